@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/PrinceNorin/rburlshortener/service"
 	"github.com/PrinceNorin/rburlshortener/transport"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
 var (
@@ -22,41 +26,113 @@ var (
 )
 
 func main() {
-	// connect to sqlite database
-	db, err := gorm.Open(sqlite.Open("database.sqlite"), &gorm.Config{})
-	checkError(err)
-	// save created_at as UTC
-	db.NowFunc = func() time.Time {
-		return time.Now().UTC()
-	}
-	// create short_urls table. ideally we want to integrate
-	// with some sort of migration management tool
-	// but we skip it in this example for simplicity
-	err = initSchema(db)
+	checkError(fs.Parse(os.Args[1:]))
+	if fs.Arg(0) == "migrate" {
+		runMigrate(fs.Args()[1:])
+		return
+	}
+
+	// open storage selected by DATABASE_URL, default to sqlite file
+	store, err := openStorage(os.Getenv("DATABASE_URL"))
 	checkError(err)
+	// refuse to serve until schema is up to date
+	checkError(checkSchema(store))
+
+	// stay below server write timeout so timed out requests still get a response
+	requestTimeout := 5 * time.Second
 
 	// Load required environment variables
 	host := env("SERVER_HOST")
-	adminToken := env("ADMIN_TOKEN")
-	// comma separated pattern of blacklist
-	// normally should have api to manage blacklist
-	blacklistPatterns := loadBlacklist()
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	// comma separated name:token pairs of admins recorded on changes they make
+	adminTokens, err := transport.ParseAdminTokens(os.Getenv("ADMIN_TOKENS"))
+	checkError(err)
+	if adminToken == "" && len(adminTokens) == 0 {
+		checkError(fmt.Errorf("missing env [ADMIN_TOKEN] or [ADMIN_TOKENS]"))
+	}
+	// comma separated pattern of blacklist applied along with rules managed by api
+	blacklistPatterns := loadPatterns("BLACKLIST")
+	// comma separated pattern of allowlist, only matching urls can be shortened when set
+	allowlistPatterns := loadPatterns("ALLOWLIST")
 
 	// build repository
-	repo := service.NewURLShortenerRepository(db)
-	// adding cache layer
-	repo = service.WithCache(repo, service.NewMemoryCacheStore())
+	repo := store.repo
+	// buffer hit counts so redirects don't wait on database write
+	bufferedRepo := service.WithBufferedHitCount(repo)
+	repo = bufferedRepo
+	// adding cache layer, shared redis when CACHE_URL is set otherwise
+	// in memory LRU bounded by CACHE_MAX_ENTRIES and CACHE_MAX_BYTES
+	cacheStore, err := openCacheStore(os.Getenv("CACHE_URL"))
+	checkError(err)
+	// busted codes are broadcast to other instances when CACHE_INVALIDATION_URL is set
+	bus, err := openInvalidationBus(os.Getenv("CACHE_INVALIDATION_URL"))
+	checkError(err)
+	cacheOption := loadCacheRepositoryOption()
+	cacheOption.Bus = bus
+	cacheOption.LookupTimeout = requestTimeout
+	cachedRepo := service.WithCache(repo, cacheStore, cacheOption)
+	repo = cachedRepo
 
 	// build service
-	svc := service.NewURLShortener(repo)
-	// adding blacklist check
-	svc, err = service.WithBlacklist(svc, blacklistPatterns)
+	svc := service.NewURLShortener(repo, service.ServiceOption{
+		CodeGenerator: loadCodeGenerator(store.counter),
+	})
+	// adding blacklist check, rules are managed at runtime when stored in sql database
+	var blacklist service.Blacklist
+	var matchers service.BlacklistMatchers
+	if store.db != nil {
+		blacklist, err = service.NewBlacklist(service.NewBlacklistRepository(store.db), blacklistPatterns)
+		checkError(err)
+		matchers = append(matchers, blacklist)
+	} else if len(blacklistPatterns) > 0 {
+		static, err := service.NewBlacklistMatcher(blacklistPatterns)
+		checkError(err)
+		matchers = append(matchers, static)
+	}
+	// third party blocklists stored in BLACKLIST_FEEDS_DIR, reloaded when modified
+	var feeds service.BlacklistFeeds
+	if dir := os.Getenv("BLACKLIST_FEEDS_DIR"); dir != "" {
+		feeds, err = service.NewBlacklistFeeds(dir, loadBlacklistFeedOption())
+		checkError(err)
+		matchers = append(matchers, feeds)
+	}
+	// existing short urls are flagged periodically when new rules match them
+	var scanner service.BlacklistScanner
+	if len(matchers) > 0 {
+		svc = service.WithBlacklistMatcher(svc, matchers)
+		scanner = service.NewBlacklistScanner(repo, matchers, loadBlacklistScanOption())
+	}
+	// blacklist rules take precedence over allowlist
+	if len(allowlistPatterns) > 0 {
+		svc, err = service.WithAllowlist(svc, allowlistPatterns)
+		checkError(err)
+	}
+
+	// click tracking needs sql database, client ips are hashed with the required
+	// IP_HASH_SALT as unsalted hashes of ips are easily reversed.
+	// events are written in batch so redirects don't wait on database write
+	var analytics service.Analytics
+	var clickRepo service.BufferedClickEventRepository
+	if store.db != nil {
+		clickRepo = service.WithBufferedClickEvents(service.NewClickEventRepository(store.db))
+		analytics = service.NewAnalytics(clickRepo, env("IP_HASH_SALT"))
+	}
+	// X-Forwarded-For is only used when sent by one of TRUSTED_PROXIES
+	trustedProxies, err := loadTrustedProxies()
 	checkError(err)
 
 	h := transport.NewHTTPHandler(transport.HTTPConfig{
-		Service:    svc,
-		ServerHost: host,
-		AdminToken: adminToken,
+		Service:          svc,
+		Analytics:        analytics,
+		Cache:            cachedRepo,
+		Blacklist:        blacklist,
+		ServerHost:       host,
+		AdminToken:       adminToken,
+		AdminTokens:      adminTokens,
+		BlacklistScanner: scanner,
+		BlacklistFeeds:   feeds,
+		RequestTimeout:   requestTimeout,
+		TrustedProxies:   trustedProxies,
 	})
 	s := &http.Server{
 		Handler:      h,
@@ -65,15 +141,51 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	logger.Printf("Listening to: http://127.0.0.1:%d", *port)
-	if err := s.ListenAndServe(); err != nil {
-		logger.Fatalf("Error: %v", err)
-	}
-}
+	go func() {
+		logger.Printf("Listening to: http://127.0.0.1:%d", *port)
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Error: %v", err)
+		}
+	}()
+
+	// wait for shutdown signal then drain in-flight requests
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
 
-func initSchema(db *gorm.DB) (err error) {
-	err = db.AutoMigrate(&service.ShortURL{})
-	return
+	logger.Println("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Printf("Error: %v", err)
+	}
+	if scanner != nil {
+		scanner.Close()
+	}
+	if blacklist != nil {
+		blacklist.Close()
+	}
+	if feeds != nil {
+		feeds.Close()
+	}
+	// write pending hit counts and click events before exit
+	if err := bufferedRepo.Close(); err != nil {
+		logger.Printf("Error: %v", err)
+	}
+	if clickRepo != nil {
+		if err := clickRepo.Close(); err != nil {
+			logger.Printf("Error: %v", err)
+		}
+	}
+	if err := store.close(); err != nil {
+		logger.Printf("Error: %v", err)
+	}
+	if err := bus.Close(); err != nil {
+		logger.Printf("Error: %v", err)
+	}
+	if err := cacheStore.Close(); err != nil {
+		logger.Printf("Error: %v", err)
+	}
 }
 
 func checkError(err error) {
@@ -90,8 +202,8 @@ func env(key string) string {
 	return val
 }
 
-func loadBlacklist() []string {
-	val := os.Getenv("BLACKLIST")
+func loadPatterns(key string) []string {
+	val := os.Getenv(key)
 	if val == "" {
 		return nil
 	}
@@ -102,3 +214,219 @@ func loadBlacklist() []string {
 	}
 	return patterns
 }
+
+// loadTrustedProxies read TRUSTED_PROXIES, comma separated ips or cidr ranges
+func loadTrustedProxies() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, val := range loadPatterns("TRUSTED_PROXIES") {
+		if !strings.Contains(val, "/") {
+			ip := net.ParseIP(val)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES address [%s]", val)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES range [%s]", val)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// closableCacheStore is a CacheStore owning resources released on shutdown
+type closableCacheStore interface {
+	service.CacheStore
+	Close() error
+}
+
+// openCacheStore build cache store from CACHE_URL in the form of
+// redis://[:password@]host:port[/db], empty url use in memory LRU
+func openCacheStore(rawURL string) (closableCacheStore, error) {
+	codec, err := loadCacheCodec()
+	if err != nil {
+		return nil, err
+	}
+	if rawURL == "" {
+		opt := loadLRUOption()
+		opt.Codec = codec
+		return service.NewLRUCacheStore(opt), nil
+	}
+
+	if codec == service.NoCodec {
+		return nil, fmt.Errorf("cache codec [none] can't be used with redis")
+	}
+	addr, opt, err := parseRedisURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return service.NewRedisCacheStore(addr, "urlshortener:", service.RedisCacheOption{
+		RedisOption: opt,
+		Codec:       codec,
+	}), nil
+}
+
+// loadCacheCodec read CACHE_CODEC which can be none, gob or json.
+// Empty value keep the cache store default
+func loadCacheCodec() (service.Codec, error) {
+	switch codec := os.Getenv("CACHE_CODEC"); codec {
+	case "":
+		return nil, nil
+	case "none":
+		return service.NoCodec, nil
+	case "gob":
+		return service.GobCodec, nil
+	case "json":
+		return service.JSONCodec, nil
+	default:
+		return nil, fmt.Errorf("unknown cache codec [%s]", codec)
+	}
+}
+
+// openInvalidationBus build invalidation bus from CACHE_INVALIDATION_URL,
+// a redis url like CACHE_URL. Empty url keep invalidation local
+func openInvalidationBus(rawURL string) (service.InvalidationBus, error) {
+	if rawURL == "" {
+		return service.NewLocalInvalidationBus(), nil
+	}
+
+	addr, opt, err := parseRedisURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return service.NewRedisInvalidationBus(addr, "urlshortener:invalidate", opt), nil
+}
+
+// parseRedisURL split redis://[:password@]host:port[/db] into address and option
+func parseRedisURL(rawURL string) (string, service.RedisOption, error) {
+	var opt service.RedisOption
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", opt, err
+	}
+	if u.Scheme != "redis" {
+		return "", opt, fmt.Errorf("unsupported redis url scheme [%s]", u.Scheme)
+	}
+
+	if password, ok := u.User.Password(); ok {
+		opt.Password = password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		opt.DB, err = strconv.Atoi(db)
+		if err != nil {
+			return "", opt, fmt.Errorf("invalid redis db [%s]", db)
+		}
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	return addr, opt, nil
+}
+
+// loadLRUOption read cache bounds from env, zero values keep defaults
+func loadLRUOption() service.LRUOption {
+	var opt service.LRUOption
+	if val := os.Getenv("CACHE_MAX_ENTRIES"); val != "" {
+		v, err := strconv.Atoi(val)
+		checkError(err)
+		opt.MaxEntries = v
+	}
+	if val := os.Getenv("CACHE_MAX_BYTES"); val != "" {
+		v, err := strconv.ParseInt(val, 10, 64)
+		checkError(err)
+		opt.MaxBytes = v
+	}
+	return opt
+}
+
+// loadCacheRepositoryOption read caching policy from CACHE_* duration env,
+// zero values keep defaults
+func loadCacheRepositoryOption() service.CacheRepositoryOption {
+	duration := func(key string) time.Duration {
+		val := os.Getenv(key)
+		if val == "" {
+			return 0
+		}
+		d, err := time.ParseDuration(val)
+		checkError(err)
+		return d
+	}
+
+	return service.CacheRepositoryOption{
+		TTL:                  duration("CACHE_TTL"),
+		NotFoundTTL:          duration("CACHE_NOT_FOUND_TTL"),
+		Jitter:               duration("CACHE_TTL_JITTER"),
+		StaleWhileRevalidate: duration("CACHE_STALE_WHILE_REVALIDATE"),
+	}
+}
+
+// loadBlacklistScanOption read BLACKLIST_SCAN_INTERVAL as a duration and
+// BLACKLIST_SCAN_DISABLE to soft delete matching short urls
+func loadBlacklistScanOption() service.BlacklistScanOption {
+	var opt service.BlacklistScanOption
+	if val := os.Getenv("BLACKLIST_SCAN_INTERVAL"); val != "" {
+		d, err := time.ParseDuration(val)
+		checkError(err)
+		opt.Interval = d
+	}
+	if val := os.Getenv("BLACKLIST_SCAN_DISABLE"); val != "" {
+		v, err := strconv.ParseBool(val)
+		checkError(err)
+		opt.Disable = v
+	}
+	return opt
+}
+
+// loadBlacklistFeedOption read BLACKLIST_FEEDS_RELOAD_INTERVAL as a duration
+func loadBlacklistFeedOption() service.BlacklistFeedOption {
+	var opt service.BlacklistFeedOption
+	if val := os.Getenv("BLACKLIST_FEEDS_RELOAD_INTERVAL"); val != "" {
+		d, err := time.ParseDuration(val)
+		checkError(err)
+		opt.ReloadInterval = d
+	}
+	return opt
+}
+
+// loadCodeGenerator build short code generator from SHORT_CODE_* env.
+// SHORT_CODE_STRATEGY can be random (default), sequential or hashids, counter
+// based strategies need counter persisted by the storage so instances and
+// restarts don't issue the same codes
+func loadCodeGenerator(counter service.Counter) service.CodeGenerator {
+	length := service.DEFAULT_SHORT_CODE_LENGTH
+	if val := os.Getenv("SHORT_CODE_LENGTH"); val != "" {
+		v, err := strconv.Atoi(val)
+		checkError(err)
+		length = v
+	}
+
+	strategy := os.Getenv("SHORT_CODE_STRATEGY")
+	if (strategy == "sequential" || strategy == "hashids") && counter == nil {
+		checkError(fmt.Errorf("short code strategy [%s] needs a sql or bolt storage", strategy))
+	}
+
+	switch strategy {
+	case "", "random":
+		alphabet := os.Getenv("SHORT_CODE_ALPHABET")
+		if alphabet == "" {
+			alphabet = service.Base64URLAlphabet
+		}
+		gen, err := service.NewRandomCodeGenerator(length, alphabet)
+		checkError(err)
+		return gen
+	case "sequential":
+		return service.NewSequentialCodeGenerator(counter)
+	case "hashids":
+		return service.NewHashidsCodeGenerator(counter, os.Getenv("SHORT_CODE_SALT"), length)
+	default:
+		checkError(fmt.Errorf("unknown short code strategy [%s]", strategy))
+		return nil
+	}
+}
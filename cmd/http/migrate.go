@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/PrinceNorin/rburlshortener/migrations"
+)
+
+var errNoDatabase = errors.New("migrations only apply to sql databases")
+
+// runMigrate handle `migrate up`, `migrate down [steps]` and `migrate status`
+func runMigrate(args []string) {
+	store, err := openStorage(os.Getenv("DATABASE_URL"))
+	checkError(err)
+	defer store.close()
+	if store.db == nil {
+		checkError(errNoDatabase)
+	}
+
+	migrator, err := migrations.NewMigrator(store.db)
+	checkError(err)
+	ctx := context.Background()
+
+	var command string
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			logger.Printf("Applied: %d_%s", migration.Version, migration.Name)
+		}
+		checkError(err)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			checkError(err)
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, migration := range rolledBack {
+			logger.Printf("Rolled back: %d_%s", migration.Version, migration.Name)
+		}
+		checkError(err)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		checkError(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		checkError(fmt.Errorf("usage: migrate up|down [steps]|status"))
+	}
+}
+
+// checkSchema return error when sql database has pending migrations
+func checkSchema(store *storage) error {
+	if store.db == nil {
+		return nil
+	}
+
+	migrator, err := migrations.NewMigrator(store.db)
+	if err != nil {
+		return err
+	}
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind by %d migration(s), run `migrate up` first", len(pending))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/PrinceNorin/rburlshortener/service"
+	bolt "go.etcd.io/bbolt"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// storage hold repository selected by DATABASE_URL. db is nil when
+// the backend isn't a sql database, features relying on it are disabled.
+type storage struct {
+	repo service.URLShortenerRepository
+	db   *gorm.DB
+	// counter persisted by the backend, nil for in-memory repository
+	counter service.Counter
+	close   func() error
+}
+
+// openStorage select backend from dsn
+//   - memory:// or memory://path/to/snapshot for in-memory repository
+//   - bolt://path/to/file.db for embedded bolt repository, no cgo needed
+//   - postgres:// or postgresql:// for postgres
+//   - otherwise dsn is treated as sqlite file path
+func openStorage(dsn string) (*storage, error) {
+	if strings.HasPrefix(dsn, "memory://") {
+		return openMemoryStorage(strings.TrimPrefix(dsn, "memory://"))
+	}
+	if strings.HasPrefix(dsn, "bolt://") {
+		return openBoltStorage(strings.TrimPrefix(dsn, "bolt://"))
+	}
+
+	db, err := openDatabase(dsn)
+	if err != nil {
+		return nil, err
+	}
+	// save created_at as UTC
+	db.NowFunc = func() time.Time {
+		return time.Now().UTC()
+	}
+
+	return &storage{
+		repo:    service.NewURLShortenerRepository(db),
+		db:      db,
+		counter: service.NewGormCounter(db, service.ShortCodeCounter),
+		close: func() error {
+			sqlDB, err := db.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.Close()
+		},
+	}, nil
+}
+
+// openMemoryStorage load snapshot on startup and save it on close
+// when snapshotPath is given
+func openMemoryStorage(snapshotPath string) (*storage, error) {
+	repo := service.NewMemoryRepository()
+	if snapshotPath == "" {
+		return &storage{repo: repo, close: func() error { return nil }}, nil
+	}
+
+	if err := repo.LoadSnapshot(snapshotPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return &storage{
+		repo: repo,
+		close: func() error {
+			return repo.SaveSnapshot(snapshotPath)
+		},
+	}, nil
+}
+
+func openBoltStorage(path string) (*storage, error) {
+	if path == "" {
+		path = "database.bolt"
+	}
+	// fail instead of waiting forever when another process hold the file lock
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	repo, err := service.NewBoltRepository(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &storage{
+		repo:    repo,
+		counter: service.NewBoltCounter(db, service.ShortCodeCounter),
+		close:   db.Close,
+	}, nil
+}
+
+func openDatabase(dsn string) (*gorm.DB, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	}
+	if dsn == "" {
+		dsn = "database.sqlite"
+	}
+	return gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+}
@@ -0,0 +1,246 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// sql files are named <version>_<name>.<up|down>.sql under a directory per dialect
+//
+//go:embed sql
+var sqlFiles embed.FS
+
+// Errors return from Migrator
+var (
+	ErrUnsupportedDialect = errors.New("unsupported database dialect")
+	ErrNoMigration        = errors.New("no migration to roll back")
+)
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus state of a migration in database
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator apply embedded migrations to a sql database
+type Migrator interface {
+	// Up apply every pending migration in order
+	Up(ctx context.Context) ([]*Migration, error)
+	// Down roll back the latest steps applied migrations
+	Down(ctx context.Context, steps int) ([]*Migration, error)
+	// Status list every known migration and when it was applied
+	Status(ctx context.Context) ([]*MigrationStatus, error)
+	// Pending list migrations not applied yet
+	Pending(ctx context.Context) ([]*Migration, error)
+}
+
+// schemaMigration model mapping to schema_migrations table
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// NewMigrator factory function. Migrations are picked by the dialect of db
+func NewMigrator(db *gorm.DB) (Migrator, error) {
+	migrations, err := load(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{db: db, migrations: migrations}, nil
+}
+
+type migrator struct {
+	db         *gorm.DB
+	migrations []*Migration
+}
+
+func (m *migrator) Up(ctx context.Context) ([]*Migration, error) {
+	if err := m.db.WithContext(ctx).AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []*Migration
+	for _, migration := range pending {
+		// each migration run in its own transaction so a failure
+		// leave the schema at the last successful version
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+func (m *migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	versions, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []*Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := versions[migration.Version]; !ok {
+			continue
+		}
+
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: migration.Version}).Error
+		})
+		if err != nil {
+			return rolledBack, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		rolledBack = append(rolledBack, migration)
+	}
+	if len(rolledBack) == 0 && steps > 0 {
+		return nil, ErrNoMigration
+	}
+	return rolledBack, nil
+}
+
+func (m *migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	versions, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*MigrationStatus
+	for _, migration := range m.migrations {
+		status := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := versions[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (m *migrator) Pending(ctx context.Context) ([]*Migration, error) {
+	versions, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*Migration
+	for _, migration := range m.migrations {
+		if _, ok := versions[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// appliedVersions return applied time by version. A missing schema_migrations
+// table means nothing is applied, it is left for Up to create so read-only
+// callers don't write to the database
+func (m *migrator) appliedVersions(ctx context.Context) (map[int64]time.Time, error) {
+	db := m.db.WithContext(ctx)
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return map[int64]time.Time{}, nil
+	}
+
+	var rows []*schemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	versions := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		versions[row.Version] = row.AppliedAt
+	}
+	return versions, nil
+}
+
+// load read migrations of dialect ordered by version
+func load(dialect string) ([]*Migration, error) {
+	dir := path.Join("sql", dialect)
+	entries, err := fs.ReadDir(sqlFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDialect, dialect)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		direction := path.Ext(name)
+		name = strings.TrimSuffix(name, direction)
+
+		parts := strings.SplitN(name, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+
+		content, err := fs.ReadFile(sqlFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		}
+		switch direction {
+		case ".up":
+			migration.Up = string(content)
+		case ".down":
+			migration.Down = string(content)
+		default:
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+	}
+
+	var migrations []*Migration
+	for _, migration := range byVersion {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
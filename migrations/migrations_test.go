@@ -0,0 +1,153 @@
+package migrations
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type MigratorSuite struct {
+	suite.Suite
+	db       *gorm.DB
+	migrator Migrator
+}
+
+func (suite *MigratorSuite) SetupTest() {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(suite.T().TempDir(), "test.sqlite")), &gorm.Config{})
+	suite.Require().Nil(err)
+	suite.db = db
+
+	migrator, err := NewMigrator(db)
+	suite.Require().Nil(err)
+	suite.migrator = migrator
+}
+
+func (suite *MigratorSuite) TearDownTest() {
+	sqlDB, _ := suite.db.DB()
+	sqlDB.Close()
+}
+
+func (suite *MigratorSuite) TestUp() {
+	ctx := context.Background()
+
+	pending, err := suite.migrator.Pending(ctx)
+	suite.Nil(err)
+	suite.NotEmpty(pending)
+
+	applied, err := suite.migrator.Up(ctx)
+	suite.Nil(err)
+	suite.Equal(pending, applied)
+	suite.True(suite.db.Migrator().HasTable("short_urls"))
+	suite.True(suite.db.Migrator().HasTable("click_events"))
+	suite.True(suite.db.Migrator().HasTable("blacklist_rules"))
+	suite.True(suite.db.Migrator().HasColumn("blacklist_rules", "type"))
+	suite.True(suite.db.Migrator().HasColumn("short_urls", "blocked_by"))
+	suite.True(suite.db.Migrator().HasTable("counters"))
+
+	// nothing left to apply
+	pending, err = suite.migrator.Pending(ctx)
+	suite.Nil(err)
+	suite.Empty(pending)
+	applied, err = suite.migrator.Up(ctx)
+	suite.Nil(err)
+	suite.Empty(applied)
+}
+
+func (suite *MigratorSuite) TestDown() {
+	ctx := context.Background()
+	suite.migrator.Up(ctx)
+
+	rolledBack, err := suite.migrator.Down(ctx, 4)
+	suite.Nil(err)
+	suite.Len(rolledBack, 4)
+	suite.EqualValues(7, rolledBack[0].Version)
+	suite.EqualValues(6, rolledBack[1].Version)
+	suite.EqualValues(5, rolledBack[2].Version)
+	suite.EqualValues(4, rolledBack[3].Version)
+	suite.False(suite.db.Migrator().HasTable("counters"))
+	suite.False(suite.db.Migrator().HasTable("blacklist_rules"))
+	suite.False(suite.db.Migrator().HasColumn("short_urls", "blocked_at"))
+	suite.True(suite.db.Migrator().HasTable("click_events"))
+	suite.True(suite.db.Migrator().HasTable("short_urls"))
+
+	pending, _ := suite.migrator.Pending(ctx)
+	suite.Len(pending, 4)
+
+	suite.migrator.Down(ctx, 10)
+	suite.False(suite.db.Migrator().HasTable("short_urls"))
+	_, err = suite.migrator.Down(ctx, 1)
+	suite.Equal(ErrNoMigration, err)
+}
+
+func (suite *MigratorSuite) TestStatus() {
+	ctx := context.Background()
+
+	statuses, err := suite.migrator.Status(ctx)
+	suite.Nil(err)
+	for _, status := range statuses {
+		suite.Nil(status.AppliedAt)
+	}
+	pending, err := suite.migrator.Pending(ctx)
+	suite.Nil(err)
+	suite.Len(pending, len(statuses))
+	_, err = suite.migrator.Down(ctx, 1)
+	suite.Equal(ErrNoMigration, err)
+	// reading status doesn't write to the database
+	suite.False(suite.db.Migrator().HasTable("schema_migrations"))
+
+	suite.migrator.Up(ctx)
+	statuses, err = suite.migrator.Status(ctx)
+	suite.Nil(err)
+	suite.EqualValues(1, statuses[0].Version)
+	suite.Equal("create_short_urls", statuses[0].Name)
+	for _, status := range statuses {
+		suite.NotNil(status.AppliedAt)
+	}
+}
+
+func (suite *MigratorSuite) TestExistingSchema() {
+	ctx := context.Background()
+	// schema created before migrations were introduced
+	suite.db.Exec("CREATE TABLE `short_urls` (`id` integer,`full_url` text NOT NULL,`domain` text NOT NULL,`code` text NOT NULL UNIQUE,`hit_count` integer DEFAULT 0,`expires_at` datetime,`created_at` datetime,`deleted_at` datetime,PRIMARY KEY (`id`))")
+	suite.db.Exec("CREATE INDEX `idx_short_urls_domain` ON `short_urls`(`domain`)")
+	suite.db.Exec("INSERT INTO short_urls (full_url, domain, code) VALUES ('http://example.com', 'example.com', '123')")
+
+	_, err := suite.migrator.Up(ctx)
+	suite.Nil(err)
+
+	var count int64
+	suite.db.Table("short_urls").Count(&count)
+	suite.EqualValues(1, count)
+}
+
+func TestMigrator(t *testing.T) {
+	suite.Run(t, new(MigratorSuite))
+}
+
+func TestUnsupportedDialect(t *testing.T) {
+	_, err := load("mysql")
+	if err == nil {
+		t.Error("expected error for unsupported dialect")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, dialect := range []string{"sqlite", "postgres"} {
+		migrations, err := load(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, migration := range migrations {
+			if migration.Version != int64(i+1) {
+				t.Errorf("%s: expected version: %v, got: %v", dialect, i+1, migration.Version)
+			}
+			if migration.Up == "" || migration.Down == "" {
+				t.Errorf("%s: migration %d missing up or down sql", dialect, migration.Version)
+			}
+		}
+	}
+}
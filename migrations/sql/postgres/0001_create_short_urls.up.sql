@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "short_urls" (
+  "id" bigserial,
+  "full_url" text NOT NULL,
+  "domain" text NOT NULL,
+  "code" text NOT NULL UNIQUE,
+  "hit_count" bigint DEFAULT 0,
+  "expires_at" timestamptz,
+  "created_at" timestamptz,
+  "deleted_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_short_urls_domain" ON "short_urls"("domain");
+CREATE INDEX IF NOT EXISTS "idx_short_urls_deleted_at" ON "short_urls"("deleted_at");
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "blacklist_rules" (
+  "id" bigserial,
+  "pattern" text NOT NULL,
+  "reason" text,
+  "created_by" text,
+  "created_at" timestamptz,
+  PRIMARY KEY ("id")
+);
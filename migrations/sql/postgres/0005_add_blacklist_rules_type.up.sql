@@ -0,0 +1 @@
+ALTER TABLE "blacklist_rules" ADD COLUMN IF NOT EXISTS "type" text NOT NULL DEFAULT 'regex';
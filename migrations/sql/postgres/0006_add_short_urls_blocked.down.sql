@@ -0,0 +1,2 @@
+ALTER TABLE "short_urls" DROP COLUMN IF EXISTS "blocked_by";
+ALTER TABLE "short_urls" DROP COLUMN IF EXISTS "blocked_at";
@@ -0,0 +1,2 @@
+ALTER TABLE "short_urls" ADD COLUMN IF NOT EXISTS "blocked_at" timestamptz;
+ALTER TABLE "short_urls" ADD COLUMN IF NOT EXISTS "blocked_by" text;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `short_urls` (
+  `id` integer,
+  `full_url` text NOT NULL,
+  `domain` text NOT NULL,
+  `code` text NOT NULL UNIQUE,
+  `hit_count` integer DEFAULT 0,
+  `expires_at` datetime,
+  `created_at` datetime,
+  `deleted_at` datetime,
+  PRIMARY KEY (`id`)
+);
+CREATE INDEX IF NOT EXISTS `idx_short_urls_domain` ON `short_urls`(`domain`);
+CREATE INDEX IF NOT EXISTS `idx_short_urls_deleted_at` ON `short_urls`(`deleted_at`);
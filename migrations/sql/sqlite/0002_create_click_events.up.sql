@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `click_events` (
+  `id` integer,
+  `code` text NOT NULL,
+  `referrer` text,
+  `user_agent` text,
+  `ua_family` text,
+  `ip_hash` text,
+  `accept_language` text,
+  `created_at` datetime,
+  PRIMARY KEY (`id`)
+);
+CREATE INDEX IF NOT EXISTS `idx_click_events_code_created_at` ON `click_events`(`code`,`created_at`);
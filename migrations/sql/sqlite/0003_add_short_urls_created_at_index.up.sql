@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS `idx_short_urls_created_at_id` ON `short_urls`(`created_at`,`id`);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `blacklist_rules`;
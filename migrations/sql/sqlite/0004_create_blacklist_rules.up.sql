@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `blacklist_rules` (
+  `id` integer,
+  `pattern` text NOT NULL,
+  `reason` text,
+  `created_by` text,
+  `created_at` datetime,
+  PRIMARY KEY (`id`)
+);
@@ -0,0 +1 @@
+ALTER TABLE `blacklist_rules` DROP COLUMN `type`;
@@ -0,0 +1 @@
+ALTER TABLE `blacklist_rules` ADD COLUMN `type` text NOT NULL DEFAULT 'regex';
@@ -0,0 +1,2 @@
+ALTER TABLE `short_urls` ADD COLUMN `blocked_at` datetime;
+ALTER TABLE `short_urls` ADD COLUMN `blocked_by` text;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `counters` (
+  `name` text,
+  `value` integer NOT NULL DEFAULT 0,
+  PRIMARY KEY (`name`)
+);
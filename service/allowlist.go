@@ -0,0 +1,19 @@
+package service
+
+// WithAllowlist decorate existing URLShortener so only urls matching one of
+// patterns can be shortened and redirected to, others fail with
+// ErrNotAllowedURL. Patterns use the same rule types as blacklist. Combined
+// with a blacklist, deny rules take precedence whatever the decoration order
+func WithAllowlist(svc URLShortener, patterns []string) (URLShortener, error) {
+	rules, err := compileBlacklistPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return WithAllowlistMatcher(svc, rules), nil
+}
+
+// WithAllowlistMatcher decorate existing URLShortener with allowlist checking
+// capabilty, rules of matcher may change at runtime
+func WithAllowlistMatcher(svc URLShortener, matcher BlacklistMatcher) URLShortener {
+	return withURLPolicy(svc, nil, []BlacklistMatcher{matcher})
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAllowlistURLShortener(t *testing.T) {
+	repo := new(mockRepo)
+	repo.On("CreateShortURL", mock.Anything).Return(nil)
+
+	allow := []string{"domain:example.com"}
+	deny := []string{"path:example.com/private"}
+
+	allowOnly, err := WithAllowlist(NewURLShortener(repo), allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowFirst, err := WithAllowlist(NewURLShortener(repo), allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowFirst, err = WithBlacklist(allowFirst, deny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denyFirst, err := WithBlacklist(NewURLShortener(repo), deny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denyFirst, err = WithAllowlist(denyFirst, allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		svc   URLShortener
+		input string
+		want  error
+	}{
+		{svc: allowOnly, input: "http://wiki.example.com/123", want: nil},
+		{svc: allowOnly, input: "http://other.com/?q=example.com", want: ErrNotAllowedURL},
+		{svc: allowOnly, input: "http://example.com/private", want: nil},
+		// deny rules take precedence whatever the decoration order
+		{svc: allowFirst, input: "http://example.com/123", want: nil},
+		{svc: allowFirst, input: "http://example.com/private/123", want: ErrBlockedURL},
+		{svc: allowFirst, input: "http://other.com/private", want: ErrNotAllowedURL},
+		{svc: denyFirst, input: "http://example.com/123", want: nil},
+		{svc: denyFirst, input: "http://example.com/private/123", want: ErrBlockedURL},
+		{svc: denyFirst, input: "http://other.com/private", want: ErrNotAllowedURL},
+	}
+	for _, tc := range tests {
+		_, err := tc.svc.Create(context.Background(), ShortURLInput{URL: tc.input})
+		if err != tc.want {
+			t.Errorf("%s: expected: %v, got: %v", tc.input, tc.want, err)
+		}
+	}
+}
+
+func TestAllowlistGetFullURL(t *testing.T) {
+	repo := new(mockRepo)
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123", FullURL: "http://example.com/123"}, nil)
+	repo.On("FindShortURL", "456").Return(&ShortURL{Code: "456", FullURL: "http://other.com/456"}, nil)
+	repo.On("IncreaseShortURLHitCount", mock.Anything, 1).Return(nil)
+
+	svc, err := WithAllowlist(NewURLShortener(repo), []string{"host:example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.GetFullURL(context.Background(), "123"); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	// links created before the allowlist was enabled stop redirecting
+	if _, err := svc.GetFullURL(context.Background(), "456"); err != ErrNotAllowedURL {
+		t.Errorf("expected: %v, got: %v", ErrNotAllowedURL, err)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Default number of top referrers and user agents in stats
+const DEFAULT_STATS_TOP_SIZE = 10
+
+// Errors return from analytics service
+var (
+	ErrInvalidTimeRange = newError("invalid time range", http.StatusBadRequest)
+	ErrInvalidInterval  = newError("invalid interval", http.StatusBadRequest)
+)
+
+// user agent families matched in order, first match wins
+var userAgentFamilies = []struct {
+	family   string
+	keywords []string
+}{
+	{family: "Bot", keywords: []string{"bot", "crawler", "spider", "slurp"}},
+	{family: "curl", keywords: []string{"curl/"}},
+	{family: "Edge", keywords: []string{"edg/", "edge/"}},
+	{family: "Opera", keywords: []string{"opr/", "opera"}},
+	{family: "Firefox", keywords: []string{"firefox/", "fxios/"}},
+	{family: "Chrome", keywords: []string{"chrome/", "crios/"}},
+	{family: "Safari", keywords: []string{"safari/"}},
+	{family: "Internet Explorer", keywords: []string{"msie ", "trident/"}},
+}
+
+// ClickInput describe a single visit of a short url
+type ClickInput struct {
+	Referrer       string
+	UserAgent      string
+	ClientIP       string
+	AcceptLanguage string
+}
+
+// FindClicksParams used to get/filter click events
+type FindClicksParams struct {
+	Offset int64
+	Size   int64
+	Filter *ClickFilterParams
+}
+
+// ClickResult type returned by FindClicks
+type ClickResult struct {
+	Data       []*ClickEvent
+	TotalCount int64
+}
+
+// Analytics public service interface
+type Analytics interface {
+	// RecordClick store a click event of a short url
+	RecordClick(ctx context.Context, code string, input ClickInput) error
+	// FindClicks return a list of click events of a short url
+	FindClicks(ctx context.Context, code string, params *FindClicksParams) (*ClickResult, error)
+	// Stats return click statistics of a short url
+	Stats(ctx context.Context, code string, params StatsParams) (*ClickStats, error)
+}
+
+// NewAnalytics factory function. Client IPs are hashed with ipSalt
+// before they are stored, it must be a secret as ips are few enough
+// to be brute forced.
+func NewAnalytics(repo ClickEventRepository, ipSalt string) Analytics {
+	return &analytics{repo: repo, ipSalt: ipSalt}
+}
+
+type analytics struct {
+	repo   ClickEventRepository
+	ipSalt string
+}
+
+func (s *analytics) RecordClick(ctx context.Context, code string, input ClickInput) error {
+	event := ClickEvent{
+		Code:           code,
+		Referrer:       input.Referrer,
+		UserAgent:      input.UserAgent,
+		UAFamily:       userAgentFamily(input.UserAgent),
+		AcceptLanguage: input.AcceptLanguage,
+	}
+	if input.ClientIP != "" {
+		event.IPHash = s.hashIP(input.ClientIP)
+	}
+	return s.repo.CreateClickEvent(ctx, &event)
+}
+
+func (s *analytics) FindClicks(ctx context.Context, code string, params *FindClicksParams) (*ClickResult, error) {
+	if err := validateTimeRange(params.Filter); err != nil {
+		return nil, err
+	}
+
+	events, count, err := s.repo.ListClickEvents(ctx, code, params.Offset, params.Size, params.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClickResult{
+		Data:       events,
+		TotalCount: count,
+	}, nil
+}
+
+func (s *analytics) Stats(ctx context.Context, code string, params StatsParams) (*ClickStats, error) {
+	switch params.Interval {
+	case "":
+		params.Interval = IntervalDay
+	case IntervalHour, IntervalDay, IntervalWeek:
+	default:
+		return nil, ErrInvalidInterval
+	}
+	if err := validateTimeRange(params.Filter); err != nil {
+		return nil, err
+	}
+	if params.TopSize <= 0 {
+		params.TopSize = DEFAULT_STATS_TOP_SIZE
+	}
+	return s.repo.ClickStats(ctx, code, params)
+}
+
+func (s *analytics) hashIP(ip string) string {
+	sum := sha256.Sum256([]byte(s.ipSalt + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+func validateTimeRange(f *ClickFilterParams) error {
+	if f != nil && f.From != nil && f.To != nil && f.From.After(*f.To) {
+		return ErrInvalidTimeRange
+	}
+	return nil
+}
+
+func userAgentFamily(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown"
+	}
+	ua := strings.ToLower(userAgent)
+	for _, f := range userAgentFamilies {
+		for _, keyword := range f.keywords {
+			if strings.Contains(ua, keyword) {
+				return f.family
+			}
+		}
+	}
+	return "Other"
+}
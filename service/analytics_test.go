@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type mockClickRepo struct {
+	mock.Mock
+}
+
+func (m *mockClickRepo) CreateClickEvent(ctx context.Context, event *ClickEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
+
+func (m *mockClickRepo) CreateClickEvents(ctx context.Context, events []*ClickEvent) error {
+	args := m.Called(events)
+	return args.Error(0)
+}
+
+func (m *mockClickRepo) ListClickEvents(ctx context.Context, code string, offset, size int64, filters ...*ClickFilterParams) ([]*ClickEvent, int64, error) {
+	arguments := []interface{}{code, offset, size}
+	for _, f := range filters {
+		arguments = append(arguments, f)
+	}
+
+	args := m.Called(arguments...)
+	return args.Get(0).([]*ClickEvent), int64(args.Int(1)), args.Error(2)
+}
+
+func (m *mockClickRepo) ClickStats(ctx context.Context, code string, params StatsParams) (*ClickStats, error) {
+	args := m.Called(code, params)
+	if args.Get(0) != nil {
+		return args.Get(0).(*ClickStats), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func TestAnalyticsRecordClick(t *testing.T) {
+	repo := new(mockClickRepo)
+	svc := NewAnalytics(repo, "salt")
+
+	var recorded []*ClickEvent
+	repo.On("CreateClickEvent", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		recorded = append(recorded, args.Get(0).(*ClickEvent))
+	})
+
+	input := ClickInput{
+		Referrer:       "http://referrer.com",
+		UserAgent:      "curl/7.79.1",
+		ClientIP:       "127.0.0.1",
+		AcceptLanguage: "en-US",
+	}
+	if err := svc.RecordClick(context.Background(), "123", input); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RecordClick(context.Background(), "123", ClickInput{ClientIP: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RecordClick(context.Background(), "123", ClickInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := recorded[0]
+	if e.Code != "123" || e.Referrer != input.Referrer || e.UserAgent != input.UserAgent || e.AcceptLanguage != input.AcceptLanguage {
+		t.Errorf("unexpected click event: %+v", e)
+	}
+	if e.UAFamily != "curl" {
+		t.Errorf("unexpected click event: %+v", e)
+	}
+	if e.IPHash == "" || e.IPHash == input.ClientIP {
+		t.Errorf("expected client ip to be hashed, got: %v", e.IPHash)
+	}
+	if recorded[1].IPHash != e.IPHash {
+		t.Error("expected same client ip to produce same hash")
+	}
+	if recorded[2].IPHash != "" {
+		t.Error("expected empty hash without client ip")
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestAnalyticsFindClicks(t *testing.T) {
+	repo := new(mockClickRepo)
+	svc := NewAnalytics(repo, "salt")
+
+	from := time.Now().Add(-1 * time.Hour)
+	to := time.Now()
+	filter := &ClickFilterParams{From: &from, To: &to}
+	events := []*ClickEvent{{Code: "123"}, {Code: "123"}}
+	repo.On("ListClickEvents", "123", int64(0), int64(30), filter).Return(events, 2, nil)
+
+	r, err := svc.FindClicks(context.Background(), "123", &FindClicksParams{Size: 30, Filter: filter})
+	if err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	if len(r.Data) != 2 || r.TotalCount != 2 {
+		t.Errorf("unexpected result: %+v", r)
+	}
+
+	_, err = svc.FindClicks(context.Background(), "123", &FindClicksParams{
+		Size:   30,
+		Filter: &ClickFilterParams{From: &to, To: &from},
+	})
+	if err != ErrInvalidTimeRange {
+		t.Errorf("expected: %v, got: %v", ErrInvalidTimeRange, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestAnalyticsStats(t *testing.T) {
+	repo := new(mockClickRepo)
+	svc := NewAnalytics(repo, "salt")
+
+	stats := &ClickStats{TotalClicks: 1}
+	repo.On("ClickStats", "123", StatsParams{Interval: IntervalDay, TopSize: DEFAULT_STATS_TOP_SIZE}).Return(stats, nil)
+	repo.On("ClickStats", "123", StatsParams{Interval: IntervalHour, TopSize: 5}).Return(stats, nil)
+
+	from := time.Now()
+	to := from.Add(-1 * time.Hour)
+
+	type test struct {
+		input StatsParams
+		want  error
+	}
+
+	tests := []test{
+		{input: StatsParams{}, want: nil},
+		{input: StatsParams{Interval: IntervalHour, TopSize: 5}, want: nil},
+		{input: StatsParams{Interval: "minute"}, want: ErrInvalidInterval},
+		{input: StatsParams{Filter: &ClickFilterParams{From: &from, To: &to}}, want: ErrInvalidTimeRange},
+	}
+	for _, tc := range tests {
+		r, err := svc.Stats(context.Background(), "123", tc.input)
+		if err != tc.want {
+			t.Errorf("expected: %v, got: %v", tc.want, err)
+		}
+		if err == nil && r != stats {
+			t.Errorf("unexpected stats: %+v", r)
+		}
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestUserAgentFamily(t *testing.T) {
+	tests := map[string]string{
+		"": "Unknown",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0.4664.110 Safari/537.36":                     "Chrome",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0.4664.110 Safari/537.36 Edg/96.0.1054":       "Edge",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:95.0) Gecko/20100101 Firefox/95.0":                                                      "Firefox",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 15_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.2 Mobile/15E148 Safari/604.1": "Safari",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                                                "Bot",
+		"curl/7.79.1":  "curl",
+		"HTTPie/2.6.0": "Other",
+	}
+	for ua, want := range tests {
+		if got := userAgentFamily(ua); got != want {
+			t.Errorf("expected: %v, got: %v for %q", want, got, ua)
+		}
+	}
+}
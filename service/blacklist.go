@@ -1,38 +1,150 @@
 package service
 
-import "regexp"
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default interval between reloads of blacklist rules
+const DEFAULT_BLACKLIST_RELOAD_INTERVAL = 30 * time.Second
+
+// Errors return from blacklist service
+var (
+	ErrInvalidPattern = newError("invalid pattern", http.StatusBadRequest)
+)
+
+// BlacklistMatcher find the rule matching a url
+type BlacklistMatcher interface {
+	// Match return the first rule matching url, nil when none does
+	Match(url string) *BlacklistRule
+}
+
+// BlacklistRuleInput used to create a BlacklistRule
+type BlacklistRuleInput struct {
+	// Type of rule, default to RuleTypeRegex
+	Type      string
+	Pattern   string
+	Reason    string
+	CreatedBy string
+}
+
+// BlacklistOption to modify blacklist behavior
+type BlacklistOption struct {
+	// ReloadInterval between reloads of rules changed by other instances
+	ReloadInterval time.Duration
+}
+
+// Blacklist public service interface managing blacklist rules at runtime
+type Blacklist interface {
+	BlacklistMatcher
+	// Rules return rules stored in repository
+	Rules(ctx context.Context) ([]*BlacklistRule, error)
+	// AddRule store a rule which apply immediately
+	AddRule(ctx context.Context, input BlacklistRuleInput) (*BlacklistRule, error)
+	// DeleteRule remove a stored rule
+	DeleteRule(ctx context.Context, id int64) error
+	// Reload rules from repository
+	Reload(ctx context.Context) error
+	// Close stop periodic reloading
+	Close() error
+}
+
+// NewBlacklist factory function. Rules stored in repo are reloaded periodically
+// so changes made by other instances apply without a restart, patterns are
+// static rules applied along with them.
+func NewBlacklist(repo BlacklistRepository, patterns []string, opts ...BlacklistOption) (Blacklist, error) {
+	static, err := compileBlacklistPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &blacklist{
+		repo:     repo,
+		static:   static,
+		interval: DEFAULT_BLACKLIST_RELOAD_INTERVAL,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if len(opts) > 0 && opts[0].ReloadInterval > 0 {
+		b.interval = opts[0].ReloadInterval
+	}
+	if err := b.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+
+	go b.run()
+	return b, nil
+}
+
+// NewBlacklistMatcher factory function. Rules of patterns are fixed, a pattern
+// may be prefixed with its type, eg: domain:example.com, otherwise it's a regex
+func NewBlacklistMatcher(patterns []string) (BlacklistMatcher, error) {
+	rules, err := compileBlacklistPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
 
 // WithBlacklist decorate existing URLShortener with blacklist checking capabilty
 func WithBlacklist(svc URLShortener, patterns []string) (URLShortener, error) {
-	rxPatterns := []*regexp.Regexp{}
-	for _, pattern := range patterns {
-		r, err := regexp.Compile(pattern)
-		if err != nil {
-			return nil, err
-		}
-		rxPatterns = append(rxPatterns, r)
+	matcher, err := NewBlacklistMatcher(patterns)
+	if err != nil {
+		return nil, err
 	}
+	return WithBlacklistMatcher(svc, matcher), nil
+}
 
-	return &blacklistUrlShortener{
+// WithBlacklistMatcher decorate existing URLShortener with blacklist checking
+// capabilty, rules of matcher may change at runtime
+func WithBlacklistMatcher(svc URLShortener, matcher BlacklistMatcher) URLShortener {
+	return withURLPolicy(svc, []BlacklistMatcher{matcher}, nil)
+}
+
+// withURLPolicy add deny and allow matchers to svc. Matchers of an already
+// decorated service are merged so precedence doesn't depend on decoration order
+func withURLPolicy(svc URLShortener, deny, allow []BlacklistMatcher) URLShortener {
+	if s, ok := svc.(*policyUrlShortener); ok {
+		return &policyUrlShortener{
+			URLShortener: s.URLShortener,
+			deny:         append(s.deny[:len(s.deny):len(s.deny)], deny...),
+			allow:        append(s.allow[:len(s.allow):len(s.allow)], allow...),
+		}
+	}
+	return &policyUrlShortener{
 		URLShortener: svc,
-		patterns:     rxPatterns,
-	}, nil
+		deny:         deny,
+		allow:        allow,
+	}
 }
 
-type blacklistUrlShortener struct {
+type policyUrlShortener struct {
 	URLShortener
-	patterns []*regexp.Regexp
+	deny  []BlacklistMatcher
+	allow []BlacklistMatcher
 }
 
-func (s *blacklistUrlShortener) Create(input ShortURLInput) (string, error) {
+func (s *policyUrlShortener) Create(ctx context.Context, input ShortURLInput) (string, error) {
 	if err := s.validate(input.URL); err != nil {
 		return "", err
 	}
-	return s.URLShortener.Create(input)
+	return s.URLShortener.Create(ctx, input)
 }
 
-func (s *blacklistUrlShortener) GetFullURL(code string) (string, error) {
-	fullURL, err := s.URLShortener.GetFullURL(code)
+func (s *policyUrlShortener) Update(ctx context.Context, code string, input UpdateShortURLInput) (*ShortURL, error) {
+	if input.URL != nil {
+		if err := s.validate(*input.URL); err != nil {
+			return nil, err
+		}
+	}
+	return s.URLShortener.Update(ctx, code, input)
+}
+
+func (s *policyUrlShortener) GetFullURL(ctx context.Context, code string) (string, error) {
+	fullURL, err := s.URLShortener.GetFullURL(ctx, code)
 	if err != nil {
 		return "", err
 	}
@@ -42,11 +154,140 @@ func (s *blacklistUrlShortener) GetFullURL(code string) (string, error) {
 	return fullURL, nil
 }
 
-func (s *blacklistUrlShortener) validate(url string) error {
-	for _, pattern := range s.patterns {
-		if pattern.MatchString(url) {
+// validate apply deny rules first so a url matching both a deny and an allow
+// rule is blocked. When there are allow rules url must match one of them
+func (s *policyUrlShortener) validate(url string) error {
+	for _, m := range s.deny {
+		if m.Match(url) != nil {
 			return ErrBlockedURL
 		}
 	}
+	if len(s.allow) == 0 {
+		return nil
+	}
+	for _, m := range s.allow {
+		if m.Match(url) != nil {
+			return nil
+		}
+	}
+	return ErrNotAllowedURL
+}
+
+type blacklist struct {
+	repo     BlacklistRepository
+	static   compiledRules
+	interval time.Duration
+
+	mux   sync.RWMutex
+	rules compiledRules
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func (b *blacklist) Match(url string) *BlacklistRule {
+	u := parseBlacklistURL(url)
+	if rule := b.static.match(u); rule != nil {
+		return rule
+	}
+
+	b.mux.RLock()
+	rules := b.rules
+	b.mux.RUnlock()
+	return rules.match(u)
+}
+
+func (b *blacklist) Rules(ctx context.Context) ([]*BlacklistRule, error) {
+	return b.repo.ListBlacklistRules(ctx)
+}
+
+func (b *blacklist) AddRule(ctx context.Context, input BlacklistRuleInput) (*BlacklistRule, error) {
+	rule := &BlacklistRule{
+		Type:      strings.ToLower(strings.TrimSpace(input.Type)),
+		Pattern:   strings.TrimSpace(input.Pattern),
+		Reason:    strings.TrimSpace(input.Reason),
+		CreatedBy: strings.TrimSpace(input.CreatedBy),
+	}
+	if rule.Type == "" {
+		rule.Type = RuleTypeRegex
+	}
+	if rule.Pattern == "" {
+		return nil, ErrInvalidPattern
+	}
+	compiled, err := compileBlacklistRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.repo.CreateBlacklistRule(ctx, rule); err != nil {
+		return nil, err
+	}
+
+	b.mux.Lock()
+	b.rules = append(b.rules[:len(b.rules):len(b.rules)], compiled)
+	b.mux.Unlock()
+	return rule, nil
+}
+
+func (b *blacklist) DeleteRule(ctx context.Context, id int64) error {
+	if err := b.repo.DeleteBlacklistRule(ctx, id); err != nil {
+		return notFound(err)
+	}
+
+	b.mux.Lock()
+	rules := make(compiledRules, 0, len(b.rules))
+	for _, r := range b.rules {
+		if r.rule.Id != id {
+			rules = append(rules, r)
+		}
+	}
+	b.rules = rules
+	b.mux.Unlock()
+	return nil
+}
+
+// Reload replace rules with the ones stored in repository. A stored rule
+// which doesn't compile, only possible when edited outside the api, is skipped
+func (b *blacklist) Reload(ctx context.Context) error {
+	stored, err := b.repo.ListBlacklistRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	rules := make(compiledRules, 0, len(stored))
+	for _, rule := range stored {
+		if r, err := compileBlacklistRule(rule); err == nil {
+			rules = append(rules, r)
+		}
+	}
+
+	b.mux.Lock()
+	b.rules = rules
+	b.mux.Unlock()
 	return nil
 }
+
+func (b *blacklist) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		<-b.stopped
+	})
+	return nil
+}
+
+func (b *blacklist) run() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// keep current rules when repository is unavailable
+			b.Reload(context.Background())
+		case <-b.done:
+			return
+		}
+	}
+}
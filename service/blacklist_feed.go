@@ -0,0 +1,371 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default interval between reloads of blacklist feeds
+const DEFAULT_BLACKLIST_FEED_RELOAD_INTERVAL = 5 * time.Minute
+
+// host names of hosts files which are not blocked entries
+var hostsFileLocalNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+}
+
+// BlacklistFeedOption to modify blacklist feeds behavior
+type BlacklistFeedOption struct {
+	// ReloadInterval between checks of feed files for changes
+	ReloadInterval time.Duration
+}
+
+// BlacklistFeedStats entries of a feed file
+type BlacklistFeedStats struct {
+	File    string `json:"file"`
+	Loaded  int64  `json:"loaded"`
+	Invalid int64  `json:"invalid"`
+	// Error of the last load, rules previously loaded from the file are kept
+	Error    string    `json:"error,omitempty"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+// BlacklistFeedsStats entries of every feed file
+type BlacklistFeedsStats struct {
+	Loaded   int64                 `json:"loaded"`
+	Invalid  int64                 `json:"invalid"`
+	Feeds    []*BlacklistFeedStats `json:"feeds"`
+	LoadedAt time.Time             `json:"loadedAt"`
+}
+
+// BlacklistFeeds is a BlacklistMatcher over third party blocklists stored in
+// a directory. Each line is either a hosts file entry (0.0.0.0 example.com),
+// an Adblock rule (||example.com^) or a plain host name. Adblock rules block
+// subdomains as well, other formats block the host only.
+type BlacklistFeeds interface {
+	BlacklistMatcher
+	// Stats return number of loaded and invalid entries per file
+	Stats() *BlacklistFeedsStats
+	// Reload files changed since last load
+	Reload(ctx context.Context) error
+	// Close stop periodic reloading
+	Close() error
+}
+
+// NewBlacklistFeeds factory function. Every regular file of dir is loaded,
+// then reloaded periodically when modified
+func NewBlacklistFeeds(dir string, opts ...BlacklistFeedOption) (BlacklistFeeds, error) {
+	f := &blacklistFeeds{
+		dir:      dir,
+		interval: DEFAULT_BLACKLIST_FEED_RELOAD_INTERVAL,
+		files:    map[string]*feedFile{},
+		rules:    &hostRules{},
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if len(opts) > 0 && opts[0].ReloadInterval > 0 {
+		f.interval = opts[0].ReloadInterval
+	}
+	if err := f.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+
+	go f.run()
+	return f, nil
+}
+
+// feedEntry a valid line of a feed
+type feedEntry struct {
+	ruleType string
+	host     string
+}
+
+// feedFile last successful load of a file
+type feedFile struct {
+	entries []feedEntry
+	modTime time.Time
+	size    int64
+	stats   *BlacklistFeedStats
+}
+
+type blacklistFeeds struct {
+	dir      string
+	interval time.Duration
+
+	// reloadMux serialize reloads, files are only used while holding it
+	reloadMux sync.Mutex
+	files     map[string]*feedFile
+
+	mux   sync.RWMutex
+	rules *hostRules
+	stats *BlacklistFeedsStats
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func (f *blacklistFeeds) Match(url string) *BlacklistRule {
+	f.mux.RLock()
+	rules := f.rules
+	f.mux.RUnlock()
+	return rules.match(parseBlacklistURL(url))
+}
+
+func (f *blacklistFeeds) Stats() *BlacklistFeedsStats {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return f.stats
+}
+
+// Reload parse files added or modified since last load. A file which can't
+// be read keep rules of its previous load
+func (f *blacklistFeeds) Reload(ctx context.Context) error {
+	f.reloadMux.Lock()
+	defer f.reloadMux.Unlock()
+
+	dirEntries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*feedFile{}
+	var names []string
+	for _, entry := range dirEntries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+
+		prev := f.files[name]
+		if prev != nil && prev.modTime.Equal(info.ModTime()) && prev.size == info.Size() {
+			files[name] = prev
+			continue
+		}
+		file, err := loadFeedFile(filepath.Join(f.dir, name), name, info)
+		if err != nil {
+			if prev == nil {
+				prev = &feedFile{stats: &BlacklistFeedStats{File: name}}
+			}
+			stats := *prev.stats
+			stats.Error = err.Error()
+			file = &feedFile{entries: prev.entries, stats: &stats}
+		}
+		files[name] = file
+	}
+	sort.Strings(names)
+
+	// earlier files win when an entry is listed several times
+	rules := &hostRules{hosts: map[string]*BlacklistRule{}, domains: map[string]*BlacklistRule{}}
+	stats := &BlacklistFeedsStats{Feeds: []*BlacklistFeedStats{}, LoadedAt: time.Now().UTC()}
+	for _, name := range names {
+		file := files[name]
+		reason := "feed " + name
+		for _, entry := range file.entries {
+			rules.add(&BlacklistRule{Type: entry.ruleType, Pattern: entry.host, Reason: reason})
+		}
+		stats.Loaded += file.stats.Loaded
+		stats.Invalid += file.stats.Invalid
+		stats.Feeds = append(stats.Feeds, file.stats)
+	}
+	f.files = files
+
+	f.mux.Lock()
+	f.rules = rules
+	f.stats = stats
+	f.mux.Unlock()
+	return nil
+}
+
+func (f *blacklistFeeds) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+		<-f.stopped
+	})
+	return nil
+}
+
+func (f *blacklistFeeds) run() {
+	defer close(f.stopped)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// keep current rules when directory is unavailable
+			f.Reload(context.Background())
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func loadFeedFile(path, name string, info os.FileInfo) (*feedFile, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries, invalid, err := parseBlacklistFeed(r)
+	if err != nil {
+		return nil, err
+	}
+	return &feedFile{
+		entries: entries,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		stats: &BlacklistFeedStats{
+			File:     name,
+			Loaded:   int64(len(entries)),
+			Invalid:  invalid,
+			LoadedAt: time.Now().UTC(),
+		},
+	}, nil
+}
+
+// parseBlacklistFeed return valid entries of r and the number of invalid lines.
+// Blank lines, comments and Adblock headers are ignored
+func parseBlacklistFeed(r io.Reader) ([]feedEntry, int64, error) {
+	var entries []feedEntry
+	var invalid int64
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+		lineEntries, ok := parseFeedLine(line)
+		if !ok {
+			invalid++
+			continue
+		}
+		entries = append(entries, lineEntries...)
+	}
+	return entries, invalid, scanner.Err()
+}
+
+func parseFeedLine(line string) ([]feedEntry, bool) {
+	// Adblock domain anchor, rules with options or paths can't be honored
+	if strings.HasPrefix(line, "||") {
+		host, ok := parseFeedHost(strings.TrimSuffix(line[2:], "^"))
+		if !ok {
+			return nil, false
+		}
+		return []feedEntry{{ruleType: RuleTypeDomain, host: host}}, true
+	}
+
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 1:
+		host, ok := parseFeedHost(fields[0])
+		if !ok {
+			return nil, false
+		}
+		return []feedEntry{{ruleType: RuleTypeHost, host: host}}, true
+	case len(fields) > 1 && net.ParseIP(fields[0]) != nil:
+		// hosts file entry, several host names may share the address
+		var entries []feedEntry
+		for _, field := range fields[1:] {
+			if hostsFileLocalNames[strings.ToLower(field)] || net.ParseIP(field) != nil {
+				continue
+			}
+			host, ok := parseFeedHost(field)
+			if !ok {
+				return nil, false
+			}
+			entries = append(entries, feedEntry{ruleType: RuleTypeHost, host: host})
+		}
+		return entries, true
+	default:
+		return nil, false
+	}
+}
+
+// parseFeedHost is parseRuleHost also rejecting wildcards and Adblock syntax
+func parseFeedHost(host string) (string, bool) {
+	if strings.ContainsAny(host, "*^|$!,=~") {
+		return "", false
+	}
+	return parseRuleHost(host)
+}
+
+// hostRules match host and domain rules with a lookup per label of the host,
+// feeds hold far more rules than compiledRules could try one after the other
+type hostRules struct {
+	hosts   map[string]*BlacklistRule
+	domains map[string]*BlacklistRule
+}
+
+// add rule unless its host is already listed
+func (r *hostRules) add(rule *BlacklistRule) {
+	rules := r.hosts
+	if rule.Type == RuleTypeDomain {
+		rules = r.domains
+	}
+	if _, ok := rules[rule.Pattern]; !ok {
+		rules[rule.Pattern] = rule
+	}
+}
+
+func (r *hostRules) match(u *blacklistURL) *BlacklistRule {
+	if u.host == "" {
+		return nil
+	}
+	if rule, ok := r.hosts[u.host]; ok {
+		return rule
+	}
+	for host := u.host; ; {
+		if rule, ok := r.domains[host]; ok {
+			return rule
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return nil
+		}
+		host = host[i+1:]
+	}
+}
+
+// BlacklistMatchers match urls against several matchers in order
+type BlacklistMatchers []BlacklistMatcher
+
+func (matchers BlacklistMatchers) Match(url string) *BlacklistRule {
+	for _, m := range matchers {
+		if rule := m.Match(url); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBlacklistFeed(t *testing.T) {
+	feed := `# hosts file
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 evil.com www.EVIL.com # inline comment
+0.0.0.0 bad/host
+[Adblock Plus 2.0]
+! adblock comment
+||ads.example^
+||tracker.example^$third-party
+@@||allowed.example^
+xn--mnchen-3ya.de
+not a host
+`
+	entries, invalid, err := parseBlacklistFeed(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []feedEntry{
+		{ruleType: RuleTypeHost, host: "evil.com"},
+		{ruleType: RuleTypeHost, host: "www.evil.com"},
+		{ruleType: RuleTypeDomain, host: "ads.example"},
+		{ruleType: RuleTypeHost, host: "xn--mnchen-3ya.de"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected: %+v, got: %+v", expected, entries)
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("expected: %+v, got: %+v", expected[i], entries[i])
+		}
+	}
+	if invalid != 4 {
+		t.Errorf("expected: %v, got: %v", 4, invalid)
+	}
+}
+
+func TestBlacklistFeeds(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string, modTime time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	start := time.Now().Add(-time.Hour)
+	writeFile("hosts.txt", "0.0.0.0 evil.com\n0.0.0.0 bad/host\n", start)
+	writeFile("adblock.txt", "||ads.example^\n", start)
+	writeFile(".hidden", "hidden.com\n", start)
+	os.Mkdir(filepath.Join(dir, "subdir"), 0755)
+
+	feeds, err := NewBlacklistFeeds(dir, BlacklistFeedOption{ReloadInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feeds.Close()
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "http://evil.com/1", want: "evil.com"},
+		{input: "http://www.evil.com/1", want: ""},
+		{input: "http://cdn.ads.example/1.js", want: "ads.example"},
+		{input: "http://good.com/?q=evil.com", want: ""},
+		{input: "http://hidden.com", want: ""},
+	}
+	for _, tc := range tests {
+		rule := feeds.Match(tc.input)
+		if (rule == nil && tc.want != "") || (rule != nil && rule.Pattern != tc.want) {
+			t.Errorf("%s: expected: %v, got: %+v", tc.input, tc.want, rule)
+		}
+	}
+	if rule := feeds.Match("http://evil.com"); rule.Reason != "feed hosts.txt" {
+		t.Errorf("expected: %v, got: %v", "feed hosts.txt", rule.Reason)
+	}
+
+	stats := feeds.Stats()
+	if stats.Loaded != 2 || stats.Invalid != 1 || len(stats.Feeds) != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if feed := stats.Feeds[1]; feed.File != "hosts.txt" || feed.Loaded != 1 || feed.Invalid != 1 {
+		t.Errorf("unexpected feed stats: %+v", feed)
+	}
+
+	// modified and removed files are picked up on reload
+	writeFile("hosts.txt", "0.0.0.0 other.com\n", start.Add(time.Minute))
+	os.Remove(filepath.Join(dir, "adblock.txt"))
+	if err := feeds.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rule := feeds.Match("http://evil.com"); rule != nil {
+		t.Errorf("expected: %v, got: %+v", nil, rule)
+	}
+	if rule := feeds.Match("http://other.com"); rule == nil {
+		t.Errorf("expected rule matching other.com")
+	}
+	if rule := feeds.Match("http://ads.example"); rule != nil {
+		t.Errorf("expected: %v, got: %+v", nil, rule)
+	}
+	if stats := feeds.Stats(); stats.Loaded != 1 || stats.Invalid != 0 || len(stats.Feeds) != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// missing directory is an error, rules loaded previously are kept
+	os.RemoveAll(dir)
+	if err := feeds.Reload(context.Background()); err == nil {
+		t.Errorf("expected error")
+	}
+	if rule := feeds.Match("http://other.com"); rule == nil {
+		t.Errorf("expected rule matching other.com")
+	}
+}
+
+func TestBlacklistMatchers(t *testing.T) {
+	first, _ := compileBlacklistPatterns([]string{"host:evil.com"})
+	second, _ := compileBlacklistPatterns([]string{"domain:evil.com", "host:bad.com"})
+	matchers := BlacklistMatchers{first, second}
+
+	if rule := matchers.Match("http://evil.com"); rule == nil || rule.Type != RuleTypeHost {
+		t.Errorf("expected host rule, got: %+v", rule)
+	}
+	if rule := matchers.Match("http://bad.com"); rule == nil || rule.Pattern != "bad.com" {
+		t.Errorf("expected bad.com rule, got: %+v", rule)
+	}
+	if rule := matchers.Match("http://good.com"); rule != nil {
+		t.Errorf("expected: %v, got: %+v", nil, rule)
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BlacklistRepository to interact with blacklist rule data store
+type BlacklistRepository interface {
+	ListBlacklistRules(ctx context.Context) ([]*BlacklistRule, error)
+	CreateBlacklistRule(ctx context.Context, rule *BlacklistRule) error
+	DeleteBlacklistRule(ctx context.Context, id int64) error
+}
+
+// NewBlacklistRepository factory function
+func NewBlacklistRepository(db *gorm.DB) BlacklistRepository {
+	return &gormBlacklistRepository{db: db}
+}
+
+type gormBlacklistRepository struct {
+	db *gorm.DB
+}
+
+// ListBlacklistRules return rules in creation order
+func (r *gormBlacklistRepository) ListBlacklistRules(ctx context.Context) ([]*BlacklistRule, error) {
+	var rules []*BlacklistRule
+	if err := r.db.WithContext(ctx).Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *gormBlacklistRepository) CreateBlacklistRule(ctx context.Context, rule *BlacklistRule) error {
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now().UTC()
+	}
+	return r.db.WithContext(ctx).Create(rule).Error
+}
+
+func (r *gormBlacklistRepository) DeleteBlacklistRule(ctx context.Context, id int64) error {
+	result := r.db.WithContext(ctx).Delete(&BlacklistRule{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
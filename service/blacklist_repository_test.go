@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type BlacklistRepositorySuite struct {
+	suite.Suite
+	db   *gorm.DB
+	repo BlacklistRepository
+}
+
+func (suite *BlacklistRepositorySuite) SetupSuite() {
+	suite.repo = NewBlacklistRepository(suite.db)
+}
+
+func (suite *BlacklistRepositorySuite) SetupTest() {
+	suite.db.AutoMigrate(&BlacklistRule{})
+}
+
+func (suite *BlacklistRepositorySuite) TearDownTest() {
+	suite.db.Exec("DROP TABLE blacklist_rules")
+}
+
+func (suite *BlacklistRepositorySuite) TestBlacklistRules() {
+	ctx := context.Background()
+	rule1 := &BlacklistRule{Type: RuleTypeRegex, Pattern: `sample\.com`, Reason: "phishing", CreatedBy: "alice"}
+	rule2 := &BlacklistRule{Type: RuleTypeDomain, Pattern: `example.com`}
+	suite.Nil(suite.repo.CreateBlacklistRule(ctx, rule1))
+	suite.Nil(suite.repo.CreateBlacklistRule(ctx, rule2))
+	suite.NotZero(rule1.Id)
+	suite.False(rule1.CreatedAt.IsZero())
+
+	rules, err := suite.repo.ListBlacklistRules(ctx)
+	suite.Nil(err)
+	suite.Len(rules, 2)
+	suite.Equal(rule1.Id, rules[0].Id)
+	suite.Equal("phishing", rules[0].Reason)
+	suite.Equal("alice", rules[0].CreatedBy)
+	suite.Equal(RuleTypeRegex, rules[0].Type)
+	suite.Equal(RuleTypeDomain, rules[1].Type)
+
+	suite.Nil(suite.repo.DeleteBlacklistRule(ctx, rule1.Id))
+	suite.Equal(ErrRecordNotFound, suite.repo.DeleteBlacklistRule(ctx, rule1.Id))
+	rules, _ = suite.repo.ListBlacklistRules(ctx)
+	suite.Len(rules, 1)
+	suite.Equal(rule2.Id, rules[0].Id)
+}
+
+func TestBlacklistRepository(t *testing.T) {
+	for _, database := range testDatabases() {
+		t.Run(database.name, func(t *testing.T) {
+			suite.Run(t, &BlacklistRepositorySuite{db: openTestDB(t, database.dialector)})
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Types of blacklist rule
+const (
+	// RuleTypeHost match the host exactly
+	RuleTypeHost = "host"
+	// RuleTypeDomain match the host and all of its subdomains
+	RuleTypeDomain = "domain"
+	// RuleTypePath match a path prefix of a domain, eg: example.com/ads
+	RuleTypePath = "path"
+	// RuleTypeRegex match a regular expression against the whole url
+	RuleTypeRegex = "regex"
+)
+
+// ErrInvalidRuleType return when creating a rule of unknown type
+var ErrInvalidRuleType = newError("invalid rule type", http.StatusBadRequest)
+
+// hostProfile map hosts the way browsers resolve them, eg: full width letters
+// and ideographic full stop. Labels aren't validated so hosts with underscore
+// are still matched
+var hostProfile = idna.New(
+	idna.MapForLookup(),
+	idna.StrictDomainName(false),
+	idna.ValidateLabels(false),
+	idna.CheckHyphens(false),
+	idna.CheckJoiners(false),
+)
+
+// blacklistURL is a url normalized for matching
+type blacklistURL struct {
+	raw  string
+	host string
+	path string
+}
+
+// parseBlacklistURL normalize rawURL. Host is mapped to its ascii form, port
+// and userinfo are dropped. Host is empty when rawURL can't be parsed so only
+// regex rules apply.
+func parseBlacklistURL(rawURL string) *blacklistURL {
+	u := &blacklistURL{raw: rawURL}
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return u
+	}
+	u.host = normalizeHost(parsed.Hostname())
+	u.path = cleanPath(parsed.Path)
+	return u
+}
+
+// normalizeHost return the ascii form of host, unicode labels are punycode
+// encoded. A host failing IDNA processing is kept partially mapped
+func normalizeHost(host string) string {
+	ascii, _ := hostProfile.ToASCII(host)
+	return strings.TrimSuffix(strings.ToLower(ascii), ".")
+}
+
+// cleanPath resolve dot segments so they can't be used to skip path rules
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return path.Clean("/" + p)
+}
+
+// compiledRule is a BlacklistRule ready for matching
+type compiledRule struct {
+	rule  *BlacklistRule
+	match func(u *blacklistURL) bool
+}
+
+// compiledRules is a BlacklistMatcher over a fixed list of rules
+type compiledRules []*compiledRule
+
+func (rules compiledRules) Match(rawURL string) *BlacklistRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	return rules.match(parseBlacklistURL(rawURL))
+}
+
+func (rules compiledRules) match(u *blacklistURL) *BlacklistRule {
+	for _, r := range rules {
+		if r.match(u) {
+			return r.rule
+		}
+	}
+	return nil
+}
+
+// compileBlacklistRule build the matcher of rule, empty type is a regex
+func compileBlacklistRule(rule *BlacklistRule) (*compiledRule, error) {
+	switch rule.Type {
+	case RuleTypeHost:
+		host, ok := parseRuleHost(rule.Pattern)
+		if !ok {
+			return nil, ErrInvalidPattern
+		}
+		return &compiledRule{rule: rule, match: func(u *blacklistURL) bool {
+			return u.host == host
+		}}, nil
+	case RuleTypeDomain:
+		domain, ok := parseRuleHost(strings.TrimPrefix(rule.Pattern, "*."))
+		if !ok {
+			return nil, ErrInvalidPattern
+		}
+		return &compiledRule{rule: rule, match: func(u *blacklistURL) bool {
+			return matchDomain(u.host, domain)
+		}}, nil
+	case RuleTypePath:
+		i := strings.Index(rule.Pattern, "/")
+		if i < 0 {
+			return nil, ErrInvalidPattern
+		}
+		domain, ok := parseRuleHost(rule.Pattern[:i])
+		if !ok {
+			return nil, ErrInvalidPattern
+		}
+		prefix := cleanPath(rule.Pattern[i:])
+		return &compiledRule{rule: rule, match: func(u *blacklistURL) bool {
+			return matchDomain(u.host, domain) && matchPathPrefix(u.path, prefix)
+		}}, nil
+	case RuleTypeRegex, "":
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, ErrInvalidPattern
+		}
+		return &compiledRule{rule: rule, match: func(u *blacklistURL) bool {
+			return pattern.MatchString(u.raw)
+		}}, nil
+	default:
+		return nil, ErrInvalidRuleType
+	}
+}
+
+// parseRuleHost normalize host of a rule and reject anything else than a host name
+func parseRuleHost(host string) (string, bool) {
+	host = normalizeHost(host)
+	if host == "" || strings.ContainsAny(host, "/:@?#% \t") {
+		return "", false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" {
+			return "", false
+		}
+	}
+	return host, true
+}
+
+func matchDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// matchPathPrefix match whole path segments so /ads doesn't match /adsense
+func matchPathPrefix(p, prefix string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return len(p) == len(prefix) || strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/'
+}
+
+// parseBlacklistPattern turn a configured pattern into a rule. Pattern may be
+// prefixed with its type, eg: domain:example.com, otherwise it's a regex
+func parseBlacklistPattern(pattern string) *BlacklistRule {
+	for _, t := range []string{RuleTypeHost, RuleTypeDomain, RuleTypePath, RuleTypeRegex} {
+		if strings.HasPrefix(pattern, t+":") {
+			return &BlacklistRule{Type: t, Pattern: pattern[len(t)+1:]}
+		}
+	}
+	return &BlacklistRule{Type: RuleTypeRegex, Pattern: pattern}
+}
+
+// compileBlacklistPatterns turn patterns into rules without id
+func compileBlacklistPatterns(patterns []string) (compiledRules, error) {
+	rules := compiledRules{}
+	for _, pattern := range patterns {
+		r, err := compileBlacklistRule(parseBlacklistPattern(pattern))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import "testing"
+
+func TestBlacklistRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule  BlacklistRule
+		input string
+		want  bool
+	}{
+		{rule: BlacklistRule{Type: RuleTypeHost, Pattern: "evil.com"}, input: "http://evil.com/123", want: true},
+		{rule: BlacklistRule{Type: RuleTypeHost, Pattern: "evil.com"}, input: "https://EVIL.com.:8080/123", want: true},
+		{rule: BlacklistRule{Type: RuleTypeHost, Pattern: "evil.com"}, input: "http://good.com@evil.com/", want: true},
+		{rule: BlacklistRule{Type: RuleTypeHost, Pattern: "evil.com"}, input: "http://www.evil.com/", want: false},
+		{rule: BlacklistRule{Type: RuleTypeHost, Pattern: "evil.com"}, input: "http://evil.com@good.com/", want: false},
+		{rule: BlacklistRule{Type: RuleTypeHost, Pattern: "evil.com"}, input: "https://good.com/?q=evil.com", want: false},
+		{rule: BlacklistRule{Type: RuleTypeDomain, Pattern: "evil.com"}, input: "http://www.Evil.com/", want: true},
+		{rule: BlacklistRule{Type: RuleTypeDomain, Pattern: "*.evil.com"}, input: "http://a.b.evil.com/", want: true},
+		{rule: BlacklistRule{Type: RuleTypeDomain, Pattern: "evil.com"}, input: "http://notevil.com/", want: false},
+		// hosts browsers resolve to evil.com
+		{rule: BlacklistRule{Type: RuleTypeDomain, Pattern: "evil.com"}, input: "http://evil。com/", want: true},
+		{rule: BlacklistRule{Type: RuleTypeDomain, Pattern: "evil.com"}, input: "http://www.ｅｖｉｌ.com/", want: true},
+		// unicode and punycode forms are the same host
+		{rule: BlacklistRule{Type: RuleTypeDomain, Pattern: "münchen.de"}, input: "http://www.xn--mnchen-3ya.de/", want: true},
+		{rule: BlacklistRule{Type: RuleTypeDomain, Pattern: "xn--mnchen-3ya.de"}, input: "http://MÜNCHEN.de/", want: true},
+		{rule: BlacklistRule{Type: RuleTypePath, Pattern: "example.com/ads"}, input: "http://example.com/ads", want: true},
+		{rule: BlacklistRule{Type: RuleTypePath, Pattern: "example.com/ads"}, input: "http://cdn.example.com/ads/1.js", want: true},
+		{rule: BlacklistRule{Type: RuleTypePath, Pattern: "example.com/ads"}, input: "http://example.com/x/../ads/1.js", want: true},
+		{rule: BlacklistRule{Type: RuleTypePath, Pattern: "example.com/ads"}, input: "http://example.com/adsense", want: false},
+		{rule: BlacklistRule{Type: RuleTypePath, Pattern: "example.com/ads"}, input: "http://other.com/ads", want: false},
+		{rule: BlacklistRule{Type: RuleTypeRegex, Pattern: `evil\.com`}, input: "https://good.com/?q=evil.com", want: true},
+		{rule: BlacklistRule{Pattern: `^https://good\.com/private`}, input: "https://good.com/private/1", want: true},
+	}
+	for _, tc := range tests {
+		rule := tc.rule
+		compiled, err := compileBlacklistRule(&rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (compiledRules{compiled}).Match(tc.input) != nil; got != tc.want {
+			t.Errorf("%s %s, %s: expected: %v, got: %v", tc.rule.Type, tc.rule.Pattern, tc.input, tc.want, got)
+		}
+	}
+}
+
+func TestCompileBlacklistRule(t *testing.T) {
+	tests := []struct {
+		rule BlacklistRule
+		want error
+	}{
+		{rule: BlacklistRule{Type: RuleTypeHost, Pattern: "evil.com/path"}, want: ErrInvalidPattern},
+		{rule: BlacklistRule{Type: RuleTypeHost, Pattern: "evil..com"}, want: ErrInvalidPattern},
+		{rule: BlacklistRule{Type: RuleTypeDomain, Pattern: "evil.com:80"}, want: ErrInvalidPattern},
+		{rule: BlacklistRule{Type: RuleTypePath, Pattern: "evil.com"}, want: ErrInvalidPattern},
+		{rule: BlacklistRule{Type: RuleTypePath, Pattern: "/ads"}, want: ErrInvalidPattern},
+		{rule: BlacklistRule{Type: RuleTypeRegex, Pattern: "("}, want: ErrInvalidPattern},
+		{rule: BlacklistRule{Type: "glob", Pattern: "*"}, want: ErrInvalidRuleType},
+	}
+	for _, tc := range tests {
+		rule := tc.rule
+		if _, err := compileBlacklistRule(&rule); err != tc.want {
+			t.Errorf("%s %s: expected: %v, got: %v", tc.rule.Type, tc.rule.Pattern, tc.want, err)
+		}
+	}
+}
+
+func TestParseBlacklistPattern(t *testing.T) {
+	tests := []struct {
+		input string
+		want  BlacklistRule
+	}{
+		{input: "domain:evil.com", want: BlacklistRule{Type: RuleTypeDomain, Pattern: "evil.com"}},
+		{input: "path:example.com/ads", want: BlacklistRule{Type: RuleTypePath, Pattern: "example.com/ads"}},
+		{input: "regex:host:8080", want: BlacklistRule{Type: RuleTypeRegex, Pattern: "host:8080"}},
+		{input: `evil\.com`, want: BlacklistRule{Type: RuleTypeRegex, Pattern: `evil\.com`}},
+	}
+	for _, tc := range tests {
+		if got := parseBlacklistPattern(tc.input); *got != tc.want {
+			t.Errorf("%s: expected: %+v, got: %+v", tc.input, tc.want, *got)
+		}
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "Example.COM.", want: "example.com"},
+		{input: "evil。com", want: "evil.com"},
+		{input: "evil．com", want: "evil.com"},
+		{input: "evil｡com", want: "evil.com"},
+		{input: "ｅｖｉｌ.com", want: "evil.com"},
+		{input: "münchen.de", want: "xn--mnchen-3ya.de"},
+		{input: "XN--MNCHEN-3YA.de", want: "xn--mnchen-3ya.de"},
+		{input: "中文.com", want: "xn--fiq228c.com"},
+		{input: "my_host.example", want: "my_host.example"},
+	}
+	for _, tc := range tests {
+		if got := normalizeHost(tc.input); got != tc.want {
+			t.Errorf("%s: expected: %v, got: %v", tc.input, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default interval between background blacklist scans
+const DEFAULT_BLACKLIST_SCAN_INTERVAL = time.Hour
+
+// Default number of short urls loaded at once by blacklist scan
+const DEFAULT_BLACKLIST_SCAN_BATCH_SIZE = 500
+
+// BlacklistScanOption to modify blacklist scanner behavior
+type BlacklistScanOption struct {
+	// Interval between background scans
+	Interval time.Duration
+	// BatchSize number of short urls loaded at once
+	BatchSize int64
+	// Disable soft delete matching short urls in addition to flagging them
+	Disable bool
+}
+
+// BlacklistScanMatch a short url matching a blacklist rule
+type BlacklistScanMatch struct {
+	Code    string         `json:"code"`
+	FullURL string         `json:"fullUrl"`
+	Rule    *BlacklistRule `json:"rule"`
+	// Disabled is true when the short url was soft deleted by this scan
+	Disabled bool `json:"disabled"`
+}
+
+// BlacklistScanReport outcome of a scan over every stored short url
+type BlacklistScanReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Scanned    int64     `json:"scanned"`
+	// Affected short urls matching a rule, flagged ones included
+	Affected []*BlacklistScanMatch `json:"affected"`
+	// Cleared codes of flagged short urls which don't match any rule anymore.
+	// Soft deleted short urls keep their flag until restored, clearing it
+	// wouldn't bring them back
+	Cleared []string `json:"cleared"`
+}
+
+// BlacklistScanner flag stored short urls matching blacklist rules added after
+// they were created, so they show up as blocked when listed
+type BlacklistScanner interface {
+	// Scan every short url and return the report. A scan already running is
+	// joined rather than started again. Scan keeps running when ctx is done
+	Scan(ctx context.Context) (*BlacklistScanReport, error)
+	// Trigger start a scan in background unless one is running
+	Trigger()
+	// LastReport return the report of the last completed scan, nil before the first one
+	LastReport() *BlacklistScanReport
+	// Close stop periodic scans and cancel the running one
+	Close() error
+}
+
+// NewBlacklistScanner factory function. Short urls are scanned periodically
+// against rules of matcher
+func NewBlacklistScanner(repo URLShortenerRepository, matcher BlacklistMatcher, opts ...BlacklistScanOption) BlacklistScanner {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &blacklistScanner{
+		ctx:       ctx,
+		cancel:    cancel,
+		repo:      repo,
+		matcher:   matcher,
+		interval:  DEFAULT_BLACKLIST_SCAN_INTERVAL,
+		batchSize: DEFAULT_BLACKLIST_SCAN_BATCH_SIZE,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	if len(opts) > 0 {
+		if opts[0].Interval > 0 {
+			s.interval = opts[0].Interval
+		}
+		if opts[0].BatchSize > 0 {
+			s.batchSize = opts[0].BatchSize
+		}
+		s.disable = opts[0].Disable
+	}
+
+	go s.run()
+	return s
+}
+
+// scanCall is a running scan shared by every caller
+type scanCall struct {
+	done   chan struct{}
+	report *BlacklistScanReport
+	err    error
+}
+
+type blacklistScanner struct {
+	// ctx of scans, canceled on close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	repo      URLShortenerRepository
+	matcher   BlacklistMatcher
+	interval  time.Duration
+	batchSize int64
+	disable   bool
+
+	mux     sync.Mutex
+	closed  bool
+	running *scanCall
+	last    *BlacklistScanReport
+	wg      sync.WaitGroup
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *blacklistScanner) Scan(ctx context.Context) (*BlacklistScanReport, error) {
+	call := s.start()
+	select {
+	case <-call.done:
+		return call.report, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *blacklistScanner) Trigger() {
+	s.start()
+}
+
+func (s *blacklistScanner) LastReport() *BlacklistScanReport {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.last
+}
+
+func (s *blacklistScanner) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+
+		s.mux.Lock()
+		s.closed = true
+		s.mux.Unlock()
+		s.cancel()
+		s.wg.Wait()
+	})
+	return nil
+}
+
+// start return the running scan or start a new one, detached from callers
+// so giving up waiting doesn't leave short urls half scanned
+func (s *blacklistScanner) start() *scanCall {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.running != nil {
+		return s.running
+	}
+
+	call := &scanCall{done: make(chan struct{})}
+	if s.closed {
+		call.err = context.Canceled
+		close(call.done)
+		return call
+	}
+	s.running = call
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		call.report, call.err = s.scan(s.ctx)
+
+		s.mux.Lock()
+		s.running = nil
+		if call.err == nil {
+			s.last = call.report
+		}
+		s.mux.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// scan walk short urls in (created_at, id) order so records created meanwhile
+// don't shift pages
+func (s *blacklistScanner) scan(ctx context.Context) (*BlacklistScanReport, error) {
+	report := &BlacklistScanReport{
+		StartedAt: time.Now().UTC(),
+		Affected:  []*BlacklistScanMatch{},
+		Cleared:   []string{},
+	}
+
+	params := &ListParams{Size: s.batchSize}
+	for {
+		shortURLs, _, err := s.repo.ListShortURLs(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, shortURL := range shortURLs {
+			if err := s.check(ctx, shortURL, report); err != nil {
+				return nil, err
+			}
+		}
+		report.Scanned += int64(len(shortURLs))
+
+		if int64(len(shortURLs)) < s.batchSize {
+			break
+		}
+		last := shortURLs[len(shortURLs)-1]
+		params.After = &Cursor{CreatedAt: last.CreatedAt, Id: last.Id}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// check flag shortURL with the first rule it match, or clear the flag set by
+// a previous scan when it doesn't match anymore. shortURL may be outdated so
+// only the flag and deletion time are written, short urls removed meanwhile
+// are skipped
+func (s *blacklistScanner) check(ctx context.Context, shortURL *ShortURL, report *BlacklistScanReport) error {
+	rule := s.matcher.Match(shortURL.FullURL)
+	if rule == nil {
+		if shortURL.BlockedAt == nil || shortURL.DeletedAt != nil {
+			return nil
+		}
+		err := s.repo.SetShortURLBlocked(ctx, shortURL.Code, nil, "")
+		if err == ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.Cleared = append(report.Cleared, shortURL.Code)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	blockedBy := ruleLabel(rule)
+	if shortURL.BlockedAt == nil || shortURL.BlockedBy != blockedBy {
+		blockedAt := shortURL.BlockedAt
+		if blockedAt == nil {
+			blockedAt = &now
+		}
+		err := s.repo.SetShortURLBlocked(ctx, shortURL.Code, blockedAt, blockedBy)
+		if err == ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	match := &BlacklistScanMatch{Code: shortURL.Code, FullURL: shortURL.FullURL, Rule: rule}
+	report.Affected = append(report.Affected, match)
+	if s.disable && shortURL.DeletedAt == nil {
+		disabled, err := s.repo.DisableShortURL(ctx, shortURL.Code, now)
+		if err != nil {
+			return err
+		}
+		match.Disabled = disabled
+	}
+	return nil
+}
+
+func (s *blacklistScanner) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Trigger()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// ruleLabel identify rule on flagged short urls, static rules have no id
+func ruleLabel(rule *BlacklistRule) string {
+	if rule.Type == "" {
+		return RuleTypeRegex + ":" + rule.Pattern
+	}
+	return rule.Type + ":" + rule.Pattern
+}
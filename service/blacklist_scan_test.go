@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBlacklistScanner(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	for i, fullURL := range []string{
+		"http://evil.com/1",
+		"http://good.com/1",
+		"http://www.evil.com/2",
+		"http://good.com/?q=evil.com",
+		"http://good.com/2",
+	} {
+		shortURL := &ShortURL{FullURL: fullURL, Code: string(rune('a' + i)), CreatedAt: time.Now().UTC().Add(time.Duration(i) * time.Second)}
+		if err := repo.CreateShortURL(ctx, shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules, err := compileBlacklistPatterns([]string{"domain:evil.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := NewBlacklistScanner(repo, rules, BlacklistScanOption{Interval: time.Hour, BatchSize: 2})
+	defer scanner.Close()
+
+	if report := scanner.LastReport(); report != nil {
+		t.Errorf("expected: %v, got: %+v", nil, report)
+	}
+	report, err := scanner.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Scanned != 5 {
+		t.Errorf("expected: %v, got: %v", 5, report.Scanned)
+	}
+	var codes []string
+	for _, match := range report.Affected {
+		codes = append(codes, match.Code)
+		if match.Rule.Pattern != "evil.com" || match.Disabled {
+			t.Errorf("unexpected match: %+v", match)
+		}
+	}
+	if len(codes) != 2 || codes[0] != "a" || codes[1] != "c" {
+		t.Errorf("expected: %v, got: %v", []string{"a", "c"}, codes)
+	}
+	if scanner.LastReport() != report {
+		t.Errorf("expected last report to be %+v", report)
+	}
+
+	flagged, _, _ := repo.ListShortURLs(ctx, &ListParams{Filter: &FilterParams{Blocked: boolPtr(true)}})
+	if len(flagged) != 2 || flagged[0].BlockedBy != "domain:evil.com" || flagged[0].DeletedAt != nil {
+		t.Errorf("unexpected flagged short urls: %+v", flagged)
+	}
+
+	// flags are cleared once no rule match anymore
+	scanner = NewBlacklistScanner(repo, compiledRules{}, BlacklistScanOption{Interval: time.Hour, BatchSize: 2})
+	defer scanner.Close()
+	report, err = scanner.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Affected) != 0 || len(report.Cleared) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	flagged, _, _ = repo.ListShortURLs(ctx, &ListParams{Filter: &FilterParams{Blocked: boolPtr(true)}})
+	if len(flagged) != 0 {
+		t.Errorf("expected no flagged short url, got: %+v", flagged)
+	}
+}
+
+func TestBlacklistScannerDisable(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://evil.com/1", Code: "a"})
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://good.com/1", Code: "b"})
+
+	rules, _ := compileBlacklistPatterns([]string{"host:evil.com"})
+	scanner := NewBlacklistScanner(repo, rules, BlacklistScanOption{Disable: true})
+	defer scanner.Close()
+
+	report, err := scanner.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Affected) != 1 || !report.Affected[0].Disabled {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if s, _ := repo.FindShortURL(ctx, "a"); s.DeletedAt == nil || s.BlockedAt == nil {
+		t.Errorf("expected disabled short url, got: %+v", s)
+	}
+	if s, _ := repo.FindShortURL(ctx, "b"); s.DeletedAt != nil || s.BlockedAt != nil {
+		t.Errorf("expected untouched short url, got: %+v", s)
+	}
+
+	// already disabled short url is left as is
+	report, _ = scanner.Scan(ctx)
+	if len(report.Affected) != 1 || report.Affected[0].Disabled {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// disabled short url keep its flag once rules don't match it anymore
+	scanner = NewBlacklistScanner(repo, compiledRules{})
+	defer scanner.Close()
+	report, _ = scanner.Scan(ctx)
+	if len(report.Cleared) != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if s, _ := repo.FindShortURL(ctx, "a"); s.DeletedAt == nil || s.BlockedAt == nil {
+		t.Errorf("expected disabled short url, got: %+v", s)
+	}
+}
+
+// editedRepo run edit once short urls are listed, as if they were modified
+// by requests served while scanning
+type editedRepo struct {
+	URLShortenerRepository
+	edit func()
+}
+
+func (r *editedRepo) ListShortURLs(ctx context.Context, params *ListParams) ([]*ShortURL, int64, error) {
+	shortURLs, count, err := r.URLShortenerRepository.ListShortURLs(ctx, params)
+	if r.edit != nil {
+		r.edit()
+		r.edit = nil
+	}
+	return shortURLs, count, err
+}
+
+func TestBlacklistScannerConcurrentUpdate(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	blockedAt := time.Now().UTC().Add(-time.Hour)
+	deletedAt := time.Now().UTC().Add(-time.Minute)
+	for _, shortURL := range []*ShortURL{
+		{FullURL: "http://evil.com/1", Code: "a"},
+		{FullURL: "http://good.com/1", Code: "b", BlockedAt: &blockedAt, BlockedBy: "host:good.com"},
+		{FullURL: "http://evil.com/2", Code: "c"},
+		{FullURL: "http://evil.com/3", Code: "d"},
+	} {
+		if err := repo.CreateShortURL(ctx, shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	edited := &editedRepo{URLShortenerRepository: repo, edit: func() {
+		a, _ := repo.FindShortURL(ctx, "a")
+		a.ExpiresAt = &expiresAt
+		repo.UpdateShortURL(ctx, a)
+		b, _ := repo.FindShortURL(ctx, "b")
+		b.DeletedAt = &deletedAt
+		repo.UpdateShortURL(ctx, b)
+		c, _ := repo.FindShortURL(ctx, "c")
+		c.DeletedAt = &deletedAt
+		repo.UpdateShortURL(ctx, c)
+		repo.DeleteShortURL(ctx, "d")
+	}}
+
+	rules, _ := compileBlacklistPatterns([]string{"domain:evil.com"})
+	scanner := NewBlacklistScanner(edited, rules, BlacklistScanOption{Disable: true})
+	defer scanner.Close()
+
+	report, err := scanner.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Affected) != 2 || !report.Affected[0].Disabled || report.Affected[1].Disabled {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// update made meanwhile is kept
+	a, _ := repo.FindShortURL(ctx, "a")
+	if a.ExpiresAt == nil || !a.ExpiresAt.Equal(expiresAt) || a.BlockedAt == nil || a.DeletedAt == nil {
+		t.Errorf("unexpected short url: %+v", a)
+	}
+	// clearing the flag doesn't restore a short url deleted meanwhile
+	b, _ := repo.FindShortURL(ctx, "b")
+	if b.DeletedAt == nil || !b.DeletedAt.Equal(deletedAt) || b.BlockedAt != nil {
+		t.Errorf("unexpected short url: %+v", b)
+	}
+	// short url deleted meanwhile keep its deletion time
+	c, _ := repo.FindShortURL(ctx, "c")
+	if c.DeletedAt == nil || !c.DeletedAt.Equal(deletedAt) || c.BlockedAt == nil {
+		t.Errorf("unexpected short url: %+v", c)
+	}
+	// removed short url isn't recreated
+	if _, err := repo.FindShortURL(ctx, "d"); err != ErrRecordNotFound {
+		t.Errorf("expected: %v, got: %v", ErrRecordNotFound, err)
+	}
+}
+
+func TestBlacklistScannerDetached(t *testing.T) {
+	repo := NewMemoryRepository()
+	repo.CreateShortURL(context.Background(), &ShortURL{FullURL: "http://evil.com/1", Code: "a"})
+
+	matcher := &blockingMatcher{release: make(chan struct{})}
+	scanner := NewBlacklistScanner(repo, matcher)
+	defer scanner.Close()
+
+	// caller giving up doesn't stop the scan
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := scanner.Scan(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	close(matcher.release)
+
+	report, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Affected) != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+// blockingMatcher match every url once release is closed
+type blockingMatcher struct {
+	release chan struct{}
+}
+
+func (m *blockingMatcher) Match(url string) *BlacklistRule {
+	<-m.release
+	return &BlacklistRule{Type: RuleTypeHost, Pattern: "evil.com"}
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
@@ -43,7 +45,7 @@ func (suite *BlackListURLShortenerSuite) TestCreate() {
 
 	suite.repo.On("CreateShortURL", mock.Anything).Return(nil)
 	for _, tc := range tests {
-		_, err := suite.svc.Create(ShortURLInput{URL: tc.input})
+		_, err := suite.svc.Create(context.Background(), ShortURLInput{URL: tc.input})
 		suite.Equal(tc.want, err)
 	}
 
@@ -79,13 +81,170 @@ func (suite *BlackListURLShortenerSuite) TestGetFullURL() {
 	suite.repo.On("IncreaseShortURLHitCount", "789", 1).Return(nil)
 
 	for _, tc := range tests {
-		_, err := suite.svc.GetFullURL(tc.input)
+		_, err := suite.svc.GetFullURL(context.Background(), tc.input)
 		suite.Equal(tc.want, err)
 	}
 
 	suite.repo.AssertExpectations(suite.T())
 }
 
+func (suite *BlackListURLShortenerSuite) TestUpdate() {
+	blockedURL := "http://sample.com/123"
+	allowedURL := "http://example.com/456"
+
+	suite.repo.On("FindShortURL", "111").Return(&ShortURL{
+		Id:      1,
+		Code:    "111",
+		FullURL: "http://example.com/123",
+	}, nil)
+	suite.repo.On("UpdateShortURL", mock.Anything).Return(nil)
+
+	_, err := suite.svc.Update(context.Background(), "111", UpdateShortURLInput{URL: &blockedURL})
+	suite.Equal(ErrBlockedURL, err)
+
+	s, err := suite.svc.Update(context.Background(), "111", UpdateShortURLInput{URL: &allowedURL})
+	suite.Nil(err)
+	suite.Equal(allowedURL, s.FullURL)
+
+	suite.repo.AssertExpectations(suite.T())
+}
+
 func TestBlacklistURLShortener(t *testing.T) {
 	suite.Run(t, new(BlackListURLShortenerSuite))
 }
+
+type mockBlacklistRepo struct {
+	mock.Mock
+}
+
+func (m *mockBlacklistRepo) ListBlacklistRules(ctx context.Context) ([]*BlacklistRule, error) {
+	args := m.Called()
+	return args.Get(0).([]*BlacklistRule), args.Error(1)
+}
+
+func (m *mockBlacklistRepo) CreateBlacklistRule(ctx context.Context, rule *BlacklistRule) error {
+	args := m.Called(rule)
+	return args.Error(0)
+}
+
+func (m *mockBlacklistRepo) DeleteBlacklistRule(ctx context.Context, id int64) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func TestBlacklist(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockBlacklistRepo)
+	repo.On("ListBlacklistRules").Return([]*BlacklistRule{
+		{Id: 1, Pattern: `sample\.com`},
+		// stored invalid pattern is skipped
+		{Id: 2, Pattern: `(`},
+	}, nil).Once()
+
+	bl, err := NewBlacklist(repo, []string{`static\.com`}, BlacklistOption{ReloadInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bl.Close()
+
+	if rule := bl.Match("http://static.com"); rule == nil || rule.Pattern != `static\.com` {
+		t.Errorf("expected static rule, got: %+v", rule)
+	}
+	if rule := bl.Match("http://sample.com"); rule == nil || rule.Id != 1 {
+		t.Errorf("expected stored rule, got: %+v", rule)
+	}
+
+	// added rule apply immediately
+	repo.On("CreateBlacklistRule", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*BlacklistRule).Id = 3
+	}).Return(nil).Once()
+	rule, err := bl.AddRule(ctx, BlacklistRuleInput{Pattern: ` evil\.com `, Reason: "malware", CreatedBy: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Pattern != `evil\.com` || rule.Reason != "malware" || rule.CreatedBy != "alice" {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+	if rule := bl.Match("http://evil.com"); rule == nil || rule.Id != 3 {
+		t.Errorf("expected added rule, got: %+v", rule)
+	}
+	if rule.Type != RuleTypeRegex {
+		t.Errorf("expected: %v, got: %v", RuleTypeRegex, rule.Type)
+	}
+	for _, pattern := range []string{"", "("} {
+		if _, err := bl.AddRule(ctx, BlacklistRuleInput{Pattern: pattern}); err != ErrInvalidPattern {
+			t.Errorf("%q: expected: %v, got: %v", pattern, ErrInvalidPattern, err)
+		}
+	}
+	if _, err := bl.AddRule(ctx, BlacklistRuleInput{Type: "glob", Pattern: "*"}); err != ErrInvalidRuleType {
+		t.Errorf("expected: %v, got: %v", ErrInvalidRuleType, err)
+	}
+
+	// structured rule match on host only
+	repo.On("CreateBlacklistRule", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*BlacklistRule).Id = 5
+	}).Return(nil).Once()
+	rule, err = bl.AddRule(ctx, BlacklistRuleInput{Type: " Domain ", Pattern: "bad.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Type != RuleTypeDomain {
+		t.Errorf("expected: %v, got: %v", RuleTypeDomain, rule.Type)
+	}
+	if rule := bl.Match("http://WWW.bad.org/"); rule == nil || rule.Id != 5 {
+		t.Errorf("expected domain rule, got: %+v", rule)
+	}
+	if rule := bl.Match("http://good.org/?q=bad.org"); rule != nil {
+		t.Errorf("expected: %v, got: %+v", nil, rule)
+	}
+
+	// deleted rule stop matching
+	repo.On("DeleteBlacklistRule", int64(1)).Return(nil).Once()
+	repo.On("DeleteBlacklistRule", int64(9)).Return(ErrRecordNotFound).Once()
+	if err := bl.DeleteRule(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if rule := bl.Match("http://sample.com"); rule != nil {
+		t.Errorf("expected: %v, got: %+v", nil, rule)
+	}
+	if err := bl.DeleteRule(ctx, 9); err != ErrRecordNotFound {
+		t.Errorf("expected: %v, got: %v", ErrRecordNotFound, err)
+	}
+
+	// rules added by other instances are picked up on reload
+	repo.On("ListBlacklistRules").Return([]*BlacklistRule{{Id: 4, Pattern: `other\.com`}}, nil).Once()
+	bl.Reload(ctx)
+	if rule := bl.Match("http://other.com"); rule == nil || rule.Id != 4 {
+		t.Errorf("expected reloaded rule, got: %+v", rule)
+	}
+	if rule := bl.Match("http://evil.com"); rule != nil {
+		t.Errorf("expected: %v, got: %+v", nil, rule)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestBlacklistMatcherURLShortener(t *testing.T) {
+	repo := new(mockBlacklistRepo)
+	repo.On("ListBlacklistRules").Return([]*BlacklistRule{}, nil)
+	repo.On("CreateBlacklistRule", mock.Anything).Return(nil)
+	bl, err := NewBlacklist(repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bl.Close()
+
+	shortURLRepo := new(mockRepo)
+	shortURLRepo.On("CreateShortURL", mock.Anything).Return(nil)
+	svc := WithBlacklistMatcher(NewURLShortener(shortURLRepo), bl)
+
+	input := ShortURLInput{URL: "http://sample.com/123"}
+	if _, err := svc.Create(context.Background(), input); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	// new rule is enforced without rebuilding the service
+	bl.AddRule(context.Background(), BlacklistRuleInput{Pattern: `sample\.com`})
+	if _, err := svc.Create(context.Background(), input); err != ErrBlockedURL {
+		t.Errorf("expected: %v, got: %v", ErrBlockedURL, err)
+	}
+}
@@ -0,0 +1,527 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bolt buckets. short_urls is the primary store keyed by id, the rest are indexes
+var (
+	boltShortURLsBucket = []byte("short_urls")
+	boltHitCountsBucket = []byte("hit_counts")
+	boltCodesBucket     = []byte("codes")
+	boltDomainsBucket   = []byte("domains")
+	boltDeletedBucket   = []byte("deleted")
+	boltCreatedBucket   = []byte("created")
+)
+
+// NewBoltRepository factory function. It is a pure Go embedded store so the
+// binary can be built without cgo. Missing buckets are created on first use.
+func NewBoltRepository(db *bolt.DB) (URLShortenerRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		// created index was added later, fill it for existing records
+		if tx.Bucket(boltCreatedBucket) == nil && tx.Bucket(boltShortURLsBucket) != nil {
+			if err := backfillBoltCreatedIndex(tx); err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{
+			boltShortURLsBucket,
+			boltHitCountsBucket,
+			boltCodesBucket,
+			boltDomainsBucket,
+			boltDeletedBucket,
+			boltCreatedBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &boltRepository{db: db}, nil
+}
+
+// boltRepository store records as gob encoded values. Hit counts are
+// kept in their own bucket so an increment doesn't rewrite the record.
+//
+// Indexes:
+//   - codes: code -> id
+//   - domains: lower(domain) + 0x00 + id -> nil, scanned for keyword search
+//   - deleted: deleted_at unix nano + id -> nil, ordered by deletion time for purge
+//   - created: created_at unix nano + id -> nil, ordered for listing
+type boltRepository struct {
+	db *bolt.DB
+}
+
+func (r *boltRepository) CreateShortURL(ctx context.Context, shortURL *ShortURL) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltCodesBucket).Get([]byte(shortURL.Code)) != nil {
+			return ErrConstraintUnique
+		}
+
+		id, err := tx.Bucket(boltShortURLsBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		record := copyShortURL(shortURL)
+		record.Id = int64(id)
+		if record.CreatedAt.IsZero() {
+			record.CreatedAt = time.Now().UTC()
+		}
+
+		if err := putBoltShortURL(tx, record); err != nil {
+			return err
+		}
+		if err := putBoltIndexes(tx, record); err != nil {
+			return err
+		}
+		if record.HitCount != 0 {
+			if err := tx.Bucket(boltHitCountsBucket).Put(boltId(record.Id), boltUint64(uint64(record.HitCount))); err != nil {
+				return err
+			}
+		}
+
+		shortURL.Id = record.Id
+		shortURL.CreatedAt = record.CreatedAt
+		return nil
+	})
+}
+
+func (r *boltRepository) FindShortURL(ctx context.Context, code string) (*ShortURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var shortURL *ShortURL
+	err := r.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltCodesBucket).Get([]byte(code))
+		if id == nil {
+			return ErrRecordNotFound
+		}
+		s, err := getBoltShortURL(tx, id)
+		if err != nil {
+			return err
+		}
+		shortURL = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shortURL, nil
+}
+
+// UpdateShortURL update the same mutable fields as the sql repositories
+func (r *boltRepository) UpdateShortURL(ctx context.Context, shortURL *ShortURL) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		record, err := getBoltShortURL(tx, boltId(shortURL.Id))
+		if err != nil {
+			return err
+		}
+		if err := deleteBoltIndexes(tx, record); err != nil {
+			return err
+		}
+
+		record.FullURL = shortURL.FullURL
+		record.Domain = shortURL.Domain
+		record.ExpiresAt = copyTime(shortURL.ExpiresAt)
+		record.DeletedAt = copyTime(shortURL.DeletedAt)
+		record.BlockedAt = copyTime(shortURL.BlockedAt)
+		record.BlockedBy = shortURL.BlockedBy
+
+		if err := putBoltShortURL(tx, record); err != nil {
+			return err
+		}
+		return putBoltIndexes(tx, record)
+	})
+}
+
+func (r *boltRepository) DeleteShortURL(ctx context.Context, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltCodesBucket).Get([]byte(code))
+		if id == nil {
+			return ErrRecordNotFound
+		}
+		record, err := getBoltShortURL(tx, id)
+		if err != nil {
+			return err
+		}
+		return deleteBoltShortURL(tx, record)
+	})
+}
+
+func (r *boltRepository) PurgeShortURLs(ctx context.Context, deletedBefore time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		// deleted index is ordered by time so stop at the first newer key
+		limit := boltUint64(uint64(deletedBefore.UnixNano()))
+		var ids [][]byte
+		c := tx.Bucket(boltDeletedBucket).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:8], limit) < 0; k, _ = c.Next() {
+			ids = append(ids, append([]byte(nil), k[8:]...))
+		}
+
+		for _, id := range ids {
+			record, err := getBoltShortURL(tx, id)
+			if err != nil {
+				return err
+			}
+			if err := deleteBoltShortURL(tx, record); err != nil {
+				return err
+			}
+			codes = append(codes, record.Code)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// IncreaseShortURLHitCount is atomic since bolt serializes write transactions
+func (r *boltRepository) IncreaseShortURLHitCount(ctx context.Context, code string, count int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltCodesBucket).Get([]byte(code))
+		if id == nil {
+			return ErrRecordNotFound
+		}
+		bucket := tx.Bucket(boltHitCountsBucket)
+		hitCount := int64(boltUint64Value(bucket.Get(id))) + int64(count)
+		return bucket.Put(id, boltUint64(uint64(hitCount)))
+	})
+}
+
+func (r *boltRepository) SetShortURLBlocked(ctx context.Context, code string, blockedAt *time.Time, blockedBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltCodesBucket).Get([]byte(code))
+		if id == nil {
+			return ErrRecordNotFound
+		}
+		record, err := getBoltShortURL(tx, id)
+		if err != nil {
+			return err
+		}
+		record.BlockedAt = copyTime(blockedAt)
+		record.BlockedBy = blockedBy
+		return putBoltShortURL(tx, record)
+	})
+}
+
+func (r *boltRepository) DisableShortURL(ctx context.Context, code string, deletedAt time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var disabled bool
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltCodesBucket).Get([]byte(code))
+		if id == nil {
+			return nil
+		}
+		record, err := getBoltShortURL(tx, id)
+		if err != nil || record.DeletedAt != nil {
+			return err
+		}
+		record.DeletedAt = copyTime(&deletedAt)
+		if err := putBoltShortURL(tx, record); err != nil {
+			return err
+		}
+		disabled = true
+		return tx.Bucket(boltDeletedBucket).Put(boltDeletedKey(record), nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	return disabled, nil
+}
+
+func (r *boltRepository) ListShortURLs(ctx context.Context, params *ListParams) ([]*ShortURL, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var count int64
+	var shortURLs []*ShortURL
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		if !isEmptyFilter(params.Filter) || params.Sort.Field != SortCreatedAt && params.Sort.Field != "" {
+			shortURLs, count, err = listFilteredBolt(tx, params)
+			return err
+		}
+
+		if params.WithCount {
+			count = int64(tx.Bucket(boltShortURLsBucket).Stats().KeyN)
+		}
+		shortURLs, err = listCreatedBolt(tx, params)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return shortURLs, count, nil
+}
+
+// listCreatedBolt walk created index so only the requested page is decoded
+func listCreatedBolt(tx *bolt.Tx, params *ListParams) ([]*ShortURL, error) {
+	c := tx.Bucket(boltCreatedBucket).Cursor()
+	first, next := c.First, c.Next
+	if params.Sort.Desc {
+		first, next = c.Last, c.Prev
+	}
+
+	var k []byte
+	if after := params.After; after != nil {
+		seek := boltCreatedKey(after.CreatedAt, after.Id)
+		k, _ = c.Seek(seek)
+		if params.Sort.Desc {
+			// Seek land on the first key >= seek, step back below it
+			if k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		} else if bytes.Equal(k, seek) {
+			k, _ = c.Next()
+		}
+	} else {
+		k, _ = first()
+		for i := int64(0); i < params.Offset && k != nil; i++ {
+			k, _ = next()
+		}
+	}
+
+	var shortURLs []*ShortURL
+	for ; k != nil; k, _ = next() {
+		if params.Size > 0 && int64(len(shortURLs)) >= params.Size {
+			break
+		}
+		s, err := getBoltShortURL(tx, k[8:])
+		if err != nil {
+			return nil, err
+		}
+		shortURLs = append(shortURLs, s)
+	}
+	return shortURLs, nil
+}
+
+// listFilteredBolt decode candidate records then filter, sort and paginate them.
+// Code and keyword filters narrow candidates down with indexes
+func listFilteredBolt(tx *bolt.Tx, params *ListParams) ([]*ShortURL, int64, error) {
+	filter := params.Filter
+	if filter == nil {
+		filter = &FilterParams{}
+	}
+
+	var ids [][]byte
+	switch {
+	case filter.Keyword != "":
+		ids = scanBoltDomainIndex(tx, filter)
+	case filter.Code != "":
+		if id := tx.Bucket(boltCodesBucket).Get([]byte(filter.Code)); id != nil {
+			ids = append(ids, id)
+		}
+	default:
+		c := tx.Bucket(boltShortURLsBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			ids = append(ids, k)
+		}
+	}
+
+	now := time.Now().UTC()
+	var matches []*ShortURL
+	for _, id := range ids {
+		s, err := getBoltShortURL(tx, id)
+		if err != nil {
+			return nil, 0, err
+		}
+		if matchFilter(s, filter, now) {
+			matches = append(matches, s)
+		}
+	}
+	sortShortURLs(matches, params.Sort)
+
+	var count int64
+	if params.WithCount {
+		count = int64(len(matches))
+	}
+	return paginate(matches, params), count, nil
+}
+
+// scanBoltDomainIndex return ids whose domain contain filter keyword,
+// scanning domain index rather than decoding every record
+func scanBoltDomainIndex(tx *bolt.Tx, filter *FilterParams) [][]byte {
+	var codeId []byte
+	if filter.Code != "" {
+		codeId = tx.Bucket(boltCodesBucket).Get([]byte(filter.Code))
+		if codeId == nil {
+			return nil
+		}
+	}
+
+	var ids [][]byte
+	keyword := []byte(strings.ToLower(filter.Keyword))
+	c := tx.Bucket(boltDomainsBucket).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		domain, id := k[:len(k)-9], k[len(k)-8:]
+		if !bytes.Contains(domain, keyword) {
+			continue
+		}
+		if codeId != nil && !bytes.Equal(id, codeId) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func backfillBoltCreatedIndex(tx *bolt.Tx) error {
+	bucket, err := tx.CreateBucket(boltCreatedBucket)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltShortURLsBucket).ForEach(func(id, _ []byte) error {
+		s, err := getBoltShortURL(tx, id)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltCreatedKey(s.CreatedAt, s.Id), nil)
+	})
+}
+
+func getBoltShortURL(tx *bolt.Tx, id []byte) (*ShortURL, error) {
+	v := tx.Bucket(boltShortURLsBucket).Get(id)
+	if v == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	var shortURL ShortURL
+	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&shortURL); err != nil {
+		return nil, err
+	}
+	shortURL.HitCount = int64(boltUint64Value(tx.Bucket(boltHitCountsBucket).Get(id)))
+	return &shortURL, nil
+}
+
+// putBoltShortURL encode record without hit count, it lives in hit_counts bucket
+func putBoltShortURL(tx *bolt.Tx, shortURL *ShortURL) error {
+	record := *shortURL
+	record.HitCount = 0
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&record); err != nil {
+		return err
+	}
+	return tx.Bucket(boltShortURLsBucket).Put(boltId(record.Id), buf.Bytes())
+}
+
+func deleteBoltShortURL(tx *bolt.Tx, shortURL *ShortURL) error {
+	if err := deleteBoltIndexes(tx, shortURL); err != nil {
+		return err
+	}
+	id := boltId(shortURL.Id)
+	if err := tx.Bucket(boltHitCountsBucket).Delete(id); err != nil {
+		return err
+	}
+	return tx.Bucket(boltShortURLsBucket).Delete(id)
+}
+
+func putBoltIndexes(tx *bolt.Tx, shortURL *ShortURL) error {
+	id := boltId(shortURL.Id)
+	if err := tx.Bucket(boltCodesBucket).Put([]byte(shortURL.Code), id); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltDomainsBucket).Put(boltDomainKey(shortURL), nil); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltCreatedBucket).Put(boltCreatedKey(shortURL.CreatedAt, shortURL.Id), nil); err != nil {
+		return err
+	}
+	if shortURL.DeletedAt != nil {
+		return tx.Bucket(boltDeletedBucket).Put(boltDeletedKey(shortURL), nil)
+	}
+	return nil
+}
+
+func deleteBoltIndexes(tx *bolt.Tx, shortURL *ShortURL) error {
+	if err := tx.Bucket(boltCodesBucket).Delete([]byte(shortURL.Code)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltDomainsBucket).Delete(boltDomainKey(shortURL)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltCreatedBucket).Delete(boltCreatedKey(shortURL.CreatedAt, shortURL.Id)); err != nil {
+		return err
+	}
+	if shortURL.DeletedAt != nil {
+		return tx.Bucket(boltDeletedBucket).Delete(boltDeletedKey(shortURL))
+	}
+	return nil
+}
+
+func boltDomainKey(shortURL *ShortURL) []byte {
+	key := append([]byte(strings.ToLower(shortURL.Domain)), 0)
+	return append(key, boltId(shortURL.Id)...)
+}
+
+func boltDeletedKey(shortURL *ShortURL) []byte {
+	key := boltUint64(uint64(shortURL.DeletedAt.UnixNano()))
+	return append(key, boltId(shortURL.Id)...)
+}
+
+func boltCreatedKey(createdAt time.Time, id int64) []byte {
+	key := boltUint64(uint64(createdAt.UnixNano()))
+	return append(key, boltId(id)...)
+}
+
+// boltId encode id as big endian so keys sort in numeric order
+func boltId(id int64) []byte {
+	return boltUint64(uint64(id))
+}
+
+func boltUint64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func boltUint64Value(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestBolt(t *testing.T, path string) *bolt.DB {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestBoltRepository(t *testing.T, db *bolt.DB) URLShortenerRepository {
+	repo, err := NewBoltRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestBoltRepository(t *testing.T) {
+	var db *bolt.DB
+	s := &URLShortenerRepositorySuite{}
+	s.setup = func() URLShortenerRepository {
+		db = openTestBolt(t, filepath.Join(t.TempDir(), "bolt.db"))
+		return newTestBoltRepository(t, db)
+	}
+	s.teardown = func() {
+		db.Close()
+	}
+	suite.Run(t, s)
+}
+
+func TestBoltRepositoryReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bolt.db")
+
+	db := openTestBolt(t, path)
+	repo := newTestBoltRepository(t, db)
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+	repo.IncreaseShortURLHitCount(ctx, "123", 2)
+	db.Close()
+
+	db = openTestBolt(t, path)
+	defer db.Close()
+	repo = newTestBoltRepository(t, db)
+
+	s, err := repo.FindShortURL(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Id != 1 || s.HitCount != 2 || s.Domain != "example.com" {
+		t.Errorf("unexpected short url after reopen: %+v", s)
+	}
+}
+
+func TestBoltRepositorySoftDeleteIndex(t *testing.T) {
+	ctx := context.Background()
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "bolt.db"))
+	defer db.Close()
+	repo := newTestBoltRepository(t, db)
+
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://example1.com", Domain: "example1.com", Code: "456"})
+
+	// soft delete both then restore one, only the deleted one is purged
+	deletedAt := time.Now().UTC().AddDate(0, 0, -10)
+	for _, code := range []string{"123", "456"} {
+		s, _ := repo.FindShortURL(ctx, code)
+		s.DeletedAt = &deletedAt
+		repo.UpdateShortURL(ctx, s)
+	}
+	s, _ := repo.FindShortURL(ctx, "456")
+	s.DeletedAt = nil
+	repo.UpdateShortURL(ctx, s)
+
+	codes, err := repo.PurgeShortURLs(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 || codes[0] != "123" {
+		t.Errorf("expected: %v, got: %v", []string{"123"}, codes)
+	}
+	if _, err := repo.FindShortURL(ctx, "123"); err != ErrRecordNotFound {
+		t.Errorf("expected: %v, got: %v", ErrRecordNotFound, err)
+	}
+
+	// purged record is removed from domain index too
+	shortURLs, total, _ := repo.ListShortURLs(ctx, &ListParams{Size: 10, WithCount: true, Filter: &FilterParams{Keyword: "example"}})
+	if total != 1 || shortURLs[0].Code != "456" {
+		t.Errorf("unexpected list result: %v %+v", total, shortURLs)
+	}
+}
+
+func TestBoltRepositoryConcurrency(t *testing.T) {
+	ctx := context.Background()
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "bolt.db"))
+	defer db.Close()
+	repo := newTestBoltRepository(t, db)
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo.IncreaseShortURLHitCount(ctx, "123", 1)
+		}()
+	}
+	wg.Wait()
+
+	s, _ := repo.FindShortURL(ctx, "123")
+	if s.HitCount != 50 {
+		t.Errorf("expected: %v, got: %v", 50, s.HitCount)
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default number of click events kept while they can't be written,
+// newer events are dropped past it
+const DEFAULT_MAX_QUEUED_CLICKS = 100000
+
+// BufferedClickEventRepository is a ClickEventRepository which queue click events in memory
+type BufferedClickEventRepository interface {
+	ClickEventRepository
+	// Flush write queued click events to the underlying repository
+	Flush(ctx context.Context) error
+	// Close stop periodic flushing and flush queued click events
+	Close() error
+}
+
+// WithBufferedClickEvents decorate existing ClickEventRepository with write-behind
+// click recording. Events are queued and written in batch so redirects don't
+// wait on a database write, they show up in lists and stats once flushed.
+func WithBufferedClickEvents(repo ClickEventRepository, opts ...BufferOption) BufferedClickEventRepository {
+	r := &bufferedClickEventRepository{
+		ClickEventRepository: repo,
+		interval:             DEFAULT_FLUSH_INTERVAL,
+		maxPending:           DEFAULT_MAX_PENDING,
+		maxQueued:            DEFAULT_MAX_QUEUED_CLICKS,
+	}
+	if len(opts) > 0 {
+		if opts[0].FlushInterval > 0 {
+			r.interval = opts[0].FlushInterval
+		}
+		if opts[0].MaxPending > 0 {
+			r.maxPending = opts[0].MaxPending
+		}
+	}
+
+	r.loop = newFlushLoop(r.interval, r.Flush)
+	return r
+}
+
+type bufferedClickEventRepository struct {
+	ClickEventRepository
+	interval   time.Duration
+	maxPending int
+	maxQueued  int
+
+	mux     sync.Mutex
+	pending []*ClickEvent
+
+	// serialize flushes so events are written in order
+	flushMux sync.Mutex
+	loop     *flushLoop
+}
+
+// CreateClickEvent queue event, it is timestamped now rather than when written
+func (r *bufferedClickEventRepository) CreateClickEvent(ctx context.Context, event *ClickEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+
+	r.mux.Lock()
+	if len(r.pending) < r.maxQueued {
+		r.pending = append(r.pending, event)
+	}
+	full := len(r.pending) >= r.maxPending
+	r.mux.Unlock()
+
+	if full {
+		r.loop.trigger()
+	}
+	return nil
+}
+
+func (r *bufferedClickEventRepository) Flush(ctx context.Context) error {
+	r.flushMux.Lock()
+	defer r.flushMux.Unlock()
+
+	r.mux.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mux.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := r.ClickEventRepository.CreateClickEvents(ctx, pending)
+	if err != nil {
+		// put back failed events ahead of newer ones so they are retried on next flush
+		r.mux.Lock()
+		r.pending = append(pending, r.pending...)
+		if len(r.pending) > r.maxQueued {
+			r.pending = r.pending[:r.maxQueued]
+		}
+		r.mux.Unlock()
+	}
+	return err
+}
+
+func (r *bufferedClickEventRepository) Close() error {
+	return r.loop.close()
+}
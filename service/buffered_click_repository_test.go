@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBufferedClickEventRepositoryFlush(t *testing.T) {
+	repo := new(mockClickRepo)
+	buffered := WithBufferedClickEvents(repo, BufferOption{FlushInterval: time.Hour})
+	defer buffered.Close()
+
+	first := &ClickEvent{Code: "123"}
+	second := &ClickEvent{Code: "456"}
+	buffered.CreateClickEvent(context.Background(), first)
+	buffered.CreateClickEvent(context.Background(), second)
+	if first.CreatedAt.IsZero() {
+		t.Error("expected event to be timestamped when queued")
+	}
+
+	repo.On("CreateClickEvents", []*ClickEvent{first, second}).Return(errors.New("database is locked")).Once()
+	if err := buffered.Flush(context.Background()); err == nil {
+		t.Error("expected flush error")
+	}
+
+	// failed events are retried ahead of newer ones
+	third := &ClickEvent{Code: "789"}
+	buffered.CreateClickEvent(context.Background(), third)
+	repo.On("CreateClickEvents", []*ClickEvent{first, second, third}).Return(nil).Once()
+	if err := buffered.Flush(context.Background()); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	if err := buffered.Flush(context.Background()); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestBufferedClickEventRepositoryFlushOnThreshold(t *testing.T) {
+	repo := new(mockClickRepo)
+	buffered := WithBufferedClickEvents(repo, BufferOption{FlushInterval: time.Hour, MaxPending: 2})
+	defer buffered.Close()
+
+	flushed := make(chan struct{})
+	repo.On("CreateClickEvents", mock.Anything).Return(nil).Once().Run(func(mock.Arguments) {
+		close(flushed)
+	})
+
+	buffered.CreateClickEvent(context.Background(), &ClickEvent{Code: "123"})
+	buffered.CreateClickEvent(context.Background(), &ClickEvent{Code: "123"})
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("expected flush when reaching max pending")
+	}
+	repo.AssertExpectations(t)
+}
+
+func TestBufferedClickEventRepositoryFlushOnClose(t *testing.T) {
+	repo := new(mockClickRepo)
+	buffered := WithBufferedClickEvents(repo, BufferOption{FlushInterval: time.Hour})
+
+	event := &ClickEvent{Code: "123"}
+	buffered.CreateClickEvent(context.Background(), event)
+	repo.On("CreateClickEvents", []*ClickEvent{event}).Return(nil).Once()
+	if err := buffered.Close(); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	repo.AssertExpectations(t)
+}
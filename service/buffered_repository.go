@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default hit count buffering behavior
+const (
+	DEFAULT_FLUSH_INTERVAL = time.Second
+	DEFAULT_MAX_PENDING    = 1000
+)
+
+// BufferOption to modify hit count buffering behavior
+type BufferOption struct {
+	// FlushInterval between periodic flushes
+	FlushInterval time.Duration
+	// MaxPending number of buffered hits which trigger an early flush
+	MaxPending int
+}
+
+// BufferedRepository is an URLShortenerRepository which buffer hit counts in memory
+type BufferedRepository interface {
+	URLShortenerRepository
+	// Flush write pending hit counts to the underlying repository
+	Flush(ctx context.Context) error
+	// Close stop periodic flushing and flush pending hit counts
+	Close() error
+}
+
+// WithBufferedHitCount decorate existing URLShortenerRepository with write-behind
+// hit counting. Increments are accumulated per code and written in batch so
+// redirects don't wait on a database write.
+func WithBufferedHitCount(repo URLShortenerRepository, opts ...BufferOption) BufferedRepository {
+	r := &bufferedRepository{
+		URLShortenerRepository: repo,
+		interval:               DEFAULT_FLUSH_INTERVAL,
+		maxPending:             DEFAULT_MAX_PENDING,
+		pending:                make(map[string]int),
+	}
+	if len(opts) > 0 {
+		if opts[0].FlushInterval > 0 {
+			r.interval = opts[0].FlushInterval
+		}
+		if opts[0].MaxPending > 0 {
+			r.maxPending = opts[0].MaxPending
+		}
+	}
+
+	r.loop = newFlushLoop(r.interval, r.Flush)
+	return r
+}
+
+type bufferedRepository struct {
+	URLShortenerRepository
+	interval   time.Duration
+	maxPending int
+
+	mux     sync.Mutex
+	pending map[string]int
+	total   int
+
+	// serialize flushes so counts are written in order
+	flushMux sync.Mutex
+	loop     *flushLoop
+}
+
+func (r *bufferedRepository) IncreaseShortURLHitCount(ctx context.Context, code string, count int) error {
+	r.mux.Lock()
+	r.pending[code] += count
+	r.total += count
+	full := r.total >= r.maxPending
+	r.mux.Unlock()
+
+	if full {
+		r.loop.trigger()
+	}
+	return nil
+}
+
+// FindShortURL include hits which are not flushed yet
+func (r *bufferedRepository) FindShortURL(ctx context.Context, code string) (*ShortURL, error) {
+	shortURL, err := r.URLShortenerRepository.FindShortURL(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	r.addPending(shortURL)
+	return shortURL, nil
+}
+
+// ListShortURLs include hits which are not flushed yet
+func (r *bufferedRepository) ListShortURLs(ctx context.Context, params *ListParams) ([]*ShortURL, int64, error) {
+	shortURLs, count, err := r.URLShortenerRepository.ListShortURLs(ctx, params)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.addPending(shortURLs...)
+	return shortURLs, count, nil
+}
+
+// DeleteShortURL drop pending hits of the deleted short url
+func (r *bufferedRepository) DeleteShortURL(ctx context.Context, code string) error {
+	if err := r.URLShortenerRepository.DeleteShortURL(ctx, code); err != nil {
+		return err
+	}
+	r.dropPending(code)
+	return nil
+}
+
+// PurgeShortURLs drop pending hits of the purged short urls so a code
+// created again doesn't inherit them
+func (r *bufferedRepository) PurgeShortURLs(ctx context.Context, deletedBefore time.Time) ([]string, error) {
+	codes, err := r.URLShortenerRepository.PurgeShortURLs(ctx, deletedBefore)
+	if err != nil {
+		return nil, err
+	}
+	r.dropPending(codes...)
+	return codes, nil
+}
+
+func (r *bufferedRepository) dropPending(codes ...string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for _, code := range codes {
+		r.total -= r.pending[code]
+		delete(r.pending, code)
+	}
+}
+
+func (r *bufferedRepository) Flush(ctx context.Context) error {
+	r.flushMux.Lock()
+	defer r.flushMux.Unlock()
+
+	r.mux.Lock()
+	pending := r.pending
+	r.pending = make(map[string]int)
+	r.total = 0
+	r.mux.Unlock()
+
+	var firstErr error
+	for code, count := range pending {
+		err := r.URLShortenerRepository.IncreaseShortURLHitCount(ctx, code, count)
+		if err == nil || err == ErrRecordNotFound {
+			// hits of a removed short url are dropped
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		// put back failed counts so they are retried on next flush
+		r.mux.Lock()
+		r.pending[code] += count
+		r.total += count
+		r.mux.Unlock()
+	}
+	return firstErr
+}
+
+func (r *bufferedRepository) Close() error {
+	return r.loop.close()
+}
+
+func (r *bufferedRepository) addPending(shortURLs ...*ShortURL) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for _, shortURL := range shortURLs {
+		shortURL.HitCount += int64(r.pending[shortURL.Code])
+	}
+}
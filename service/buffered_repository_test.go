@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBufferedRepositoryFlush(t *testing.T) {
+	repo := new(mockRepo)
+	buffered := WithBufferedHitCount(repo, BufferOption{FlushInterval: time.Hour})
+	defer buffered.Close()
+
+	for i := 0; i < 3; i++ {
+		buffered.IncreaseShortURLHitCount(context.Background(), "123", 1)
+	}
+	buffered.IncreaseShortURLHitCount(context.Background(), "456", 2)
+	buffered.IncreaseShortURLHitCount(context.Background(), "789", 1)
+
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123", HitCount: 10}, nil).Once()
+	s, err := buffered.FindShortURL(context.Background(), "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.HitCount != 13 {
+		t.Errorf("expected pending hits to be included, got: %d", s.HitCount)
+	}
+
+	repo.On("IncreaseShortURLHitCount", "123", 3).Return(nil).Once()
+	repo.On("IncreaseShortURLHitCount", "456", 2).Return(errors.New("database is locked")).Once()
+	repo.On("IncreaseShortURLHitCount", "789", 1).Return(ErrRecordNotFound).Once()
+	if err := buffered.Flush(context.Background()); err == nil {
+		t.Error("expected flush error")
+	}
+
+	// failed count is retried, missing short url is dropped
+	repo.On("IncreaseShortURLHitCount", "456", 2).Return(nil).Once()
+	if err := buffered.Flush(context.Background()); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	if err := buffered.Flush(context.Background()); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestBufferedRepositoryFlushOnThreshold(t *testing.T) {
+	repo := new(mockRepo)
+	buffered := WithBufferedHitCount(repo, BufferOption{FlushInterval: time.Hour, MaxPending: 2})
+	defer buffered.Close()
+
+	flushed := make(chan struct{})
+	repo.On("IncreaseShortURLHitCount", "123", 2).Return(nil).Once().Run(func(mock.Arguments) {
+		close(flushed)
+	})
+
+	buffered.IncreaseShortURLHitCount(context.Background(), "123", 1)
+	buffered.IncreaseShortURLHitCount(context.Background(), "123", 1)
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("expected flush when reaching max pending")
+	}
+	repo.AssertExpectations(t)
+}
+
+func TestBufferedRepositoryPurge(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	buffered := WithBufferedHitCount(repo, BufferOption{FlushInterval: time.Hour})
+	defer buffered.Close()
+
+	buffered.IncreaseShortURLHitCount(ctx, "123", 1)
+	buffered.IncreaseShortURLHitCount(ctx, "456", 2)
+
+	// pending hits of purged codes aren't flushed to codes created again
+	deletedBefore := time.Now()
+	repo.On("PurgeShortURLs", deletedBefore).Return([]string{"123"}, nil).Once()
+	if _, err := buffered.PurgeShortURLs(ctx, deletedBefore); err != nil {
+		t.Fatal(err)
+	}
+	repo.On("IncreaseShortURLHitCount", "456", 2).Return(nil).Once()
+	if err := buffered.Flush(ctx); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestBufferedRepositoryFlushOnClose(t *testing.T) {
+	repo := new(mockRepo)
+	buffered := WithBufferedHitCount(repo, BufferOption{FlushInterval: time.Hour})
+
+	buffered.IncreaseShortURLHitCount(context.Background(), "123", 1)
+	repo.On("IncreaseShortURLHitCount", "123", 1).Return(nil).Once()
+	if err := buffered.Close(); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	// closing twice is harmless
+	if err := buffered.Close(); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Error return from a cache store which need encoded values given NoCodec
+var ErrNoCodec = errors.New("cache store need a codec to encode values")
+
+// Codec encode cached values for stores keeping them as bytes
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Available codecs. JSONCodec skip fields tagged `json:"-"`, GobCodec keep
+// every exported field and NoCodec keep values as is in memory stores.
+var (
+	JSONCodec Codec = jsonCodec{}
+	GobCodec  Codec = gobCodec{}
+	NoCodec   Codec = noCodec{}
+)
+
+// CacheSizer is implemented by values which know their size. In memory
+// stores use it to account values kept without codec
+type CacheSizer interface {
+	CacheSize() int64
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// noCodec is recognized by in memory stores which then skip encoding,
+// it can't produce bytes
+type noCodec struct{}
+
+func (noCodec) Marshal(v interface{}) ([]byte, error) {
+	return nil, ErrNoCodec
+}
+
+func (noCodec) Unmarshal(data []byte, v interface{}) error {
+	return ErrNoCodec
+}
+
+// encodeCacheValue return val encoded by codec, or val itself with NoCodec
+func encodeCacheValue(codec Codec, val interface{}) (interface{}, error) {
+	if _, ok := codec.(noCodec); ok {
+		return val, nil
+	}
+	return codec.Marshal(val)
+}
+
+// decodeCacheValue store into v a value returned by encodeCacheValue
+func decodeCacheValue(codec Codec, stored interface{}, v interface{}) error {
+	if _, ok := codec.(noCodec); ok {
+		return assignCacheValue(stored, v)
+	}
+	return codec.Unmarshal(stored.([]byte), v)
+}
+
+// cacheValueSize of a value returned by encodeCacheValue
+func cacheValueSize(stored interface{}) int64 {
+	switch val := stored.(type) {
+	case []byte:
+		return int64(len(val))
+	case string:
+		return int64(len(val))
+	case CacheSizer:
+		return val.CacheSize()
+	default:
+		return 0
+	}
+}
+
+// assignCacheValue copy val into the value v point to. A pointer val is
+// dereferenced when v point to its element type
+func assignCacheValue(val interface{}, v interface{}) error {
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("cache: non-nil pointer expected, got %T", v)
+	}
+	dst = dst.Elem()
+
+	src := reflect.ValueOf(val)
+	if !src.IsValid() {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+	if src.Kind() == reflect.Ptr && !src.IsNil() && src.Elem().Type().AssignableTo(dst.Type()) {
+		dst.Set(src.Elem())
+		return nil
+	}
+	return fmt.Errorf("cache: can't assign %T to %T", val, v)
+}
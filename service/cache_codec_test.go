@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheCodecRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	deletedAt := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	shortURL := &ShortURL{
+		Id:        1,
+		FullURL:   "http://example.com",
+		Domain:    "example.com",
+		Code:      "123",
+		CreatedAt: deletedAt.AddDate(0, 0, -1),
+		DeletedAt: &deletedAt,
+	}
+
+	tests := []struct {
+		name     string
+		codec    Codec
+		faithful bool
+	}{
+		{name: "none", codec: NoCodec, faithful: true},
+		{name: "gob", codec: GobCodec, faithful: true},
+		// fields tagged `json:"-"` are lost
+		{name: "json", codec: JSONCodec, faithful: false},
+	}
+	for _, tc := range tests {
+		cache := NewTypedCache[*ShortURL](NewMemoryCacheStore(CacheStoreOption{Codec: tc.codec}))
+		if err := cache.Save(ctx, "123", shortURL); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got, err := cache.Get(ctx, "123")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		faithful := got.Id == shortURL.Id &&
+			got.Domain == shortURL.Domain &&
+			got.CreatedAt.Equal(shortURL.CreatedAt) &&
+			got.DeletedAt != nil && got.DeletedAt.Equal(deletedAt)
+		if faithful != tc.faithful {
+			t.Errorf("%s: expected faithful: %v, got: %+v", tc.name, tc.faithful, got)
+		}
+		if got.FullURL != shortURL.FullURL || got.Code != shortURL.Code {
+			t.Errorf("%s: unexpected short url: %+v", tc.name, got)
+		}
+	}
+}
+
+func TestTypedCacheMiss(t *testing.T) {
+	cache := NewTypedCache[ShortURL](NewMemoryCacheStore())
+	got, err := cache.Get(context.Background(), "123")
+	if err != ErrCacheKeyNotFound {
+		t.Errorf("expected: %v, got: %v", ErrCacheKeyNotFound, err)
+	}
+	if got != (ShortURL{}) {
+		t.Errorf("expected zero value, got: %+v", got)
+	}
+}
+
+func TestAssignCacheValue(t *testing.T) {
+	// pointer is dereferenced into its element type
+	var s ShortURL
+	if err := assignCacheValue(&ShortURL{Code: "123"}, &s); err != nil || s.Code != "123" {
+		t.Errorf("expected: %v, got: %v %v", "123", s.Code, err)
+	}
+
+	var value string
+	if err := assignCacheValue(1, &value); err == nil {
+		t.Error("expected type mismatch error")
+	}
+	if err := assignCacheValue("value", value); err == nil {
+		t.Error("expected non pointer error")
+	}
+}
+
+func TestNoCodecRedisCacheStore(t *testing.T) {
+	server := newFakeRedis(t, "")
+	store := NewRedisCacheStore(server.Addr(), "test:", RedisCacheOption{Codec: NoCodec})
+	defer store.Close()
+
+	if err := store.Save(context.Background(), "key1", "value1"); err != ErrNoCodec {
+		t.Errorf("expected: %v, got: %v", ErrNoCodec, err)
+	}
+}
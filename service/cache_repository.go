@@ -1,51 +1,476 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// WithCache decorate existing URLShortenerRepository with caching capability
-func WithCache(repo URLShortenerRepository, store CacheStore) URLShortenerRepository {
-	return &cacheRepository{
+// Default caching behavior
+const (
+	DEFAULT_CACHE_TTL            = 10 * time.Second
+	DEFAULT_NOT_FOUND_CACHE_TTL  = 2 * time.Second
+	DEFAULT_CACHE_LOOKUP_TIMEOUT = 5 * time.Second
+)
+
+// CacheRepositoryOption to modify caching policy
+type CacheRepositoryOption struct {
+	// TTL of a cached short url, capped by its remaining lifetime
+	TTL time.Duration
+	// NotFoundTTL of a code cached as not found
+	NotFoundTTL time.Duration
+	// Jitter add a random duration up to Jitter to TTL so entries
+	// cached together don't expire together
+	Jitter time.Duration
+	// StaleWhileRevalidate serve an entry for this long past its TTL
+	// while it is refreshed in background
+	StaleWhileRevalidate time.Duration
+	// Bus broadcast busted codes so caches of other instances evict them too
+	Bus InvalidationBus
+	// LookupTimeout bound a lookup shared by concurrent misses, it isn't
+	// canceled with the callers so it should match their request timeout
+	LookupTimeout time.Duration
+}
+
+// CacheRepositoryStats snapshot of cache repository counters. Store is
+// set when the cache store report its own counters such as evictions
+type CacheRepositoryStats struct {
+	Hits          int64 `json:"hits"`
+	StaleHits     int64 `json:"staleHits"`
+	NotFoundHits  int64 `json:"notFoundHits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+	// RemoteInvalidations received through the bus, own ones included
+	RemoteInvalidations int64 `json:"remoteInvalidations"`
+	// PublishErrors of busted codes other instances weren't told about,
+	// they serve the old value until it expires
+	PublishErrors int64 `json:"publishErrors"`
+	// SaveErrors of lookups which couldn't be cached, they are still
+	// served from the repository
+	SaveErrors int64 `json:"saveErrors"`
+	// Resets of the whole cache after the bus subscription was restored
+	Resets int64       `json:"resets"`
+	Store  *CacheStats `json:"store,omitempty"`
+}
+
+// CachedRepository is an URLShortenerRepository reporting cache counters
+type CachedRepository interface {
+	URLShortenerRepository
+	// Stats return current counters
+	Stats() CacheRepositoryStats
+	// Uncached return the decorated repository, for reads which must not be stale
+	Uncached() URLShortenerRepository
+}
+
+// WithCache decorate existing URLShortenerRepository with caching capability.
+// Concurrent misses on the same code share a single lookup and codes which
+// don't exist are remembered briefly so scans don't reach the database.
+func WithCache(repo URLShortenerRepository, store CacheStore, opts ...CacheRepositoryOption) CachedRepository {
+	r := &cacheRepository{
 		URLShortenerRepository: repo,
 		store:                  store,
+		cache:                  NewTypedCache[cacheEntry](store),
+		ttl:                    DEFAULT_CACHE_TTL,
+		notFoundTTL:            DEFAULT_NOT_FOUND_CACHE_TTL,
+		lookupTimeout:          DEFAULT_CACHE_LOOKUP_TIMEOUT,
+		calls:                  make(map[string]*findCall),
+	}
+	if len(opts) > 0 {
+		if opts[0].TTL > 0 {
+			r.ttl = opts[0].TTL
+		}
+		if opts[0].NotFoundTTL > 0 {
+			r.notFoundTTL = opts[0].NotFoundTTL
+		}
+		if opts[0].Jitter > 0 {
+			r.jitter = opts[0].Jitter
+		}
+		if opts[0].StaleWhileRevalidate > 0 {
+			r.staleWhileRevalidate = opts[0].StaleWhileRevalidate
+		}
+		if opts[0].LookupTimeout > 0 {
+			r.lookupTimeout = opts[0].LookupTimeout
+		}
+		r.bus = opts[0].Bus
+	}
+	if r.bus != nil {
+		r.bus.Subscribe(r.evictRemote)
+		r.bus.OnReconnect(r.clearLocal)
+	}
+	return r
+}
+
+// cacheEntry is the cached value, NotFound mark a negative entry and
+// StaleAt is set when the entry is kept past its TTL to be revalidated
+type cacheEntry struct {
+	ShortURL *ShortURL
+	NotFound bool
+	StaleAt  *time.Time
+}
+
+// cachedShortURL has the fields of ShortURL, none of them hidden from json
+type cachedShortURL struct {
+	Id        int64      `json:"id"`
+	FullURL   string     `json:"fullUrl"`
+	Domain    string     `json:"domain"`
+	Code      string     `json:"code"`
+	HitCount  int64      `json:"hitCount"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	BlockedAt *time.Time `json:"blockedAt,omitempty"`
+	BlockedBy string     `json:"blockedBy,omitempty"`
+}
+
+// cacheEntryJSON is the json form of cacheEntry
+type cacheEntryJSON struct {
+	ShortURL *cachedShortURL `json:"shortURL,omitempty"`
+	NotFound bool            `json:"notFound,omitempty"`
+	StaleAt  *time.Time      `json:"staleAt,omitempty"`
+}
+
+// MarshalJSON keep every field of the short url so JSONCodec round trip it
+func (e cacheEntry) MarshalJSON() ([]byte, error) {
+	v := cacheEntryJSON{NotFound: e.NotFound, StaleAt: e.StaleAt}
+	if e.ShortURL != nil {
+		shortURL := cachedShortURL(*e.ShortURL)
+		v.ShortURL = &shortURL
 	}
+	return json.Marshal(v)
+}
+
+func (e *cacheEntry) UnmarshalJSON(data []byte) error {
+	var v cacheEntryJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = cacheEntry{NotFound: v.NotFound, StaleAt: v.StaleAt}
+	if v.ShortURL != nil {
+		shortURL := ShortURL(*v.ShortURL)
+		e.ShortURL = &shortURL
+	}
+	return nil
+}
+
+// CacheSize estimate memory held by the entry when kept without codec
+func (e cacheEntry) CacheSize() int64 {
+	size := int64(64)
+	if e.ShortURL != nil {
+		size += int64(128 + len(e.ShortURL.FullURL) + len(e.ShortURL.Domain) + len(e.ShortURL.Code))
+	}
+	return size
+}
+
+// findCall is a lookup in flight shared by concurrent misses
+type findCall struct {
+	done     chan struct{}
+	shortURL *ShortURL
+	err      error
 }
 
 type cacheRepository struct {
 	URLShortenerRepository
-	store CacheStore
+	store                CacheStore
+	cache                TypedCache[cacheEntry]
+	ttl                  time.Duration
+	notFoundTTL          time.Duration
+	jitter               time.Duration
+	staleWhileRevalidate time.Duration
+	lookupTimeout        time.Duration
+
+	hits          int64
+	staleHits     int64
+	notFoundHits  int64
+	misses        int64
+	invalidations int64
+
+	remoteInvalidations int64
+	publishErrors       int64
+	saveErrors          int64
+	resets              int64
+	bus                 InvalidationBus
+
+	mux   sync.Mutex
+	calls map[string]*findCall
 }
 
-// This method implemented a simple expiring cache mechanism for demonstration purposes
-func (s *cacheRepository) FindShortURL(code string) (*ShortURL, error) {
-	if cache := s.getCache(code); cache != nil {
-		return cache, nil
+func (s *cacheRepository) FindShortURL(ctx context.Context, code string) (*ShortURL, error) {
+	if entry := s.getCache(ctx, code); entry != nil {
+		if entry.NotFound {
+			atomic.AddInt64(&s.notFoundHits, 1)
+			return nil, ErrRecordNotFound
+		}
+		if entry.StaleAt != nil && entry.StaleAt.Before(time.Now()) {
+			atomic.AddInt64(&s.staleHits, 1)
+			s.load(ctx, code)
+		} else {
+			atomic.AddInt64(&s.hits, 1)
+		}
+		// stores without codec share the cached instance, callers may modify it
+		shortURL := *entry.ShortURL
+		return &shortURL, nil
 	}
 
-	shortURL, err := s.URLShortenerRepository.FindShortURL(code)
+	atomic.AddInt64(&s.misses, 1)
+	call := s.load(ctx, code)
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	// every caller get its own copy as callers may modify it
+	shortURL := *call.shortURL
+	return &shortURL, nil
+}
+
+func (s *cacheRepository) Stats() CacheRepositoryStats {
+	stats := CacheRepositoryStats{
+		Hits:          atomic.LoadInt64(&s.hits),
+		StaleHits:     atomic.LoadInt64(&s.staleHits),
+		NotFoundHits:  atomic.LoadInt64(&s.notFoundHits),
+		Misses:        atomic.LoadInt64(&s.misses),
+		Invalidations: atomic.LoadInt64(&s.invalidations),
+
+		RemoteInvalidations: atomic.LoadInt64(&s.remoteInvalidations),
+		PublishErrors:       atomic.LoadInt64(&s.publishErrors),
+		SaveErrors:          atomic.LoadInt64(&s.saveErrors),
+		Resets:              atomic.LoadInt64(&s.resets),
+	}
+	if store, ok := s.store.(interface{ Stats() CacheStats }); ok {
+		storeStats := store.Stats()
+		stats.Store = &storeStats
+	}
+	return stats
+}
+
+func (s *cacheRepository) Uncached() URLShortenerRepository {
+	return s.URLShortenerRepository
+}
+
+// load return the lookup in flight for code, starting one if there is none
+func (s *cacheRepository) load(ctx context.Context, code string) *findCall {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	call, ok := s.calls[code]
+	if !ok {
+		call = &findCall{done: make(chan struct{})}
+		s.calls[code] = call
+		// lookup outlive the first caller so its cancellation doesn't fail the others,
+		// it is bounded on its own so a stuck one isn't waited on forever
+		go func() {
+			ctx, cancel := context.WithTimeout(detachedContext{ctx}, s.lookupTimeout)
+			defer cancel()
+			s.find(ctx, code, call)
+		}()
+	}
+	return call
+}
+
+// find load code from the underlying repository and cache the outcome
+func (s *cacheRepository) find(ctx context.Context, code string, call *findCall) {
+	defer func() {
+		s.mux.Lock()
+		delete(s.calls, code)
+		s.mux.Unlock()
+		close(call.done)
+	}()
+
+	call.shortURL, call.err = s.URLShortenerRepository.FindShortURL(ctx, code)
+	// a cache failure doesn't fail the lookup, the next one reach the repository again
+	var err error
+	switch call.err {
+	case nil:
+		err = s.saveCache(ctx, call.shortURL)
+	case ErrRecordNotFound:
+		err = s.cache.Save(ctx, code, cacheEntry{NotFound: true}, CacheOption{
+			ExpiresIn: s.notFoundTTL,
+		})
+	}
 	if err != nil {
-		return nil, err
+		atomic.AddInt64(&s.saveErrors, 1)
+	}
+}
+
+// saveCache cache shortURL for TTL plus jitter, kept for the stale window
+// afterward. Neither outlive a short url which is about to expire
+func (s *cacheRepository) saveCache(ctx context.Context, shortURL *ShortURL) error {
+	now := time.Now()
+	ttl := s.ttl
+	if s.jitter > 0 {
+		ttl += time.Duration(rand.Int63n(int64(s.jitter)))
+	}
+	expiresIn := ttl + s.staleWhileRevalidate
+	// already expired short url is cached as is, its state won't change
+	if shortURL.ExpiresAt != nil && shortURL.ExpiresAt.After(now) {
+		remaining := shortURL.ExpiresAt.Sub(now)
+		if ttl > remaining {
+			ttl = remaining
+		}
+		if expiresIn > remaining {
+			expiresIn = remaining
+		}
+	}
+
+	entry := cacheEntry{ShortURL: shortURL}
+	if expiresIn > ttl {
+		staleAt := now.Add(ttl).UTC()
+		entry.StaleAt = &staleAt
+	}
+	return s.cache.Save(ctx, shortURL.Code, entry, CacheOption{ExpiresIn: expiresIn})
+}
+
+// Cache busting on create, the code may be cached as not found
+func (s *cacheRepository) CreateShortURL(ctx context.Context, shortURL *ShortURL) error {
+	if err := s.URLShortenerRepository.CreateShortURL(ctx, shortURL); err != nil {
+		return err
+	}
+	s.bust(ctx, shortURL.Code)
+	return nil
+}
+
+// Cache busting on update
+func (s *cacheRepository) UpdateShortURL(ctx context.Context, shortURL *ShortURL) error {
+	s.cache.Delete(ctx, shortURL.Code)
+	if err := s.URLShortenerRepository.UpdateShortURL(ctx, shortURL); err != nil {
+		return err
+	}
+	// bust again in case a concurrent read cached the old value
+	s.bust(ctx, shortURL.Code)
+	return nil
+}
+
+// Cache busting on delete
+func (s *cacheRepository) DeleteShortURL(ctx context.Context, code string) error {
+	if err := s.URLShortenerRepository.DeleteShortURL(ctx, code); err != nil {
+		return err
 	}
-	err = s.store.Save(shortURL.Code, shortURL, CacheOption{
-		ExpiresIn: 10 * time.Second,
-	})
+	s.bust(ctx, code)
+	return nil
+}
+
+// Cache busting of every purged code
+func (s *cacheRepository) PurgeShortURLs(ctx context.Context, deletedBefore time.Time) ([]string, error) {
+	codes, err := s.URLShortenerRepository.PurgeShortURLs(ctx, deletedBefore)
 	if err != nil {
 		return nil, err
 	}
-	return shortURL, nil
+	for _, code := range codes {
+		s.bust(ctx, code)
+	}
+	return codes, nil
 }
 
-// Cache busting on update
-func (s *cacheRepository) UpdateShortURL(shortURL *ShortURL) error {
-	s.store.Delete(shortURL.Code)
-	return s.URLShortenerRepository.UpdateShortURL(shortURL)
+// Cache busting on blacklist flag change
+func (s *cacheRepository) SetShortURLBlocked(ctx context.Context, code string, blockedAt *time.Time, blockedBy string) error {
+	s.cache.Delete(ctx, code)
+	if err := s.URLShortenerRepository.SetShortURLBlocked(ctx, code, blockedAt, blockedBy); err != nil {
+		return err
+	}
+	s.bust(ctx, code)
+	return nil
 }
 
-func (s *cacheRepository) getCache(key string) *ShortURL {
-	var shortURL ShortURL
-	if err := s.store.Get(key, &shortURL); err != nil {
+// Cache busting on disable
+func (s *cacheRepository) DisableShortURL(ctx context.Context, code string, deletedAt time.Time) (bool, error) {
+	s.cache.Delete(ctx, code)
+	disabled, err := s.URLShortenerRepository.DisableShortURL(ctx, code, deletedAt)
+	if err != nil || !disabled {
+		return disabled, err
+	}
+	s.bust(ctx, code)
+	return true, nil
+}
+
+// bust evict code locally then announce it to other instances. The write is
+// already committed so failures are not returned, a publish error is counted
+func (s *cacheRepository) bust(ctx context.Context, code string) {
+	if err := s.evict(ctx, code); err != nil {
+		// don't keep a value which may be stale because the wait timed out
+		s.cache.Delete(context.Background(), code)
+	}
+	atomic.AddInt64(&s.invalidations, 1)
+	if s.bus != nil {
+		if err := s.bus.Publish(ctx, code); err != nil {
+			atomic.AddInt64(&s.publishErrors, 1)
+		}
+	}
+}
+
+// clearLocal drop every cached code once invalidations may have been missed.
+// Stores shared by instances get deletes directly and aren't cleared
+func (s *cacheRepository) clearLocal() {
+	store, ok := s.store.(interface{ Clear(context.Context) error })
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_REDIS_IO_TIMEOUT)
+	defer cancel()
+
+	if err := store.Clear(ctx); err == nil {
+		atomic.AddInt64(&s.resets, 1)
+	}
+}
+
+// evictRemote evict a code busted by any instance, own ones included
+func (s *cacheRepository) evictRemote(code string) {
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_REDIS_IO_TIMEOUT)
+	defer cancel()
+
+	atomic.AddInt64(&s.remoteInvalidations, 1)
+	if err := s.evict(ctx, code); err != nil {
+		// don't keep a value which may be stale because the wait timed out
+		s.cache.Delete(context.Background(), code)
+	}
+}
+
+// evict wait for a lookup in flight, which may have read the old value,
+// to be cached before deleting the key
+func (s *cacheRepository) evict(ctx context.Context, code string) error {
+	s.mux.Lock()
+	call, ok := s.calls[code]
+	s.mux.Unlock()
+
+	if ok {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.cache.Delete(ctx, code)
+}
+
+func (s *cacheRepository) getCache(ctx context.Context, key string) *cacheEntry {
+	entry, err := s.cache.Get(ctx, key)
+	if err != nil {
+		return nil
+	}
+	if entry.ShortURL == nil && !entry.NotFound {
 		return nil
 	}
-	return &shortURL
+	return &entry
+}
+
+// detachedContext keep values of parent context but is never canceled
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
 }
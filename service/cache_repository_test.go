@@ -0,0 +1,464 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCacheRepositoryCoalesce(t *testing.T) {
+	repo := new(mockRepo)
+	cached := WithCache(repo, NewMemoryCacheStore())
+
+	repo.On("FindShortURL", "123").
+		After(50*time.Millisecond).
+		Return(&ShortURL{Code: "123", FullURL: "http://example.com"}, nil).
+		Once()
+
+	var wg sync.WaitGroup
+	results := make([]*ShortURL, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := cached.FindShortURL(context.Background(), "123")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results[i] = s
+		}(i)
+	}
+	wg.Wait()
+
+	repo.AssertNumberOfCalls(t, "FindShortURL", 1)
+	// callers don't share the same instance
+	results[0].HitCount = 10
+	for _, s := range results[1:] {
+		if s == nil || s.HitCount != 0 || s.FullURL != "http://example.com" {
+			t.Errorf("unexpected short url: %+v", s)
+		}
+	}
+}
+
+func TestCacheRepositoryNotFound(t *testing.T) {
+	repo := new(mockRepo)
+	cached := WithCache(repo, NewMemoryCacheStore())
+	ctx := context.Background()
+
+	repo.On("FindShortURL", "123").Return(nil, ErrRecordNotFound).Once()
+	for i := 0; i < 3; i++ {
+		if _, err := cached.FindShortURL(ctx, "123"); err != ErrRecordNotFound {
+			t.Errorf("expected: %v, got: %v", ErrRecordNotFound, err)
+		}
+	}
+	repo.AssertNumberOfCalls(t, "FindShortURL", 1)
+
+	// creating the code invalidate the negative entry
+	shortURL := &ShortURL{Code: "123", FullURL: "http://example.com"}
+	repo.On("CreateShortURL", shortURL).Return(nil).Once()
+	repo.On("FindShortURL", "123").Return(shortURL, nil).Once()
+	if err := cached.CreateShortURL(ctx, shortURL); err != nil {
+		t.Fatal(err)
+	}
+	s, err := cached.FindShortURL(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.FullURL != shortURL.FullURL {
+		t.Errorf("expected: %v, got: %v", shortURL.FullURL, s.FullURL)
+	}
+}
+
+func TestCacheRepositoryError(t *testing.T) {
+	repo := new(mockRepo)
+	cached := WithCache(repo, NewMemoryCacheStore())
+
+	// failures other than not found are not cached
+	repo.On("FindShortURL", "123").Return(nil, errors.New("database is locked")).Twice()
+	for i := 0; i < 2; i++ {
+		if _, err := cached.FindShortURL(context.Background(), "123"); err == nil {
+			t.Error("expected error")
+		}
+	}
+	repo.AssertNumberOfCalls(t, "FindShortURL", 2)
+}
+
+func TestCacheRepositoryCanceled(t *testing.T) {
+	repo := new(mockRepo)
+	cached := WithCache(repo, NewMemoryCacheStore())
+
+	repo.On("FindShortURL", "123").
+		After(50*time.Millisecond).
+		Return(&ShortURL{Code: "123"}, nil).
+		Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cached.FindShortURL(ctx, "123"); err != context.Canceled {
+		t.Errorf("expected: %v, got: %v", context.Canceled, err)
+	}
+
+	// the lookup started by the canceled caller still complete for others
+	if _, err := cached.FindShortURL(context.Background(), "123"); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	repo.AssertNumberOfCalls(t, "FindShortURL", 1)
+}
+
+// stuckRepo never answer lookups before their context is done
+type stuckRepo struct {
+	URLShortenerRepository
+}
+
+func (r stuckRepo) FindShortURL(ctx context.Context, code string) (*ShortURL, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCacheRepositoryLookupTimeout(t *testing.T) {
+	cached := WithCache(stuckRepo{}, NewMemoryCacheStore(), CacheRepositoryOption{LookupTimeout: 50 * time.Millisecond})
+
+	// the shared lookup is canceled even though the caller isn't
+	for i := 0; i < 2; i++ {
+		if _, err := cached.FindShortURL(context.Background(), "123"); err != context.DeadlineExceeded {
+			t.Errorf("expected: %v, got: %v", context.DeadlineExceeded, err)
+		}
+	}
+}
+
+// expiryCacheStore record expiry of saved keys
+type expiryCacheStore struct {
+	CacheStore
+	mux       sync.Mutex
+	expiresIn map[string]time.Duration
+}
+
+func newExpiryCacheStore() *expiryCacheStore {
+	return &expiryCacheStore{
+		CacheStore: NewMemoryCacheStore(),
+		expiresIn:  make(map[string]time.Duration),
+	}
+}
+
+func (c *expiryCacheStore) Save(ctx context.Context, key string, val interface{}, opts ...CacheOption) error {
+	c.mux.Lock()
+	c.expiresIn[key] = opts[0].ExpiresIn
+	c.mux.Unlock()
+	return c.CacheStore.Save(ctx, key, val, opts...)
+}
+
+func (c *expiryCacheStore) ExpiresIn(key string) time.Duration {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.expiresIn[key]
+}
+
+func TestCacheRepositoryTTL(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	store := newExpiryCacheStore()
+	cached := WithCache(repo, store, CacheRepositoryOption{
+		TTL:         time.Minute,
+		NotFoundTTL: 5 * time.Second,
+		Jitter:      10 * time.Second,
+	})
+
+	expiresAt := time.Now().Add(20 * time.Second).UTC()
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123"}, nil).Once()
+	repo.On("FindShortURL", "456").Return(&ShortURL{Code: "456", ExpiresAt: &expiresAt}, nil).Once()
+	repo.On("FindShortURL", "789").Return(nil, ErrRecordNotFound).Once()
+	for _, code := range []string{"123", "456", "789"} {
+		cached.FindShortURL(ctx, code)
+	}
+
+	if ttl := store.ExpiresIn("123"); ttl < time.Minute || ttl >= time.Minute+10*time.Second {
+		t.Errorf("expected ttl with jitter, got: %v", ttl)
+	}
+	// capped by remaining lifetime of the short url
+	if ttl := store.ExpiresIn("456"); ttl > 20*time.Second || ttl < 19*time.Second {
+		t.Errorf("expected ttl capped by expiry, got: %v", ttl)
+	}
+	if ttl := store.ExpiresIn("789"); ttl != 5*time.Second {
+		t.Errorf("expected: %v, got: %v", 5*time.Second, ttl)
+	}
+}
+
+func TestCacheRepositoryStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	store := newExpiryCacheStore()
+	cached := WithCache(repo, store, CacheRepositoryOption{
+		TTL:                  20 * time.Millisecond,
+		StaleWhileRevalidate: time.Minute,
+	})
+
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123", FullURL: "http://example.com"}, nil).Once()
+	cached.FindShortURL(ctx, "123")
+	if ttl := store.ExpiresIn("123"); ttl != time.Minute+20*time.Millisecond {
+		t.Errorf("expected entry kept for stale window, got: %v", ttl)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// stale value is served right away and refreshed in background
+	refreshed := make(chan struct{})
+	repo.On("FindShortURL", "123").
+		Run(func(mock.Arguments) { close(refreshed) }).
+		Return(&ShortURL{Code: "123", FullURL: "http://example1.com"}, nil).
+		Once()
+	s, err := cached.FindShortURL(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.FullURL != "http://example.com" {
+		t.Errorf("expected stale value, got: %v", s.FullURL)
+	}
+	<-refreshed
+
+	stats := cached.Stats()
+	if stats.Misses != 1 || stats.StaleHits != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheRepositoryStats(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	store := NewLRUCacheStore(LRUOption{MaxEntries: 1})
+	defer store.Close()
+	cached := WithCache(repo, store)
+
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123"}, nil).Once()
+	repo.On("FindShortURL", "456").Return(nil, ErrRecordNotFound).Once()
+	repo.On("DeleteShortURL", "123").Return(nil).Once()
+	for _, code := range []string{"123", "123", "456", "456"} {
+		cached.FindShortURL(ctx, code)
+	}
+	cached.DeleteShortURL(ctx, "123")
+
+	stats := cached.Stats()
+	expected := CacheRepositoryStats{
+		Hits:          1,
+		NotFoundHits:  1,
+		Misses:        2,
+		Invalidations: 1,
+		Store:         &CacheStats{Entries: 1, Bytes: stats.Store.Bytes, Hits: 2, Misses: 2, Evictions: 1},
+	}
+	if stats.Store == nil || *stats.Store != *expected.Store {
+		t.Errorf("unexpected store stats: %+v", stats.Store)
+	}
+	stats.Store = expected.Store
+	if stats != expected {
+		t.Errorf("expected: %+v, got: %+v", expected, stats)
+	}
+}
+
+func TestCacheRepositoryPurge(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	bus := NewLocalInvalidationBus()
+	cached1 := WithCache(repo, NewMemoryCacheStore(), CacheRepositoryOption{Bus: bus})
+	cached2 := WithCache(repo, NewMemoryCacheStore(), CacheRepositoryOption{Bus: bus})
+
+	deletedAt := time.Now().Add(-time.Hour)
+	for _, code := range []string{"123", "456"} {
+		repo.On("FindShortURL", code).Return(&ShortURL{Code: code, DeletedAt: &deletedAt}, nil).Twice()
+		cached1.FindShortURL(ctx, code)
+		cached2.FindShortURL(ctx, code)
+	}
+
+	// every purged code is evicted from every instance
+	deletedBefore := time.Now()
+	repo.On("PurgeShortURLs", deletedBefore).Return([]string{"123", "456"}, nil).Once()
+	if _, err := cached1.PurgeShortURLs(ctx, deletedBefore); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"123", "456"} {
+		repo.On("FindShortURL", code).Return(nil, ErrRecordNotFound).Once()
+		if _, err := cached2.FindShortURL(ctx, code); err != ErrRecordNotFound {
+			t.Errorf("expected: %v, got: %v", ErrRecordNotFound, err)
+		}
+	}
+	if stats := cached1.Stats(); stats.Invalidations != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	repo.AssertExpectations(t)
+}
+
+func TestCacheRepositoryInvalidationBus(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	bus := NewLocalInvalidationBus()
+	// two instances with their own cache sharing a database
+	cached1 := WithCache(repo, NewMemoryCacheStore(), CacheRepositoryOption{Bus: bus})
+	cached2 := WithCache(repo, NewMemoryCacheStore(), CacheRepositoryOption{Bus: bus})
+
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123", FullURL: "http://example.com"}, nil).Twice()
+	cached1.FindShortURL(ctx, "123")
+	cached2.FindShortURL(ctx, "123")
+
+	// update on one instance evict the code on the other
+	shortURL := &ShortURL{Code: "123", FullURL: "http://example1.com"}
+	repo.On("UpdateShortURL", shortURL).Return(nil).Once()
+	repo.On("FindShortURL", "123").Return(shortURL, nil).Once()
+	cached2.UpdateShortURL(ctx, shortURL)
+
+	s, err := cached1.FindShortURL(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.FullURL != shortURL.FullURL {
+		t.Errorf("expected: %v, got: %v", shortURL.FullURL, s.FullURL)
+	}
+	if stats := cached1.Stats(); stats.RemoteInvalidations != 1 || stats.Misses != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheRepositoryCodecs(t *testing.T) {
+	ctx := context.Background()
+	deletedAt := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	shortURL := &ShortURL{
+		Id:        1,
+		FullURL:   "http://example.com",
+		Domain:    "example.com",
+		Code:      "123",
+		CreatedAt: deletedAt.AddDate(0, 0, -1),
+		DeletedAt: &deletedAt,
+	}
+
+	for _, codec := range []Codec{NoCodec, GobCodec, JSONCodec} {
+		repo := new(mockRepo)
+		cached := WithCache(repo, NewMemoryCacheStore(CacheStoreOption{Codec: codec}))
+		repo.On("FindShortURL", "123").Return(shortURL, nil).Once()
+
+		// second lookup is served from cache
+		for i := 0; i < 2; i++ {
+			s, err := cached.FindShortURL(ctx, "123")
+			if err != nil {
+				t.Fatalf("%T: %v", codec, err)
+			}
+			if s.Id != shortURL.Id || s.Domain != shortURL.Domain || !s.CreatedAt.Equal(shortURL.CreatedAt) ||
+				s.DeletedAt == nil || !s.DeletedAt.Equal(deletedAt) {
+				t.Errorf("%T: unexpected short url: %+v", codec, s)
+			}
+		}
+		repo.AssertNumberOfCalls(t, "FindShortURL", 1)
+	}
+}
+
+func TestCacheRepositoryUncached(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	cached := WithCache(repo, NewMemoryCacheStore())
+	svc := NewURLShortener(cached)
+
+	repo.On("FindShortURL", "123").Return(&ShortURL{Id: 1, Code: "123", FullURL: "http://example.com"}, nil).Once()
+	cached.FindShortURL(ctx, "123")
+
+	// update read the current record rather than the cached one
+	current := &ShortURL{Id: 1, Code: "123", FullURL: "http://example1.com"}
+	repo.On("FindShortURL", "123").Return(current, nil).Once()
+	repo.On("UpdateShortURL", mock.Anything).Return(nil).Once()
+	if err := svc.Delete(ctx, "123"); err != nil {
+		t.Fatal(err)
+	}
+	updated := repo.Calls[len(repo.Calls)-1].Arguments.Get(0).(*ShortURL)
+	if updated.FullURL != current.FullURL || updated.DeletedAt == nil {
+		t.Errorf("unexpected update: %+v", updated)
+	}
+	repo.AssertNumberOfCalls(t, "FindShortURL", 2)
+}
+
+// failingBus deliver keys locally but fail to publish them
+type failingBus struct {
+	InvalidationBus
+}
+
+func (b failingBus) Publish(ctx context.Context, key string) error {
+	return ErrRedisClosed
+}
+
+func TestCacheRepositoryPublishError(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	cached := WithCache(repo, NewMemoryCacheStore(), CacheRepositoryOption{Bus: failingBus{NewLocalInvalidationBus()}})
+
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123", FullURL: "http://example.com"}, nil).Once()
+	cached.FindShortURL(ctx, "123")
+
+	// committed update isn't reported as failed, the code is still evicted locally
+	shortURL := &ShortURL{Code: "123", FullURL: "http://example1.com"}
+	repo.On("UpdateShortURL", shortURL).Return(nil).Once()
+	if err := cached.UpdateShortURL(ctx, shortURL); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	repo.On("FindShortURL", "123").Return(shortURL, nil).Once()
+	if s, _ := cached.FindShortURL(ctx, "123"); s == nil || s.FullURL != shortURL.FullURL {
+		t.Errorf("unexpected short url: %+v", s)
+	}
+	if stats := cached.Stats(); stats.PublishErrors != 1 || stats.Invalidations != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// failingCacheStore fail every write like an unreachable redis
+type failingCacheStore struct {
+	CacheStore
+}
+
+func (c failingCacheStore) Save(ctx context.Context, key string, val interface{}, opts ...CacheOption) error {
+	return ErrRedisClosed
+}
+
+func TestCacheRepositorySaveError(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	cached := WithCache(repo, failingCacheStore{NewMemoryCacheStore()})
+
+	// lookups are served from the repository while the cache can't be written
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123", FullURL: "http://example.com"}, nil).Twice()
+	for i := 0; i < 2; i++ {
+		s, err := cached.FindShortURL(ctx, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.FullURL != "http://example.com" {
+			t.Errorf("unexpected short url: %+v", s)
+		}
+	}
+	repo.On("FindShortURL", "456").Return(nil, ErrRecordNotFound).Once()
+	if _, err := cached.FindShortURL(ctx, "456"); err != ErrRecordNotFound {
+		t.Errorf("expected: %v, got: %v", ErrRecordNotFound, err)
+	}
+	repo.AssertNumberOfCalls(t, "FindShortURL", 3)
+	if stats := cached.Stats(); stats.SaveErrors != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheRepositoryReconnect(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mockRepo)
+	bus := &localInvalidationBus{}
+	store := NewLRUCacheStore()
+	defer store.Close()
+	cached := WithCache(repo, store, CacheRepositoryOption{Bus: bus})
+
+	repo.On("FindShortURL", "123").Return(&ShortURL{Code: "123", FullURL: "http://example.com"}, nil).Twice()
+	cached.FindShortURL(ctx, "123")
+	cached.FindShortURL(ctx, "123")
+	repo.AssertNumberOfCalls(t, "FindShortURL", 1)
+
+	// invalidations may have been missed while the subscription was down
+	bus.reconnected()
+	cached.FindShortURL(ctx, "123")
+	repo.AssertNumberOfCalls(t, "FindShortURL", 2)
+	if stats := cached.Stats(); stats.Resets != 1 || stats.Store.Entries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
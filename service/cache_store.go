@@ -1,7 +1,7 @@
 package service
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -15,40 +15,57 @@ type CacheOption struct {
 	ExpiresIn time.Duration
 }
 
+// CacheStoreOption to modify cache store behavior
+type CacheStoreOption struct {
+	// Codec encode values, default to NoCodec which keep values as is.
+	// Cached values are then shared so callers must not modify them
+	Codec Codec
+}
+
 // CacheStore public api
 type CacheStore interface {
-	Save(key string, val interface{}, opts ...CacheOption) error
-	Get(key string, v interface{}) error
-	Delete(key string) error
+	Save(ctx context.Context, key string, val interface{}, opts ...CacheOption) error
+	Get(ctx context.Context, key string, v interface{}) error
+	Delete(ctx context.Context, key string) error
 }
 
 // NewMemoryCacheStore factory function
-func NewMemoryCacheStore() CacheStore {
-	return &memoryCacheStore{
+func NewMemoryCacheStore(opts ...CacheStoreOption) CacheStore {
+	c := &memoryCacheStore{
+		codec:  NoCodec,
 		values: make(map[string]*memoryCacheValue),
 	}
+	if len(opts) > 0 && opts[0].Codec != nil {
+		c.codec = opts[0].Codec
+	}
+	return c
 }
 
 type memoryCacheValue struct {
-	value     []byte
+	value     interface{}
 	expiredAt *time.Time
 }
 
 type memoryCacheStore struct {
+	codec  Codec
 	mux    sync.Mutex
 	values map[string]*memoryCacheValue
 }
 
-func (c *memoryCacheStore) Save(key string, val interface{}, opts ...CacheOption) error {
+func (c *memoryCacheStore) Save(ctx context.Context, key string, val interface{}, opts ...CacheOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
-	buf, err := json.Marshal(val)
+	encoded, err := encodeCacheValue(c.codec, val)
 	if err != nil {
 		return err
 	}
 
-	value := memoryCacheValue{value: buf}
+	value := memoryCacheValue{value: encoded}
 	if len(opts) > 0 {
 		expiredAt := time.Now().Add(opts[0].ExpiresIn).UTC()
 		value.expiredAt = &expiredAt
@@ -58,7 +75,11 @@ func (c *memoryCacheStore) Save(key string, val interface{}, opts ...CacheOption
 	return nil
 }
 
-func (c *memoryCacheStore) Get(key string, v interface{}) error {
+func (c *memoryCacheStore) Get(ctx context.Context, key string, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
@@ -70,13 +91,22 @@ func (c *memoryCacheStore) Get(key string, v interface{}) error {
 		delete(c.values, key)
 		return ErrCacheKeyNotFound
 	}
-	return json.Unmarshal(val.value, v)
+	return decodeCacheValue(c.codec, val.value, v)
 }
 
-func (c *memoryCacheStore) Delete(key string) error {
+func (c *memoryCacheStore) Delete(ctx context.Context, key string) error {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
 	delete(c.values, key)
 	return nil
 }
+
+// Clear delete every key
+func (c *memoryCacheStore) Clear(ctx context.Context) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.values = make(map[string]*memoryCacheValue)
+	return nil
+}
@@ -1,27 +1,30 @@
 package service
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
 )
 
-type MemoryCacheStoreSuite struct {
+// CacheStoreSuite run against any CacheStore implementation
+type CacheStoreSuite struct {
 	suite.Suite
-	store CacheStore
+	newStore func() CacheStore
+	store    CacheStore
 }
 
-func (suite *MemoryCacheStoreSuite) SetupSuite() {
-	suite.store = NewMemoryCacheStore()
+func (suite *CacheStoreSuite) SetupSuite() {
+	suite.store = suite.newStore()
 }
 
-func (suite *MemoryCacheStoreSuite) TestCacheStore() {
-	suite.store.Save("key1", "value1")
-	suite.store.Save("key2", "value2")
-	suite.store.Save("key3", "value3", CacheOption{
+func (suite *CacheStoreSuite) TestCacheStore() {
+	suite.store.Save(context.Background(), "key1", "value1")
+	suite.store.Save(context.Background(), "key2", "value2")
+	suite.store.Save(context.Background(), "key3", "value3", CacheOption{
 		ExpiresIn: 0,
 	})
-	suite.store.Delete("key2")
+	suite.store.Delete(context.Background(), "key2")
 
 	type test struct {
 		key   string
@@ -37,12 +40,28 @@ func (suite *MemoryCacheStoreSuite) TestCacheStore() {
 	}
 	for _, tc := range tests {
 		var value string
-		err := suite.store.Get(tc.key, &value)
+		err := suite.store.Get(context.Background(), tc.key, &value)
 		suite.Equal(tc.value, value)
 		suite.Equal(tc.err, err)
 	}
 }
 
+func (suite *CacheStoreSuite) TestCanceledContext() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var value string
+	suite.Equal(context.Canceled, suite.store.Save(ctx, "key5", "value5"))
+	suite.Equal(context.Canceled, suite.store.Get(ctx, "key1", &value))
+}
+
 func TestMemoryCacheStore(t *testing.T) {
-	suite.Run(t, new(MemoryCacheStoreSuite))
+	for name, codec := range map[string]Codec{"none": NoCodec, "json": JSONCodec, "gob": GobCodec} {
+		codec := codec
+		t.Run(name, func(t *testing.T) {
+			suite.Run(t, &CacheStoreSuite{newStore: func() CacheStore {
+				return NewMemoryCacheStore(CacheStoreOption{Codec: codec})
+			}})
+		})
+	}
 }
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ClickFilterParams input to filter ClickEvent
+type ClickFilterParams struct {
+	From *time.Time
+	To   *time.Time
+}
+
+// Time intervals to aggregate click events
+const (
+	IntervalHour = "hour"
+	IntervalDay  = "day"
+	IntervalWeek = "week"
+)
+
+// StatsParams input to aggregate ClickEvent
+type StatsParams struct {
+	Interval string
+	Filter   *ClickFilterParams
+	// TopSize limit number of top referrers and user agents
+	TopSize int
+}
+
+// ClickEventRepository to interact with click event data store
+type ClickEventRepository interface {
+	CreateClickEvent(ctx context.Context, event *ClickEvent) error
+	// CreateClickEvents store events at once, events of short urls which
+	// don't exist anymore are dropped
+	CreateClickEvents(ctx context.Context, events []*ClickEvent) error
+	ListClickEvents(ctx context.Context, code string, offset, size int64, filters ...*ClickFilterParams) ([]*ClickEvent, int64, error)
+	ClickStats(ctx context.Context, code string, params StatsParams) (*ClickStats, error)
+}
+
+// sqlite expressions truncating created_at to the start of each interval.
+// weeks start on monday
+var sqliteBucketExprs = map[string]string{
+	IntervalHour: "strftime('%Y-%m-%d %H:00:00', created_at)",
+	IntervalDay:  "strftime('%Y-%m-%d 00:00:00', created_at)",
+	IntervalWeek: "strftime('%Y-%m-%d 00:00:00', created_at, 'weekday 0', '-6 days')",
+}
+
+// postgres equivalent of sqliteBucketExprs, truncated in UTC
+var postgresBucketExprs = map[string]string{
+	IntervalHour: "to_char(date_trunc('hour', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD HH24:MI:SS')",
+	IntervalDay:  "to_char(date_trunc('day', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD HH24:MI:SS')",
+	IntervalWeek: "to_char(date_trunc('week', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD HH24:MI:SS')",
+}
+
+const bucketTimeLayout = "2006-01-02 15:04:05"
+
+// NewClickEventRepository factory function. Support sqlite and postgres databases
+func NewClickEventRepository(db *gorm.DB) ClickEventRepository {
+	bucketExprs := sqliteBucketExprs
+	if db.Dialector.Name() == "postgres" {
+		bucketExprs = postgresBucketExprs
+	}
+	return &gormClickEventRepository{db: db, bucketExprs: bucketExprs}
+}
+
+type gormClickEventRepository struct {
+	db          *gorm.DB
+	bucketExprs map[string]string
+}
+
+func (r *gormClickEventRepository) CreateClickEvent(ctx context.Context, event *ClickEvent) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+// Number of click events inserted per statement
+const clickEventsBatchSize = 100
+
+func (r *gormClickEventRepository) CreateClickEvents(ctx context.Context, events []*ClickEvent) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		seen := map[string]bool{}
+		var codes []string
+		for _, event := range events {
+			if !seen[event.Code] {
+				seen[event.Code] = true
+				codes = append(codes, event.Code)
+			}
+		}
+
+		// short urls deleted since the click was recorded don't get events back
+		var existing []string
+		if err := tx.Model(&ShortURL{}).Where("code IN ?", codes).Pluck("code", &existing).Error; err != nil {
+			return err
+		}
+		exists := map[string]bool{}
+		for _, code := range existing {
+			exists[code] = true
+		}
+		var kept []*ClickEvent
+		for _, event := range events {
+			if exists[event.Code] {
+				kept = append(kept, event)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(kept, clickEventsBatchSize).Error
+	})
+}
+
+// ListClickEvents return most recent click events first
+func (r *gormClickEventRepository) ListClickEvents(ctx context.Context, code string, offset, size int64, filters ...*ClickFilterParams) ([]*ClickEvent, int64, error) {
+	var filter *ClickFilterParams
+	if len(filters) > 0 {
+		filter = filters[0]
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	scope := r.scope(ctx, code, filter)
+
+	var count int64
+	var events []*ClickEvent
+
+	if err := scope.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	scope = scope.Order("created_at DESC, id DESC").Offset(int(offset)).Limit(int(size))
+	if err := scope.Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return events, count, nil
+}
+
+// ClickStats aggregate click events in database so it doesn't need to load every event
+func (r *gormClickEventRepository) ClickStats(ctx context.Context, code string, params StatsParams) (*ClickStats, error) {
+	bucketExpr, ok := r.bucketExprs[params.Interval]
+	if !ok {
+		return nil, fmt.Errorf("unsupported interval: %s", params.Interval)
+	}
+	uniqueExpr := "COUNT(DISTINCT NULLIF(ip_hash, ''))"
+
+	var total struct {
+		Clicks         int64
+		UniqueVisitors int64
+	}
+	err := r.scope(ctx, code, params.Filter).
+		Select("COUNT(*) AS clicks, " + uniqueExpr + " AS unique_visitors").
+		Scan(&total).Error
+	if err != nil {
+		return nil, err
+	}
+	stats := ClickStats{
+		TotalClicks:    total.Clicks,
+		UniqueVisitors: total.UniqueVisitors,
+	}
+
+	var buckets []struct {
+		Bucket         string
+		Clicks         int64
+		UniqueVisitors int64
+	}
+	err = r.scope(ctx, code, params.Filter).
+		Select(bucketExpr + " AS bucket, COUNT(*) AS clicks, " + uniqueExpr + " AS unique_visitors").
+		Group("bucket").Order("bucket").
+		Scan(&buckets).Error
+	if err != nil {
+		return nil, err
+	}
+	stats.Buckets = []*StatsBucket{}
+	for _, b := range buckets {
+		t, err := time.Parse(bucketTimeLayout, b.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		stats.Buckets = append(stats.Buckets, &StatsBucket{
+			Time:           t,
+			Clicks:         b.Clicks,
+			UniqueVisitors: b.UniqueVisitors,
+		})
+	}
+
+	if stats.TopReferrers, err = r.topCounts(ctx, code, "referrer", params); err != nil {
+		return nil, err
+	}
+	if stats.TopUserAgents, err = r.topCounts(ctx, code, "ua_family", params); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (r *gormClickEventRepository) topCounts(ctx context.Context, code, column string, params StatsParams) ([]*StatsCount, error) {
+	counts := []*StatsCount{}
+	err := r.scope(ctx, code, params.Filter).
+		Select(column + " AS value, COUNT(*) AS count").
+		Group(column).Order("count DESC, value").Limit(params.TopSize).
+		Scan(&counts).Error
+	return counts, err
+}
+
+func (r *gormClickEventRepository) scope(ctx context.Context, code string, filter *ClickFilterParams) *gorm.DB {
+	scope := r.db.WithContext(ctx).Model(&ClickEvent{}).Where("code = ?", code)
+	if filter != nil {
+		if filter.From != nil {
+			scope = scope.Where("created_at >= ?", filter.From.UTC())
+		}
+		if filter.To != nil {
+			scope = scope.Where("created_at < ?", filter.To.UTC())
+		}
+	}
+	return scope
+}
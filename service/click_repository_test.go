@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+type ClickEventRepositorySuite struct {
+	suite.Suite
+	db   *gorm.DB
+	repo ClickEventRepository
+}
+
+func (suite *ClickEventRepositorySuite) SetupSuite() {
+	suite.repo = NewClickEventRepository(suite.db)
+}
+
+func (suite *ClickEventRepositorySuite) SetupTest() {
+	suite.db.AutoMigrate(&ClickEvent{}, &ShortURL{})
+}
+
+func (suite *ClickEventRepositorySuite) TearDownTest() {
+	suite.db.Exec("DROP TABLE click_events")
+	suite.db.Exec("DROP TABLE short_urls")
+}
+
+func (suite *ClickEventRepositorySuite) TestDeleteShortURL() {
+	ctx := context.Background()
+	repo := NewURLShortenerRepository(suite.db)
+	now := time.Now().UTC()
+	deletedAt := now.AddDate(0, 0, -10)
+	for _, s := range []*ShortURL{
+		{FullURL: "http://example.com", Domain: "example.com", Code: "123"},
+		{FullURL: "http://example1.com", Domain: "example1.com", Code: "456", DeletedAt: &deletedAt},
+		{FullURL: "http://example2.com", Domain: "example2.com", Code: "789"},
+	} {
+		suite.Nil(repo.CreateShortURL(ctx, s))
+		suite.Nil(suite.repo.CreateClickEvent(ctx, &ClickEvent{Code: s.Code, CreatedAt: now}))
+	}
+
+	suite.Nil(repo.DeleteShortURL(ctx, "123"))
+	codes, err := repo.PurgeShortURLs(ctx, now.AddDate(0, 0, -7))
+	suite.Nil(err)
+	suite.Equal([]string{"456"}, codes)
+
+	// click events of removed short urls are gone
+	for code, want := range map[string]int64{"123": 0, "456": 0, "789": 1} {
+		_, count, err := suite.repo.ListClickEvents(ctx, code, 0, 10)
+		suite.Nil(err)
+		suite.Equal(want, count, code)
+	}
+}
+
+func (suite *ClickEventRepositorySuite) TestCreateClickEvents() {
+	ctx := context.Background()
+	repo := NewURLShortenerRepository(suite.db)
+	suite.Nil(repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"}))
+
+	now := time.Now().UTC()
+	events := []*ClickEvent{
+		{Code: "123", Referrer: "a", CreatedAt: now.Add(-time.Minute)},
+		{Code: "456", Referrer: "b", CreatedAt: now},
+		{Code: "123", Referrer: "c", CreatedAt: now},
+	}
+	suite.Nil(suite.repo.CreateClickEvents(ctx, events))
+
+	// event of a missing short url is dropped
+	list, count, err := suite.repo.ListClickEvents(ctx, "123", 0, 10)
+	suite.Nil(err)
+	suite.EqualValues(2, count)
+	suite.Equal("c", list[0].Referrer)
+	suite.True(now.Add(-time.Minute).Equal(list[1].CreatedAt))
+	_, count, _ = suite.repo.ListClickEvents(ctx, "456", 0, 10)
+	suite.EqualValues(0, count)
+}
+
+func (suite *ClickEventRepositorySuite) TestListClickEvents() {
+	now := time.Now().UTC()
+	events := []*ClickEvent{
+		{Code: "123", Referrer: "a", CreatedAt: now.Add(-3 * time.Hour)},
+		{Code: "123", Referrer: "b", CreatedAt: now.Add(-2 * time.Hour)},
+		{Code: "123", Referrer: "c", CreatedAt: now.Add(-1 * time.Hour)},
+		{Code: "456", Referrer: "d", CreatedAt: now.Add(-1 * time.Hour)},
+	}
+	for _, e := range events {
+		suite.Nil(suite.repo.CreateClickEvent(context.Background(), e))
+	}
+
+	from := now.Add(-150 * time.Minute)
+	to := now.Add(-90 * time.Minute)
+
+	type test struct {
+		code      string
+		offset    int64
+		size      int64
+		filter    *ClickFilterParams
+		referrers []string
+		count     int64
+	}
+
+	tests := []test{
+		{code: "123", size: 10, referrers: []string{"c", "b", "a"}, count: 3},
+		{code: "123", offset: 1, size: 1, referrers: []string{"b"}, count: 3},
+		{code: "123", size: 10, filter: &ClickFilterParams{From: &from}, referrers: []string{"c", "b"}, count: 2},
+		{code: "123", size: 10, filter: &ClickFilterParams{From: &from, To: &to}, referrers: []string{"b"}, count: 1},
+		{code: "789", size: 10, referrers: nil, count: 0},
+	}
+	for _, tc := range tests {
+		events, count, err := suite.repo.ListClickEvents(context.Background(), tc.code, tc.offset, tc.size, tc.filter)
+		suite.Nil(err)
+
+		var referrers []string
+		for _, e := range events {
+			referrers = append(referrers, e.Referrer)
+		}
+		suite.Equal(tc.count, count)
+		suite.Equal(tc.referrers, referrers)
+	}
+}
+
+func (suite *ClickEventRepositorySuite) TestClickStats() {
+	// 2021-12-05 is a sunday
+	day := time.Date(2021, 12, 5, 0, 0, 0, 0, time.UTC)
+	events := []*ClickEvent{
+		{Code: "123", Referrer: "a", UAFamily: "Chrome", IPHash: "1", CreatedAt: day.Add(1*time.Hour + 10*time.Minute)},
+		{Code: "123", Referrer: "a", UAFamily: "Chrome", IPHash: "1", CreatedAt: day.Add(1*time.Hour + 20*time.Minute)},
+		{Code: "123", Referrer: "b", UAFamily: "Firefox", IPHash: "2", CreatedAt: day.Add(3 * time.Hour)},
+		{Code: "123", Referrer: "a", UAFamily: "Chrome", IPHash: "", CreatedAt: day.Add(26 * time.Hour)},
+		{Code: "456", Referrer: "c", UAFamily: "Safari", IPHash: "3", CreatedAt: day},
+	}
+	for _, e := range events {
+		suite.Nil(suite.repo.CreateClickEvent(context.Background(), e))
+	}
+
+	stats, err := suite.repo.ClickStats(context.Background(), "123", StatsParams{Interval: IntervalHour, TopSize: 10})
+	suite.Nil(err)
+	suite.EqualValues(4, stats.TotalClicks)
+	suite.EqualValues(2, stats.UniqueVisitors)
+	suite.Equal([]*StatsBucket{
+		{Time: day.Add(1 * time.Hour), Clicks: 2, UniqueVisitors: 1},
+		{Time: day.Add(3 * time.Hour), Clicks: 1, UniqueVisitors: 1},
+		{Time: day.Add(26 * time.Hour), Clicks: 1, UniqueVisitors: 0},
+	}, stats.Buckets)
+	suite.Equal([]*StatsCount{{Value: "a", Count: 3}, {Value: "b", Count: 1}}, stats.TopReferrers)
+	suite.Equal([]*StatsCount{{Value: "Chrome", Count: 3}, {Value: "Firefox", Count: 1}}, stats.TopUserAgents)
+
+	stats, err = suite.repo.ClickStats(context.Background(), "123", StatsParams{Interval: IntervalDay, TopSize: 1})
+	suite.Nil(err)
+	suite.Equal([]*StatsBucket{
+		{Time: day, Clicks: 3, UniqueVisitors: 2},
+		{Time: day.AddDate(0, 0, 1), Clicks: 1, UniqueVisitors: 0},
+	}, stats.Buckets)
+	suite.Equal([]*StatsCount{{Value: "a", Count: 3}}, stats.TopReferrers)
+
+	stats, err = suite.repo.ClickStats(context.Background(), "123", StatsParams{Interval: IntervalWeek, TopSize: 10})
+	suite.Nil(err)
+	suite.Equal([]*StatsBucket{
+		{Time: day.AddDate(0, 0, -6), Clicks: 3, UniqueVisitors: 2},
+		{Time: day.AddDate(0, 0, 1), Clicks: 1, UniqueVisitors: 0},
+	}, stats.Buckets)
+
+	from := day.Add(2 * time.Hour)
+	to := day.Add(24 * time.Hour)
+	stats, err = suite.repo.ClickStats(context.Background(), "123", StatsParams{
+		Interval: IntervalDay,
+		Filter:   &ClickFilterParams{From: &from, To: &to},
+		TopSize:  10,
+	})
+	suite.Nil(err)
+	suite.EqualValues(1, stats.TotalClicks)
+	suite.Equal([]*StatsBucket{{Time: day, Clicks: 1, UniqueVisitors: 1}}, stats.Buckets)
+
+	stats, err = suite.repo.ClickStats(context.Background(), "789", StatsParams{Interval: IntervalDay, TopSize: 10})
+	suite.Nil(err)
+	suite.EqualValues(0, stats.TotalClicks)
+	suite.Empty(stats.Buckets)
+	suite.Empty(stats.TopReferrers)
+}
+
+func TestClickEventRepository(t *testing.T) {
+	for _, database := range testDatabases() {
+		t.Run(database.name, func(t *testing.T) {
+			suite.Run(t, &ClickEventRepositorySuite{db: openTestDB(t, database.dialector)})
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"sync/atomic"
+)
+
+// Alphabets commonly used to generate short codes
+const (
+	Base62Alphabet    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	Base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// Errors return from code generator factories
+var (
+	ErrInvalidAlphabet   = errors.New("alphabet must contain at least 2 unique characters")
+	ErrInvalidCodeLength = errors.New("code length must be greater than 0")
+)
+
+// CodeGenerator generate short code for a new short url
+type CodeGenerator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// Counter is a source of unique sequential numbers
+type Counter interface {
+	Next(ctx context.Context) (uint64, error)
+}
+
+// NewMemoryCounter factory function. The first number returned is start + 1
+func NewMemoryCounter(start uint64) Counter {
+	return &memoryCounter{value: start}
+}
+
+type memoryCounter struct {
+	value uint64
+}
+
+func (c *memoryCounter) Next(ctx context.Context) (uint64, error) {
+	return atomic.AddUint64(&c.value, 1), nil
+}
+
+// NewRandomCodeGenerator generate crypto-random code of given length from alphabet
+func NewRandomCodeGenerator(length int, alphabet string) (CodeGenerator, error) {
+	if length <= 0 {
+		return nil, ErrInvalidCodeLength
+	}
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	return &randomCodeGenerator{length: length, alphabet: alphabet}, nil
+}
+
+type randomCodeGenerator struct {
+	length   int
+	alphabet string
+}
+
+func (g *randomCodeGenerator) Generate(ctx context.Context) (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	buf := make([]byte, g.length)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = g.alphabet[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+// NewSequentialCodeGenerator encode numbers from counter as base62 code
+func NewSequentialCodeGenerator(counter Counter) CodeGenerator {
+	return &sequentialCodeGenerator{counter: counter}
+}
+
+type sequentialCodeGenerator struct {
+	counter Counter
+}
+
+func (g *sequentialCodeGenerator) Generate(ctx context.Context) (string, error) {
+	n, err := g.counter.Next(ctx)
+	if err != nil {
+		return "", err
+	}
+	return encodeNumber(n, Base62Alphabet, 0), nil
+}
+
+// NewHashidsCodeGenerator encode numbers from counter as hashids-style
+// obfuscated code. Codes are unique per counter value but don't reveal
+// the sequence without knowing the salt.
+func NewHashidsCodeGenerator(counter Counter, salt string, minLength int) CodeGenerator {
+	sum := sha256.Sum256([]byte(salt))
+	return &hashidsCodeGenerator{
+		counter:   counter,
+		alphabet:  shuffleAlphabet(Base62Alphabet, salt),
+		minLength: minLength,
+		// odd multiplier is invertible modulo 2^64 so scrambling is a bijection
+		multiplier: binary.BigEndian.Uint64(sum[:8]) | 1,
+		xorKey:     binary.BigEndian.Uint64(sum[8:16]),
+	}
+}
+
+type hashidsCodeGenerator struct {
+	counter    Counter
+	alphabet   string
+	minLength  int
+	multiplier uint64
+	xorKey     uint64
+}
+
+func (g *hashidsCodeGenerator) Generate(ctx context.Context) (string, error) {
+	n, err := g.counter.Next(ctx)
+	if err != nil {
+		return "", err
+	}
+	return encodeNumber((n*g.multiplier)^g.xorKey, g.alphabet, g.minLength), nil
+}
+
+func validateAlphabet(alphabet string) error {
+	seen := map[rune]bool{}
+	for _, c := range alphabet {
+		if c > 127 || seen[c] {
+			return ErrInvalidAlphabet
+		}
+		seen[c] = true
+	}
+	if len(seen) < 2 {
+		return ErrInvalidAlphabet
+	}
+	return nil
+}
+
+// encodeNumber convert n into given alphabet base, left padded to minLength
+func encodeNumber(n uint64, alphabet string, minLength int) string {
+	base := uint64(len(alphabet))
+	buf := []byte{}
+	for {
+		buf = append(buf, alphabet[n%base])
+		n /= base
+		if n == 0 {
+			break
+		}
+	}
+	for len(buf) < minLength {
+		buf = append(buf, alphabet[0])
+	}
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	return string(buf)
+}
+
+// shuffleAlphabet is the consistent shuffle used by hashids
+func shuffleAlphabet(alphabet, salt string) string {
+	buf := []byte(alphabet)
+	if salt == "" {
+		return alphabet
+	}
+	for i, v, p := len(buf)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		c := int(salt[v])
+		p += c
+		j := (c + v + p) % i
+		buf[i], buf[j] = buf[j], buf[i]
+		v++
+	}
+	return string(buf)
+}
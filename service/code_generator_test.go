@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRandomCodeGenerator(t *testing.T) {
+	type test struct {
+		length   int
+		alphabet string
+		want     error
+	}
+
+	tests := []test{
+		{length: 8, alphabet: Base62Alphabet, want: nil},
+		{length: 16, alphabet: "ab", want: nil},
+		{length: 0, alphabet: Base62Alphabet, want: ErrInvalidCodeLength},
+		{length: 8, alphabet: "a", want: ErrInvalidAlphabet},
+		{length: 8, alphabet: "abca", want: ErrInvalidAlphabet},
+	}
+
+	for _, tc := range tests {
+		gen, err := NewRandomCodeGenerator(tc.length, tc.alphabet)
+		if err != tc.want {
+			t.Errorf("expected: %v, got: %v", tc.want, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		code, err := gen.Generate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != tc.length {
+			t.Errorf("expected code length: %d, got: %d", tc.length, len(code))
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(tc.alphabet, c) {
+				t.Errorf("unexpected character %q in code %q", c, code)
+			}
+		}
+	}
+}
+
+func TestSequentialCodeGenerator(t *testing.T) {
+	gen := NewSequentialCodeGenerator(NewMemoryCounter(59))
+
+	tests := []string{"y", "z", "10", "11"}
+	for _, want := range tests {
+		code, err := gen.Generate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("expected: %v, got: %v", want, code)
+		}
+	}
+}
+
+func TestHashidsCodeGenerator(t *testing.T) {
+	gen1 := NewHashidsCodeGenerator(NewMemoryCounter(0), "salt", 6)
+	gen2 := NewHashidsCodeGenerator(NewMemoryCounter(0), "salt", 6)
+	gen3 := NewHashidsCodeGenerator(NewMemoryCounter(0), "pepper", 6)
+
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		code1, _ := gen1.Generate(context.Background())
+		code2, _ := gen2.Generate(context.Background())
+		code3, _ := gen3.Generate(context.Background())
+
+		if len(code1) < 6 {
+			t.Errorf("expected code %q to have at least 6 characters", code1)
+		}
+		if code1 != code2 {
+			t.Errorf("expected same salt to produce same code, got: %v and %v", code1, code2)
+		}
+		if code1 == code3 {
+			t.Errorf("expected different salt to produce different code, got: %v", code1)
+		}
+		if seen[code1] {
+			t.Errorf("duplicated code: %v", code1)
+		}
+		seen[code1] = true
+	}
+}
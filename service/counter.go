@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+
+	bolt "go.etcd.io/bbolt"
+	"gorm.io/gorm"
+)
+
+// Name of the counter issuing short code numbers
+const ShortCodeCounter = "short_code"
+
+// bolt bucket of counters, keyed by name
+var boltCountersBucket = []byte("counters")
+
+// NewGormCounter factory function. Numbers are kept in the counters table so
+// every instance sharing the database draw from the same sequence
+func NewGormCounter(db *gorm.DB, name string) Counter {
+	return &gormCounter{db: db, name: name}
+}
+
+type gormCounter struct {
+	db   *gorm.DB
+	name string
+}
+
+// Next increment the counter in a single statement, concurrent callers never
+// get the same number. The row is created on first use
+func (c *gormCounter) Next(ctx context.Context) (uint64, error) {
+	var value uint64
+	err := c.db.WithContext(ctx).Raw(
+		"INSERT INTO counters (name, value) VALUES (?, 1) "+
+			"ON CONFLICT (name) DO UPDATE SET value = counters.value + 1 RETURNING value",
+		c.name,
+	).Scan(&value).Error
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// NewBoltCounter factory function. Numbers are kept in the bolt file so a
+// restart doesn't replay them
+func NewBoltCounter(db *bolt.DB, name string) Counter {
+	return &boltCounter{db: db, name: []byte(name)}
+}
+
+type boltCounter struct {
+	db   *bolt.DB
+	name []byte
+}
+
+func (c *boltCounter) Next(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var value uint64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(boltCountersBucket)
+		if err != nil {
+			return err
+		}
+		value = boltUint64Value(bucket.Get(c.name)) + 1
+		return bucket.Put(c.name, boltUint64(value))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestGormCounter(t *testing.T) {
+	ctx := context.Background()
+	for _, database := range testDatabases() {
+		t.Run(database.name, func(t *testing.T) {
+			db := openTestDB(t, database.dialector)
+			if err := db.Exec("CREATE TABLE counters (name text PRIMARY KEY, value bigint NOT NULL DEFAULT 0)").Error; err != nil {
+				t.Fatal(err)
+			}
+			defer db.Exec("DROP TABLE counters")
+
+			// two instances sharing the database never issue the same number
+			counters := []Counter{NewGormCounter(db, ShortCodeCounter), NewGormCounter(db, ShortCodeCounter)}
+			for i := uint64(1); i <= 4; i++ {
+				n, err := counters[i%2].Next(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != i {
+					t.Errorf("expected: %v, got: %v", i, n)
+				}
+			}
+
+			// counters are independent
+			if n, _ := NewGormCounter(db, "other").Next(ctx); n != 1 {
+				t.Errorf("expected: %v, got: %v", 1, n)
+			}
+		})
+	}
+}
+
+func TestBoltCounter(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bolt.db")
+
+	db := openTestBolt(t, path)
+	counter := NewBoltCounter(db, ShortCodeCounter)
+	for i := uint64(1); i <= 2; i++ {
+		if n, _ := counter.Next(ctx); n != i {
+			t.Errorf("expected: %v, got: %v", i, n)
+		}
+	}
+	db.Close()
+
+	// sequence carry on after reopening
+	db = openTestBolt(t, path)
+	defer db.Close()
+	if n, _ := NewBoltCounter(db, ShortCodeCounter).Next(ctx); n != 3 {
+		t.Errorf("expected: %v, got: %v", 3, n)
+	}
+}
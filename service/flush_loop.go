@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flushLoop call flush periodically and when triggered, it is the
+// write-behind machinery shared by buffered repositories
+type flushLoop struct {
+	interval  time.Duration
+	flush     func(ctx context.Context) error
+	flushCh   chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func newFlushLoop(interval time.Duration, flush func(ctx context.Context) error) *flushLoop {
+	l := &flushLoop{
+		interval: interval,
+		flush:    flush,
+		flushCh:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// trigger an early flush, it is a no-op when one is already requested
+func (l *flushLoop) trigger() {
+	select {
+	case l.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// close stop periodic flushing then flush what is left
+func (l *flushLoop) close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		<-l.stopped
+	})
+	return l.flush(context.Background())
+}
+
+func (l *flushLoop) run() {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.flushCh:
+		case <-l.done:
+			return
+		}
+		l.flushWithTimeout()
+	}
+}
+
+// flushWithTimeout bound a periodic flush so a stuck database doesn't
+// block following flushes forever. Errors are retried on next flush.
+func (l *flushLoop) flushWithTimeout() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.interval)
+	defer cancel()
+	l.flush(ctx)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// InvalidationBus broadcast changed cache keys so every instance can evict
+// its own copy
+type InvalidationBus interface {
+	// Publish announce key changed to every subscriber, local ones included
+	Publish(ctx context.Context, key string) error
+	// Subscribe register fn to be called with every published key
+	Subscribe(fn func(key string))
+	// OnReconnect register fn to be called whenever the subscription is
+	// established again, keys published while it was down were missed
+	OnReconnect(fn func())
+	// Close stop delivering keys and release connections
+	Close() error
+}
+
+// NewLocalInvalidationBus factory function. Keys are only delivered to
+// subscribers of the same process
+func NewLocalInvalidationBus() InvalidationBus {
+	return &localInvalidationBus{}
+}
+
+// invalidationSubscribers is a list of subscribers safe for concurrent use
+type invalidationSubscribers struct {
+	mux         sync.RWMutex
+	subscribers []func(key string)
+	reconnects  []func()
+}
+
+func (s *invalidationSubscribers) Subscribe(fn func(key string)) {
+	s.mux.Lock()
+	s.subscribers = append(s.subscribers, fn)
+	s.mux.Unlock()
+}
+
+func (s *invalidationSubscribers) OnReconnect(fn func()) {
+	s.mux.Lock()
+	s.reconnects = append(s.reconnects, fn)
+	s.mux.Unlock()
+}
+
+func (s *invalidationSubscribers) reconnected() {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for _, fn := range s.reconnects {
+		fn()
+	}
+}
+
+func (s *invalidationSubscribers) deliver(key string) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for _, fn := range s.subscribers {
+		fn(key)
+	}
+}
+
+// localInvalidationBus never miss keys so OnReconnect callbacks aren't called
+type localInvalidationBus struct {
+	invalidationSubscribers
+}
+
+func (b *localInvalidationBus) Publish(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.deliver(key)
+	return nil
+}
+
+func (b *localInvalidationBus) Close() error {
+	return nil
+}
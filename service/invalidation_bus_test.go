@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalInvalidationBus(t *testing.T) {
+	bus := NewLocalInvalidationBus()
+	defer bus.Close()
+
+	var got1, got2 []string
+	bus.Subscribe(func(key string) { got1 = append(got1, key) })
+	bus.Subscribe(func(key string) { got2 = append(got2, key) })
+
+	bus.Publish(context.Background(), "123")
+	bus.Publish(context.Background(), "456")
+	for _, got := range [][]string{got1, got2} {
+		if len(got) != 2 || got[0] != "123" || got[1] != "456" {
+			t.Errorf("unexpected keys: %v", got)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bus.Publish(ctx, "789"); err != context.Canceled {
+		t.Errorf("expected: %v, got: %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// helpers shared by repositories which filter and sort in process
+
+// matchFilter report whether shortURL satisfy every field of filter
+func matchFilter(shortURL *ShortURL, filter *FilterParams, now time.Time) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.Code != "" && shortURL.Code != filter.Code {
+		return false
+	}
+	if filter.Keyword != "" && !strings.Contains(strings.ToLower(shortURL.Domain), strings.ToLower(filter.Keyword)) {
+		return false
+	}
+	if filter.URL != "" && !strings.Contains(strings.ToLower(shortURL.FullURL), strings.ToLower(filter.URL)) {
+		return false
+	}
+	if filter.CreatedFrom != nil && shortURL.CreatedAt.Before(*filter.CreatedFrom) {
+		return false
+	}
+	if filter.CreatedTo != nil && !shortURL.CreatedAt.Before(*filter.CreatedTo) {
+		return false
+	}
+
+	expiresAt := shortURL.ExpiresAt
+	switch filter.Expiry {
+	case ExpiryActive:
+		if expiresAt == nil || expiresAt.Before(now) {
+			return false
+		}
+	case ExpiryExpired:
+		if expiresAt == nil || !expiresAt.Before(now) {
+			return false
+		}
+	case ExpiryNever:
+		if expiresAt != nil {
+			return false
+		}
+	}
+
+	if filter.Deleted != nil && *filter.Deleted != (shortURL.DeletedAt != nil) {
+		return false
+	}
+	if filter.Blocked != nil && *filter.Blocked != (shortURL.BlockedAt != nil) {
+		return false
+	}
+	if filter.MinHitCount != nil && shortURL.HitCount < *filter.MinHitCount {
+		return false
+	}
+	if filter.MaxHitCount != nil && shortURL.HitCount > *filter.MaxHitCount {
+		return false
+	}
+	return true
+}
+
+// isEmptyFilter report whether filter keep every short url
+func isEmptyFilter(filter *FilterParams) bool {
+	return filter == nil || *filter == FilterParams{}
+}
+
+// sortShortURLs sort the same way as orderClause of sql repositories
+func sortShortURLs(shortURLs []*ShortURL, params SortParams) {
+	sort.SliceStable(shortURLs, func(i, j int) bool {
+		a, b := shortURLs[i], shortURLs[j]
+		if params.Field == SortExpiresAt && (a.ExpiresAt == nil) != (b.ExpiresAt == nil) {
+			// without expiry always come last
+			return b.ExpiresAt == nil
+		}
+		if params.Desc {
+			a, b = b, a
+		}
+
+		switch params.Field {
+		case SortHitCount:
+			if a.HitCount != b.HitCount {
+				return a.HitCount < b.HitCount
+			}
+		case SortExpiresAt:
+			if a.ExpiresAt != nil && b.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt) {
+				return a.ExpiresAt.Before(*b.ExpiresAt)
+			}
+		default:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		}
+		return a.Id < b.Id
+	})
+}
+
+// paginate pick the page of sorted short urls described by params
+func paginate(shortURLs []*ShortURL, params *ListParams) []*ShortURL {
+	start := int64(0)
+	if after := params.After; after != nil {
+		start = int64(sort.Search(len(shortURLs), func(i int) bool {
+			if params.Sort.Desc {
+				return after.After(shortURLs[i])
+			}
+			return after.Before(shortURLs[i])
+		}))
+	} else if params.Offset > 0 {
+		start = params.Offset
+	}
+
+	count := int64(len(shortURLs))
+	if start > count {
+		start = count
+	}
+	end := count
+	if params.Size > 0 && start+params.Size < count {
+		end = start + params.Size
+	}
+	return shortURLs[start:end]
+}
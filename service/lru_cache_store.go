@@ -0,0 +1,251 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Default LRU cache store behavior
+const (
+	DEFAULT_LRU_MAX_ENTRIES      = 10000
+	DEFAULT_LRU_MAX_BYTES        = 64 << 20
+	DEFAULT_LRU_JANITOR_INTERVAL = time.Minute
+)
+
+// LRUOption to modify LRU cache store bounds
+type LRUOption struct {
+	// MaxEntries number of keys kept before the least recently used is evicted
+	MaxEntries int
+	// MaxBytes total size of keys and encoded values kept before evicting
+	MaxBytes int64
+	// JanitorInterval between background sweeps of expired keys
+	JanitorInterval time.Duration
+	// Codec encode values, default to NoCodec which keep values as is.
+	// Their size is then only known when they implement CacheSizer
+	Codec Codec
+}
+
+// CacheStats snapshot of cache store counters
+type CacheStats struct {
+	Entries     int64 `json:"entries"`
+	Bytes       int64 `json:"bytes"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+}
+
+// LRUCacheStore is a CacheStore bounded by entry count and size
+type LRUCacheStore interface {
+	CacheStore
+	// Stats return current counters
+	Stats() CacheStats
+	// Clear delete every key
+	Clear(ctx context.Context) error
+	// Close stop the background janitor
+	Close() error
+}
+
+// NewLRUCacheStore factory function. The least recently used key is evicted
+// once either bound is reached and expired keys are swept in background.
+func NewLRUCacheStore(opts ...LRUOption) LRUCacheStore {
+	c := &lruCacheStore{
+		maxEntries: DEFAULT_LRU_MAX_ENTRIES,
+		maxBytes:   DEFAULT_LRU_MAX_BYTES,
+		interval:   DEFAULT_LRU_JANITOR_INTERVAL,
+		codec:      NoCodec,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if len(opts) > 0 {
+		if opts[0].MaxEntries > 0 {
+			c.maxEntries = opts[0].MaxEntries
+		}
+		if opts[0].MaxBytes > 0 {
+			c.maxBytes = opts[0].MaxBytes
+		}
+		if opts[0].JanitorInterval > 0 {
+			c.interval = opts[0].JanitorInterval
+		}
+		if opts[0].Codec != nil {
+			c.codec = opts[0].Codec
+		}
+	}
+
+	go c.run()
+	return c
+}
+
+type lruCacheEntry struct {
+	key       string
+	value     interface{}
+	expiredAt *time.Time
+}
+
+func (e *lruCacheEntry) size() int64 {
+	return int64(len(e.key)) + cacheValueSize(e.value)
+}
+
+func (e *lruCacheEntry) expired(now time.Time) bool {
+	return e.expiredAt != nil && e.expiredAt.Before(now)
+}
+
+type lruCacheStore struct {
+	maxEntries int
+	maxBytes   int64
+	interval   time.Duration
+	codec      Codec
+
+	mux   sync.Mutex
+	ll    *list.List // front is the most recently used
+	items map[string]*list.Element
+	stats CacheStats
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *lruCacheStore) Save(ctx context.Context, key string, val interface{}, opts ...CacheOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	encoded, err := encodeCacheValue(c.codec, val)
+	if err != nil {
+		return err
+	}
+	entry := &lruCacheEntry{key: key, value: encoded}
+	if len(opts) > 0 {
+		expiredAt := time.Now().Add(opts[0].ExpiresIn).UTC()
+		entry.expiredAt = &expiredAt
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	// a value larger than the whole cache would only flush everything else
+	if entry.size() > c.maxBytes {
+		return nil
+	}
+
+	c.items[key] = c.ll.PushFront(entry)
+	c.stats.Entries++
+	c.stats.Bytes += entry.size()
+	for c.stats.Entries > int64(c.maxEntries) || c.stats.Bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+		c.stats.Evictions++
+	}
+	return nil
+}
+
+func (c *lruCacheStore) Get(ctx context.Context, key string, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		c.mux.Unlock()
+		return ErrCacheKeyNotFound
+	}
+	entry := el.Value.(*lruCacheEntry)
+	if entry.expired(time.Now().UTC()) {
+		c.remove(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		c.mux.Unlock()
+		return ErrCacheKeyNotFound
+	}
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	c.mux.Unlock()
+
+	// entry value is never mutated so decode outside the lock
+	return decodeCacheValue(c.codec, entry.value, v)
+}
+
+func (c *lruCacheStore) Delete(ctx context.Context, key string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	return nil
+}
+
+// Clear delete every key, counters other than size are kept
+func (c *lruCacheStore) Clear(ctx context.Context) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.stats.Entries = 0
+	c.stats.Bytes = 0
+	return nil
+}
+
+func (c *lruCacheStore) Stats() CacheStats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.stats
+}
+
+func (c *lruCacheStore) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		<-c.stopped
+	})
+	return nil
+}
+
+// remove must be called with mux held
+func (c *lruCacheStore) remove(el *list.Element) {
+	entry := c.ll.Remove(el).(*lruCacheEntry)
+	delete(c.items, entry.key)
+	c.stats.Entries--
+	c.stats.Bytes -= entry.size()
+}
+
+// removeExpired sweep every expired key
+func (c *lruCacheStore) removeExpired() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now().UTC()
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*lruCacheEntry).expired(now) {
+			c.remove(el)
+			c.stats.Expirations++
+		}
+		el = prev
+	}
+}
+
+func (c *lruCacheStore) run() {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.removeExpired()
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLRUCacheStore(t *testing.T) {
+	suite.Run(t, &CacheStoreSuite{newStore: func() CacheStore {
+		store := NewLRUCacheStore()
+		t.Cleanup(func() { store.Close() })
+		return store
+	}})
+}
+
+func TestLRUCacheStoreEvictByEntries(t *testing.T) {
+	ctx := context.Background()
+	store := NewLRUCacheStore(LRUOption{MaxEntries: 2})
+	defer store.Close()
+
+	store.Save(ctx, "key1", "value1")
+	store.Save(ctx, "key2", "value2")
+	// key1 become the most recently used so key2 is evicted
+	var value string
+	store.Get(ctx, "key1", &value)
+	store.Save(ctx, "key3", "value3")
+
+	if err := store.Get(ctx, "key2", &value); err != ErrCacheKeyNotFound {
+		t.Errorf("expected: %v, got: %v", ErrCacheKeyNotFound, err)
+	}
+	for _, key := range []string{"key1", "key3"} {
+		if err := store.Get(ctx, key, &value); err != nil {
+			t.Errorf("expected %s to be kept, got: %v", key, err)
+		}
+	}
+
+	stats := store.Stats()
+	if stats.Entries != 2 || stats.Evictions != 1 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestLRUCacheStoreEvictByBytes(t *testing.T) {
+	ctx := context.Background()
+	// "key1" plus json encoded "value1" is 12 bytes
+	store := NewLRUCacheStore(LRUOption{MaxBytes: 30, Codec: JSONCodec})
+	defer store.Close()
+
+	store.Save(ctx, "key1", "value1")
+	store.Save(ctx, "key2", "value2")
+	store.Save(ctx, "key3", "value3")
+
+	stats := store.Stats()
+	if stats.Entries != 2 || stats.Bytes != 24 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// overwriting a key replace its size
+	store.Save(ctx, "key3", "v")
+	if stats := store.Stats(); stats.Bytes != 19 {
+		t.Errorf("expected: %v, got: %v", 19, stats.Bytes)
+	}
+
+	// value larger than the cache is not stored
+	store.Save(ctx, "key4", "a value which never fit in the cache")
+	var value string
+	if err := store.Get(ctx, "key4", &value); err != ErrCacheKeyNotFound {
+		t.Errorf("expected: %v, got: %v", ErrCacheKeyNotFound, err)
+	}
+}
+
+type sizedValue struct {
+	size int64
+}
+
+func (v sizedValue) CacheSize() int64 {
+	return v.size
+}
+
+func TestLRUCacheStoreSizeWithoutCodec(t *testing.T) {
+	ctx := context.Background()
+	store := NewLRUCacheStore(LRUOption{MaxBytes: 100})
+	defer store.Close()
+
+	// values kept as is are sized by CacheSizer, strings by length
+	store.Save(ctx, "key1", sizedValue{size: 50})
+	store.Save(ctx, "key2", "value2")
+	if stats := store.Stats(); stats.Bytes != 64 {
+		t.Errorf("expected: %v, got: %v", 64, stats.Bytes)
+	}
+
+	var value sizedValue
+	if err := store.Get(ctx, "key1", &value); err != nil || value.size != 50 {
+		t.Errorf("expected: %v, got: %v %v", 50, value.size, err)
+	}
+}
+
+func TestLRUCacheStoreJanitor(t *testing.T) {
+	ctx := context.Background()
+	store := NewLRUCacheStore(LRUOption{JanitorInterval: 10 * time.Millisecond})
+	defer store.Close()
+
+	store.Save(ctx, "key1", "value1", CacheOption{ExpiresIn: time.Millisecond})
+	store.Save(ctx, "key2", "value2")
+
+	deadline := time.Now().Add(time.Second)
+	for store.Stats().Entries != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := store.Stats()
+	if stats.Entries != 1 || stats.Expirations != 1 {
+		t.Errorf("expected expired key to be swept, got: %+v", stats)
+	}
+}
+
+func TestLRUCacheStoreConcurrency(t *testing.T) {
+	ctx := context.Background()
+	store := NewLRUCacheStore(LRUOption{MaxEntries: 10})
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := string(rune('a' + i%20))
+			var value int
+			store.Save(ctx, key, i)
+			store.Get(ctx, key, &value)
+			store.Delete(ctx, key)
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := store.Stats(); stats.Entries > 10 {
+		t.Errorf("expected at most %v entries, got: %v", 10, stats.Entries)
+	}
+}
+
+func TestLRUCacheStoreClear(t *testing.T) {
+	ctx := context.Background()
+	store := NewLRUCacheStore()
+	defer store.Close()
+
+	store.Save(ctx, "key1", "value1")
+	store.Save(ctx, "key2", "value2")
+	if err := store.Clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var value string
+	if err := store.Get(ctx, "key1", &value); err != ErrCacheKeyNotFound {
+		t.Errorf("expected: %v, got: %v", ErrCacheKeyNotFound, err)
+	}
+	if stats := store.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
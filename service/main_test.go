@@ -0,0 +1,121 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var (
+	testDBName = "../test.sqlite"
+
+	// set when a postgres server is available for repository tests
+	testPostgresDSN string
+)
+
+func TestMain(m *testing.M) {
+	stop := startTestPostgres()
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+type testDatabase struct {
+	name      string
+	dialector gorm.Dialector
+}
+
+// testDatabases return every database repository suites should run against.
+// sqlite always runs, postgres runs when TEST_POSTGRES_DSN is set or when
+// initdb and pg_ctl are found on PATH.
+func testDatabases() []testDatabase {
+	databases := []testDatabase{
+		{name: "sqlite", dialector: sqlite.Open(testDBName)},
+	}
+	if testPostgresDSN != "" {
+		databases = append(databases, testDatabase{
+			name:      "postgres",
+			dialector: postgres.Open(testPostgresDSN),
+		})
+	}
+	return databases
+}
+
+func openTestDB(t *testing.T, dialector gorm.Dialector) *gorm.DB {
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// startTestPostgres start a throwaway postgres cluster from local binaries
+func startTestPostgres() (stop func()) {
+	stop = func() {}
+	if dsn := os.Getenv("TEST_POSTGRES_DSN"); dsn != "" {
+		testPostgresDSN = dsn
+		return
+	}
+
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		return
+	}
+	pgctl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "rburlshortener-pg")
+	if err != nil {
+		return
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust").CombinedOutput(); err != nil {
+		// initdb refuses to run as root for example
+		log.Printf("skip postgres tests, initdb failed: %v: %s", err, out)
+		os.RemoveAll(dir)
+		return
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return
+	}
+	opts := fmt.Sprintf("-p %d -k %s -h 127.0.0.1", port, dir)
+	logFile := filepath.Join(dir, "postgres.log")
+	if out, err := exec.Command(pgctl, "-D", data, "-o", opts, "-l", logFile, "-w", "start").CombinedOutput(); err != nil {
+		log.Printf("skip postgres tests, pg_ctl start failed: %v: %s", err, out)
+		os.RemoveAll(dir)
+		return
+	}
+
+	testPostgresDSN = fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+	return func() {
+		exec.Command(pgctl, "-D", data, "-m", "fast", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SnapshotRepository is an URLShortenerRepository which can be persisted to a file
+type SnapshotRepository interface {
+	URLShortenerRepository
+	// SaveSnapshot write every record to path atomically
+	SaveSnapshot(path string) error
+	// LoadSnapshot replace every record with the content of path
+	LoadSnapshot(path string) error
+}
+
+// NewMemoryRepository factory function. Records live in process memory only,
+// use SaveSnapshot and LoadSnapshot to keep them across restarts.
+func NewMemoryRepository() SnapshotRepository {
+	return &memoryRepository{
+		byId:   make(map[int64]*ShortURL),
+		byCode: make(map[string]*ShortURL),
+	}
+}
+
+// memorySnapshot is the on-disk format. gob keeps fields hidden from json
+type memorySnapshot struct {
+	LastId    int64
+	ShortURLs []*ShortURL
+}
+
+type memoryRepository struct {
+	mux    sync.RWMutex
+	lastId int64
+	byId   map[int64]*ShortURL
+	byCode map[string]*ShortURL
+}
+
+func (r *memoryRepository) CreateShortURL(ctx context.Context, shortURL *ShortURL) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.byCode[shortURL.Code]; ok {
+		return ErrConstraintUnique
+	}
+	r.lastId++
+	shortURL.Id = r.lastId
+	if shortURL.CreatedAt.IsZero() {
+		shortURL.CreatedAt = time.Now().UTC()
+	}
+
+	record := copyShortURL(shortURL)
+	r.byId[record.Id] = record
+	r.byCode[record.Code] = record
+	return nil
+}
+
+func (r *memoryRepository) FindShortURL(ctx context.Context, code string) (*ShortURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	record, ok := r.byCode[code]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyShortURL(record), nil
+}
+
+// UpdateShortURL update the same mutable fields as the sql repositories
+func (r *memoryRepository) UpdateShortURL(ctx context.Context, shortURL *ShortURL) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	record, ok := r.byId[shortURL.Id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	record.FullURL = shortURL.FullURL
+	record.Domain = shortURL.Domain
+	record.ExpiresAt = copyTime(shortURL.ExpiresAt)
+	record.DeletedAt = copyTime(shortURL.DeletedAt)
+	record.BlockedAt = copyTime(shortURL.BlockedAt)
+	record.BlockedBy = shortURL.BlockedBy
+	return nil
+}
+
+func (r *memoryRepository) DeleteShortURL(ctx context.Context, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	record, ok := r.byCode[code]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	delete(r.byId, record.Id)
+	delete(r.byCode, record.Code)
+	return nil
+}
+
+func (r *memoryRepository) PurgeShortURLs(ctx context.Context, deletedBefore time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var codes []string
+	for id, record := range r.byId {
+		if record.DeletedAt != nil && record.DeletedAt.Before(deletedBefore) {
+			delete(r.byId, id)
+			delete(r.byCode, record.Code)
+			codes = append(codes, record.Code)
+		}
+	}
+	return codes, nil
+}
+
+func (r *memoryRepository) IncreaseShortURLHitCount(ctx context.Context, code string, count int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	record, ok := r.byCode[code]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	record.HitCount += int64(count)
+	return nil
+}
+
+func (r *memoryRepository) SetShortURLBlocked(ctx context.Context, code string, blockedAt *time.Time, blockedBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	record, ok := r.byCode[code]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	record.BlockedAt = copyTime(blockedAt)
+	record.BlockedBy = blockedBy
+	return nil
+}
+
+func (r *memoryRepository) DisableShortURL(ctx context.Context, code string, deletedAt time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	record, ok := r.byCode[code]
+	if !ok || record.DeletedAt != nil {
+		return false, nil
+	}
+	record.DeletedAt = copyTime(&deletedAt)
+	return true, nil
+}
+
+func (r *memoryRepository) ListShortURLs(ctx context.Context, params *ListParams) ([]*ShortURL, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	now := time.Now().UTC()
+	var matches []*ShortURL
+	for _, record := range r.byId {
+		if matchFilter(record, params.Filter, now) {
+			matches = append(matches, record)
+		}
+	}
+	sortShortURLs(matches, params.Sort)
+
+	var count int64
+	if params.WithCount {
+		count = int64(len(matches))
+	}
+
+	var shortURLs []*ShortURL
+	for _, record := range paginate(matches, params) {
+		shortURLs = append(shortURLs, copyShortURL(record))
+	}
+	return shortURLs, count, nil
+}
+
+func (r *memoryRepository) SaveSnapshot(path string) error {
+	r.mux.RLock()
+	snapshot := memorySnapshot{LastId: r.lastId}
+	for _, record := range r.byId {
+		snapshot.ShortURLs = append(snapshot.ShortURLs, copyShortURL(record))
+	}
+	r.mux.RUnlock()
+
+	// write to a temporary file first so a crash never leaves a partial snapshot
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(&snapshot); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (r *memoryRepository) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snapshot memorySnapshot
+	if err := gob.NewDecoder(f).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.lastId = snapshot.LastId
+	r.byId = make(map[int64]*ShortURL, len(snapshot.ShortURLs))
+	r.byCode = make(map[string]*ShortURL, len(snapshot.ShortURLs))
+	for _, record := range snapshot.ShortURLs {
+		r.byId[record.Id] = record
+		r.byCode[record.Code] = record
+	}
+	return nil
+}
+
+// copyShortURL so callers never share memory with stored records
+func copyShortURL(shortURL *ShortURL) *ShortURL {
+	c := *shortURL
+	c.ExpiresAt = copyTime(shortURL.ExpiresAt)
+	c.DeletedAt = copyTime(shortURL.DeletedAt)
+	c.BlockedAt = copyTime(shortURL.BlockedAt)
+	return &c
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
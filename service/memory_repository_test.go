@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	suite.Run(t, &URLShortenerRepositorySuite{
+		setup: func() URLShortenerRepository {
+			return NewMemoryRepository()
+		},
+		teardown: func() {},
+	})
+}
+
+func TestMemoryRepositorySnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.gob")
+
+	deletedAt := time.Now().UTC()
+	repo := NewMemoryRepository()
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://example1.com", Domain: "example1.com", Code: "456", DeletedAt: &deletedAt})
+	repo.IncreaseShortURLHitCount(ctx, "123", 2)
+	if err := repo.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewMemoryRepository()
+	if err := loaded.LoadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	s1, err := loaded.FindShortURL(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1.Id != 1 || s1.HitCount != 2 || s1.Domain != "example.com" {
+		t.Errorf("unexpected short url after load: %+v", s1)
+	}
+	s2, err := loaded.FindShortURL(ctx, "456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.DeletedAt == nil || !s2.DeletedAt.Equal(deletedAt) {
+		t.Errorf("expected soft delete state to survive snapshot, got: %v", s2.DeletedAt)
+	}
+
+	// ids keep increasing after load
+	s3 := &ShortURL{FullURL: "http://example2.com", Domain: "example2.com", Code: "789"}
+	loaded.CreateShortURL(ctx, s3)
+	if s3.Id != 3 {
+		t.Errorf("expected: %v, got: %v", 3, s3.Id)
+	}
+}
+
+func TestMemoryRepositoryConcurrency(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepository()
+	repo.CreateShortURL(ctx, &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo.IncreaseShortURLHitCount(ctx, "123", 1)
+			repo.FindShortURL(ctx, "123")
+			repo.ListShortURLs(ctx, &ListParams{Size: 10})
+		}()
+	}
+	wg.Wait()
+
+	s, _ := repo.FindShortURL(ctx, "123")
+	if s.HitCount != 50 {
+		t.Errorf("expected: %v, got: %v", 50, s.HitCount)
+	}
+}
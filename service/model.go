@@ -12,4 +12,51 @@ type ShortURL struct {
 	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 	CreatedAt time.Time  `json:"-"`
 	DeletedAt *time.Time `json:"-" gorm:"index"`
+	// BlockedAt is set by blacklist scan when the full url match BlockedBy rule
+	BlockedAt *time.Time `json:"blockedAt,omitempty"`
+	BlockedBy string     `json:"blockedBy,omitempty"`
+}
+
+// BlacklistRule model mapping to blacklist_rules table
+type BlacklistRule struct {
+	Id        int64     `json:"id"`
+	Type      string    `json:"type" gorm:"not null;default:regex"`
+	Pattern   string    `json:"pattern" gorm:"not null"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ClickEvent model mapping to click_events table
+type ClickEvent struct {
+	Id             int64     `json:"-"`
+	Code           string    `json:"code" gorm:"not null;index:idx_click_events_code_created_at,priority:1"`
+	Referrer       string    `json:"referrer"`
+	UserAgent      string    `json:"userAgent"`
+	UAFamily       string    `json:"userAgentFamily" gorm:"column:ua_family"`
+	IPHash         string    `json:"ipHash"`
+	AcceptLanguage string    `json:"acceptLanguage"`
+	CreatedAt      time.Time `json:"createdAt" gorm:"index:idx_click_events_code_created_at,priority:2"`
+}
+
+// ClickStats aggregated click events of a short url
+type ClickStats struct {
+	TotalClicks    int64          `json:"totalClicks"`
+	UniqueVisitors int64          `json:"uniqueVisitors"`
+	Buckets        []*StatsBucket `json:"buckets"`
+	TopReferrers   []*StatsCount  `json:"topReferrers"`
+	TopUserAgents  []*StatsCount  `json:"topUserAgents"`
+}
+
+// StatsBucket number of clicks in a time interval starting at Time
+type StatsBucket struct {
+	Time           time.Time `json:"time"`
+	Clicks         int64     `json:"clicks"`
+	UniqueVisitors int64     `json:"uniqueVisitors"`
+}
+
+// StatsCount number of clicks grouped by Value
+type StatsCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
 }
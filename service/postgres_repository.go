@@ -0,0 +1,24 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+// postgres error code of unique_violation
+const pgUniqueViolation = "23505"
+
+// NewPostgresRepository factory function
+func NewPostgresRepository(db *gorm.DB) URLShortenerRepository {
+	return &gormRepository{db: db, transformError: transformPostgresError}
+}
+
+func transformPostgresError(err error) error {
+	var e *pgconn.PgError
+	if errors.As(err, &e) && e.Code == pgUniqueViolation {
+		return ErrConstraintUnique
+	}
+	return transformGormError(err)
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+func TestTransformPostgresError(t *testing.T) {
+	otherErr := errors.New("connection refused")
+
+	type test struct {
+		input error
+		want  error
+	}
+
+	tests := []test{
+		{input: &pgconn.PgError{Code: pgUniqueViolation}, want: ErrConstraintUnique},
+		{input: fmt.Errorf("insert: %w", &pgconn.PgError{Code: pgUniqueViolation}), want: ErrConstraintUnique},
+		{input: &pgconn.PgError{Code: "23502"}, want: nil},
+		{input: gorm.ErrRecordNotFound, want: ErrRecordNotFound},
+		{input: otherErr, want: otherErr},
+	}
+	for _, tc := range tests {
+		err := transformPostgresError(tc.input)
+		if tc.want == nil {
+			tc.want = tc.input
+		}
+		if err != tc.want {
+			t.Errorf("expected: %v, got: %v", tc.want, err)
+		}
+	}
+}
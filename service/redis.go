@@ -0,0 +1,372 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default redis connection behavior
+const (
+	DEFAULT_REDIS_POOL_SIZE    = 10
+	DEFAULT_REDIS_DIAL_TIMEOUT = 5 * time.Second
+	DEFAULT_REDIS_IO_TIMEOUT   = 3 * time.Second
+)
+
+// bounds of replies we accept, same as redis server defaults
+const (
+	redisMaxBulkLength  = 512 << 20
+	redisMaxArrayLength = 1 << 20
+)
+
+// Error return from redis client used after Close
+var ErrRedisClosed = errors.New("redis: client closed")
+
+// RedisOption to modify redis connection behavior
+type RedisOption struct {
+	Password string
+	DB       int
+	// PoolSize maximum number of open connections
+	PoolSize int
+	// DialTimeout bound establishing a connection
+	DialTimeout time.Duration
+	// IOTimeout bound each command round trip unless ctx has an earlier deadline
+	IOTimeout time.Duration
+}
+
+// redisError is an error reply sent by the server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisClient speak RESP over a pool of connections
+type redisClient struct {
+	addr string
+	opt  RedisOption
+
+	// sem limit open connections, idle keep connections for reuse
+	sem  chan struct{}
+	idle chan *redisConn
+
+	mux    sync.Mutex
+	closed bool
+}
+
+func newRedisClient(addr string, opts ...RedisOption) *redisClient {
+	opt := RedisOption{
+		PoolSize:    DEFAULT_REDIS_POOL_SIZE,
+		DialTimeout: DEFAULT_REDIS_DIAL_TIMEOUT,
+		IOTimeout:   DEFAULT_REDIS_IO_TIMEOUT,
+	}
+	if len(opts) > 0 {
+		opt.Password = opts[0].Password
+		opt.DB = opts[0].DB
+		if opts[0].PoolSize > 0 {
+			opt.PoolSize = opts[0].PoolSize
+		}
+		if opts[0].DialTimeout > 0 {
+			opt.DialTimeout = opts[0].DialTimeout
+		}
+		if opts[0].IOTimeout > 0 {
+			opt.IOTimeout = opts[0].IOTimeout
+		}
+	}
+
+	return &redisClient{
+		addr: addr,
+		opt:  opt,
+		sem:  make(chan struct{}, opt.PoolSize),
+		idle: make(chan *redisConn, opt.PoolSize),
+	}
+}
+
+// do send a command and return its reply. Error replies are returned as error
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, reused, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(ctx, c.opt.IOTimeout, args...)
+	// error replies leave the connection usable, anything else may not
+	var replyErr redisError
+	broken := err != nil && !errors.As(err, &replyErr)
+	c.put(conn, broken)
+	// an idle connection may have been closed by the server meanwhile,
+	// retry on a new one. Commands we send are safe to repeat, timeouts
+	// are not retried as the server is likely stuck
+	var netErr net.Error
+	timeout := errors.As(err, &netErr) && netErr.Timeout()
+	if broken && reused && !timeout && ctx.Err() == nil {
+		return c.do(ctx, args...)
+	}
+	return reply, err
+}
+
+// dial open a dedicated connection which is not part of the pool
+func (c *redisClient) dial(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: c.opt.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{
+		conn: netConn,
+		r:    bufio.NewReader(netConn),
+		w:    bufio.NewWriter(netConn),
+	}
+	if c.opt.Password != "" {
+		if _, err := conn.do(ctx, c.opt.IOTimeout, "AUTH", c.opt.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.opt.DB != 0 {
+		if _, err := conn.do(ctx, c.opt.IOTimeout, "SELECT", strconv.Itoa(c.opt.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// get return an idle connection, reused is true, or dial a new one
+func (c *redisClient) get(ctx context.Context) (conn *redisConn, reused bool, err error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+
+	c.mux.Lock()
+	closed := c.closed
+	c.mux.Unlock()
+	if closed {
+		<-c.sem
+		return nil, false, ErrRedisClosed
+	}
+
+	select {
+	case conn := <-c.idle:
+		return conn, true, nil
+	default:
+	}
+
+	conn, err = c.dial(ctx)
+	if err != nil {
+		<-c.sem
+		return nil, false, err
+	}
+	return conn, false, nil
+}
+
+func (c *redisClient) put(conn *redisConn, broken bool) {
+	defer func() { <-c.sem }()
+
+	c.mux.Lock()
+	closed := c.closed
+	c.mux.Unlock()
+	if broken || closed {
+		conn.Close()
+		return
+	}
+
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// Close close idle connections, connections in use are closed when returned
+func (c *redisClient) Close() error {
+	c.mux.Lock()
+	c.closed = true
+	c.mux.Unlock()
+
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (c *redisConn) do(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	// interrupt blocking io when ctx is canceled
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.conn.SetDeadline(time.Now())
+			case <-stop:
+			}
+		}()
+	}
+
+	reply, err := c.roundTrip(ctx, timeout, args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// the socket deadline may fire just before ctx timer does
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	return reply, err
+}
+
+func (c *redisConn) roundTrip(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	if err := c.send(ctx, timeout, args...); err != nil {
+		return nil, err
+	}
+	return c.receive(ctx, timeout)
+}
+
+func (c *redisConn) send(ctx context.Context, timeout time.Duration, args ...string) error {
+	c.conn.SetWriteDeadline(redisDeadline(ctx, timeout))
+	if err := writeRedisCommand(c.w, args...); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// receive read one reply. Zero timeout wait until ctx deadline if any
+func (c *redisConn) receive(ctx context.Context, timeout time.Duration) (interface{}, error) {
+	c.conn.SetReadDeadline(redisDeadline(ctx, timeout))
+	return readRedisReply(c.r)
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// redisDeadline pick the earliest of ctx deadline and now plus timeout
+func redisDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	var t time.Time
+	if timeout > 0 {
+		t = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (t.IsZero() || d.Before(t)) {
+		t = d
+	}
+	return t
+}
+
+// writeRedisCommand encode args as an array of bulk strings
+func writeRedisCommand(w *bufio.Writer, args ...string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRedisReply decode one reply. Simple strings and bulk strings are
+// returned as string, integers as int64, arrays as []interface{} and
+// nil bulk strings or arrays as nil
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readRedisLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, redisProtocolError("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return parseRedisInt(line[1:])
+	case '$':
+		n, err := parseRedisInt(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if n > redisMaxBulkLength {
+			return nil, redisProtocolError("bulk string too long")
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := parseRedisInt(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if n > redisMaxArrayLength {
+			return nil, redisProtocolError("array too long")
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			// nested error replies are values, not a failure of the whole reply
+			v, err := readRedisReply(r)
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				v, err = replyErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	default:
+		return nil, redisProtocolError("unexpected reply type " + strconv.Quote(line[:1]))
+	}
+}
+
+func readRedisLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", redisProtocolError("missing CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+func redisProtocolError(msg string) error {
+	return errors.New("redis: protocol error: " + msg)
+}
+
+func parseRedisInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, redisProtocolError("invalid integer " + strconv.Quote(s))
+	}
+	return n, nil
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"strconv"
+)
+
+// RedisCacheStore is a CacheStore shared by every instance connected to the same redis
+type RedisCacheStore interface {
+	CacheStore
+	// Close release pooled connections
+	Close() error
+}
+
+// RedisCacheOption to modify redis cache store behavior
+type RedisCacheOption struct {
+	RedisOption
+	// Codec encode values, default to GobCodec. NoCodec can't be used
+	Codec Codec
+}
+
+// NewRedisCacheStore factory function. Keys are prefixed with keyPrefix
+// so several applications can share a redis database.
+func NewRedisCacheStore(addr, keyPrefix string, opts ...RedisCacheOption) RedisCacheStore {
+	c := &redisCacheStore{
+		prefix: keyPrefix,
+		codec:  GobCodec,
+	}
+	var redisOpts []RedisOption
+	if len(opts) > 0 {
+		redisOpts = append(redisOpts, opts[0].RedisOption)
+		if opts[0].Codec != nil {
+			c.codec = opts[0].Codec
+		}
+	}
+	c.client = newRedisClient(addr, redisOpts...)
+	return c
+}
+
+type redisCacheStore struct {
+	client *redisClient
+	prefix string
+	codec  Codec
+}
+
+func (c *redisCacheStore) Save(ctx context.Context, key string, val interface{}, opts ...CacheOption) error {
+	buf, err := c.codec.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"SET", c.prefix + key, string(buf)}
+	if len(opts) > 0 {
+		ms := opts[0].ExpiresIn.Milliseconds()
+		// redis reject non positive ttl, the value would be expired already
+		if ms <= 0 {
+			return c.Delete(ctx, key)
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	_, err = c.client.do(ctx, args...)
+	return err
+}
+
+func (c *redisCacheStore) Get(ctx context.Context, key string, v interface{}) error {
+	reply, err := c.client.do(ctx, "GET", c.prefix+key)
+	if err != nil {
+		return err
+	}
+	val, ok := reply.(string)
+	if !ok {
+		return ErrCacheKeyNotFound
+	}
+	return c.codec.Unmarshal([]byte(val), v)
+}
+
+func (c *redisCacheStore) Delete(ctx context.Context, key string) error {
+	_, err := c.client.do(ctx, "DEL", c.prefix+key)
+	return err
+}
+
+func (c *redisCacheStore) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRedisCacheStore(t *testing.T) {
+	server := newFakeRedis(t, "")
+	suite.Run(t, &CacheStoreSuite{newStore: func() CacheStore {
+		store := NewRedisCacheStore(server.Addr(), "test:")
+		t.Cleanup(func() { store.Close() })
+		return store
+	}})
+}
+
+func TestRedisCacheStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeRedis(t, "")
+	store := NewRedisCacheStore(server.Addr(), "test:")
+	defer store.Close()
+
+	store.Save(ctx, "key1", "value1", CacheOption{ExpiresIn: 20 * time.Millisecond})
+	var value string
+	if err := store.Get(ctx, "key1", &value); err != nil || value != "value1" {
+		t.Errorf("expected: %v, got: %v %v", "value1", value, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := store.Get(ctx, "key1", &value); err != ErrCacheKeyNotFound {
+		t.Errorf("expected: %v, got: %v", ErrCacheKeyNotFound, err)
+	}
+
+	// keys are prefixed on the server
+	store.Save(ctx, "key2", "value2")
+	server.mux.Lock()
+	_, ok := server.values["test:key2"]
+	server.mux.Unlock()
+	if !ok {
+		t.Error("expected key to be saved with prefix")
+	}
+}
+
+func TestRedisCacheStoreShared(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeRedis(t, "")
+	store1 := NewRedisCacheStore(server.Addr(), "test:")
+	defer store1.Close()
+	store2 := NewRedisCacheStore(server.Addr(), "test:")
+	defer store2.Close()
+
+	// busting on one instance is seen by the other
+	store1.Save(ctx, "key1", "value1")
+	store2.Delete(ctx, "key1")
+
+	var value string
+	if err := store1.Get(ctx, "key1", &value); err != ErrCacheKeyNotFound {
+		t.Errorf("expected: %v, got: %v", ErrCacheKeyNotFound, err)
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// DEFAULT_REDIS_RESUBSCRIBE_DELAY between attempts to restore a lost subscription
+const DEFAULT_REDIS_RESUBSCRIBE_DELAY = time.Second
+
+// NewRedisInvalidationBus factory function. Keys are published on a redis
+// pub/sub channel and delivered to subscribers of every instance listening to
+// it. A lost subscription is restored in background, keys published meanwhile
+// are missed so OnReconnect callbacks are called once it is restored.
+func NewRedisInvalidationBus(addr, channel string, opts ...RedisOption) InvalidationBus {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &redisInvalidationBus{
+		client:  newRedisClient(addr, opts...),
+		channel: channel,
+		delay:   DEFAULT_REDIS_RESUBSCRIBE_DELAY,
+		ctx:     ctx,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+
+	go b.run()
+	return b
+}
+
+type redisInvalidationBus struct {
+	invalidationSubscribers
+	client  *redisClient
+	channel string
+	delay   time.Duration
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+func (b *redisInvalidationBus) Publish(ctx context.Context, key string) error {
+	_, err := b.client.do(ctx, "PUBLISH", b.channel, key)
+	return err
+}
+
+func (b *redisInvalidationBus) Close() error {
+	b.cancel()
+	<-b.stopped
+	return b.client.Close()
+}
+
+// run keep a subscription open until Close
+func (b *redisInvalidationBus) run() {
+	defer close(b.stopped)
+
+	for {
+		b.subscribe()
+
+		select {
+		case <-time.After(b.delay):
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// subscribe deliver published keys until the connection fail or Close
+func (b *redisInvalidationBus) subscribe() {
+	conn, err := b.client.dial(b.ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// a subscribed connection only wait for messages, interrupt it on Close
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-b.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := conn.send(b.ctx, b.client.opt.IOTimeout, "SUBSCRIBE", b.channel); err != nil {
+		return
+	}
+	for {
+		reply, err := conn.receive(b.ctx, 0)
+		if err != nil {
+			return
+		}
+		// ["message", channel, key] or ["subscribe", channel, count]
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != 3 {
+			continue
+		}
+		switch values[0] {
+		case "subscribe":
+			// keys may have been published before this subscription started
+			b.reconnected()
+		case "message":
+			if key, ok := values[2].(string); ok {
+				b.deliver(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// awaitInvalidation publish key until it is received, the subscription is
+// established asynchronously
+func awaitInvalidation(t *testing.T, bus InvalidationBus, received <-chan string, key string) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		if err := bus.Publish(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-received:
+			if got != key {
+				t.Fatalf("expected: %v, got: %v", key, got)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("key %v was not received", key)
+		}
+	}
+}
+
+func TestRedisInvalidationBus(t *testing.T) {
+	server := newFakeRedis(t, "")
+	publisher := NewRedisInvalidationBus(server.Addr(), "invalidate")
+	defer publisher.Close()
+	subscriber := NewRedisInvalidationBus(server.Addr(), "invalidate")
+	defer subscriber.Close()
+
+	received := make(chan string, 10)
+	subscriber.Subscribe(func(key string) { received <- key })
+	awaitInvalidation(t, publisher, received, "123")
+}
+
+func TestRedisInvalidationBusResubscribe(t *testing.T) {
+	server := newFakeRedis(t, "")
+	bus := NewRedisInvalidationBus(server.Addr(), "invalidate")
+	bus.(*redisInvalidationBus).delay = 10 * time.Millisecond
+	defer bus.Close()
+
+	received := make(chan string, 10)
+	reconnects := make(chan struct{}, 10)
+	bus.Subscribe(func(key string) { received <- key })
+	bus.OnReconnect(func() { reconnects <- struct{}{} })
+	awaitInvalidation(t, bus, received, "123")
+	<-reconnects
+
+	// subscription is restored after the server drop connections
+	server.DropConns()
+	awaitInvalidation(t, bus, received, "456")
+	select {
+	case <-reconnects:
+	case <-time.After(time.Second):
+		t.Fatal("expected reconnect callback once subscription is restored")
+	}
+}
+
+func TestRedisInvalidationBusClose(t *testing.T) {
+	server := newFakeRedis(t, "")
+	bus := NewRedisInvalidationBus(server.Addr(), "invalidate")
+
+	done := make(chan struct{})
+	go func() {
+		bus.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("close blocked on subscription")
+	}
+
+	if err := bus.Publish(context.Background(), "123"); err != ErrRedisClosed {
+		t.Errorf("expected: %v, got: %v", ErrRedisClosed, err)
+	}
+}
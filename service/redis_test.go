@@ -0,0 +1,330 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in speaking enough RESP for our client
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mux      sync.Mutex
+	values   map[string]string
+	expireAt map[string]time.Time
+	conns    int
+	open     map[net.Conn]bool
+	// subscribers by channel
+	subscribers map[string]map[*fakeRedisWriter]bool
+	// hang stop replying to simulate a stuck server
+	hang bool
+}
+
+// fakeRedisWriter serialize replies and published messages on a connection
+type fakeRedisWriter struct {
+	mux sync.Mutex
+	w   *bufio.Writer
+}
+
+func (w *fakeRedisWriter) write(reply string) {
+	w.mux.Lock()
+	w.w.WriteString(reply)
+	w.w.Flush()
+	w.mux.Unlock()
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeRedis{
+		listener:    listener,
+		password:    password,
+		values:      make(map[string]string),
+		expireAt:    make(map[string]time.Time),
+		open:        make(map[net.Conn]bool),
+		subscribers: make(map[string]map[*fakeRedisWriter]bool),
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeRedis) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRedis) Conns() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.conns
+}
+
+func (s *fakeRedis) SetHang(hang bool) {
+	s.mux.Lock()
+	s.hang = hang
+	s.mux.Unlock()
+}
+
+// DropConns close every open connection to simulate a server restart
+func (s *fakeRedis) DropConns() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for conn := range s.open {
+		conn.Close()
+	}
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mux.Lock()
+		s.conns++
+		s.open[conn] = true
+		s.mux.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	w := &fakeRedisWriter{w: bufio.NewWriter(conn)}
+	defer func() {
+		s.mux.Lock()
+		delete(s.open, conn)
+		for _, subscribers := range s.subscribers {
+			delete(subscribers, w)
+		}
+		s.mux.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	authed := s.password == ""
+
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, v := range reply.([]interface{}) {
+			args = append(args, v.(string))
+		}
+
+		s.mux.Lock()
+		hang := s.hang
+		s.mux.Unlock()
+		if hang {
+			continue
+		}
+
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "AUTH":
+			if args[1] != s.password {
+				w.write("-WRONGPASS invalid password\r\n")
+				break
+			}
+			authed = true
+			w.write("+OK\r\n")
+		case !authed:
+			w.write("-NOAUTH Authentication required.\r\n")
+		case cmd == "SUBSCRIBE":
+			s.mux.Lock()
+			for i, channel := range args[1:] {
+				if s.subscribers[channel] == nil {
+					s.subscribers[channel] = make(map[*fakeRedisWriter]bool)
+				}
+				s.subscribers[channel][w] = true
+				w.write(fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(channel), channel, i+1))
+			}
+			s.mux.Unlock()
+		default:
+			w.write(s.exec(cmd, args[1:]))
+		}
+	}
+}
+
+func (s *fakeRedis) exec(cmd string, args []string) string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch cmd {
+	case "PING", "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		s.values[args[0]] = args[1]
+		delete(s.expireAt, args[0])
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, err := strconv.Atoi(args[3])
+			if err != nil || ms <= 0 {
+				return "-ERR invalid expire time in 'set' command\r\n"
+			}
+			s.expireAt[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "GET":
+		if t, ok := s.expireAt[args[0]]; ok && t.Before(time.Now()) {
+			delete(s.values, args[0])
+			delete(s.expireAt, args[0])
+		}
+		val, ok := s.values[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)
+	case "DEL":
+		var n int
+		for _, key := range args {
+			if _, ok := s.values[key]; ok {
+				delete(s.values, key)
+				delete(s.expireAt, key)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "PUBLISH":
+		channel, msg := args[0], args[1]
+		for w := range s.subscribers[channel] {
+			w.write(fmt.Sprintf("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(channel), channel, len(msg), msg))
+		}
+		return fmt.Sprintf(":%d\r\n", len(s.subscribers[channel]))
+	default:
+		return "-ERR unknown command '" + cmd + "'\r\n"
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+		err   bool
+	}{
+		{input: "+OK\r\n", want: "OK"},
+		{input: ":42\r\n", want: int64(42)},
+		{input: "$5\r\nhello\r\n", want: "hello"},
+		{input: "$0\r\n\r\n", want: ""},
+		{input: "$-1\r\n", want: nil},
+		{input: "*2\r\n$1\r\na\r\n:1\r\n", want: []interface{}{"a", int64(1)}},
+		{input: "*-1\r\n", want: nil},
+		{input: "-ERR boom\r\n", err: true},
+		{input: "?what\r\n", err: true},
+		{input: ":abc\r\n", err: true},
+		{input: "+OK\n", err: true},
+	}
+	for _, tc := range tests {
+		got, err := readRedisReply(bufio.NewReader(strings.NewReader(tc.input)))
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error: %v", tc.input, err)
+		}
+		if err == nil && fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%q: expected: %v, got: %v", tc.input, tc.want, got)
+		}
+	}
+}
+
+func TestRedisClientPool(t *testing.T) {
+	server := newFakeRedis(t, "")
+	client := newRedisClient(server.Addr(), RedisOption{PoolSize: 2})
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.do(context.Background(), "PING"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if conns := server.Conns(); conns > 2 {
+		t.Errorf("expected at most %v connections, got: %v", 2, conns)
+	}
+
+	// error replies keep the connection in the pool
+	if _, err := client.do(context.Background(), "NOPE"); err == nil {
+		t.Error("expected error reply")
+	}
+	client.do(context.Background(), "PING")
+	if conns := server.Conns(); conns > 2 {
+		t.Errorf("expected at most %v connections, got: %v", 2, conns)
+	}
+}
+
+func TestRedisClientAuth(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+
+	client := newRedisClient(server.Addr(), RedisOption{Password: "secret"})
+	defer client.Close()
+	if _, err := client.do(context.Background(), "PING"); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+
+	client = newRedisClient(server.Addr(), RedisOption{Password: "wrong"})
+	defer client.Close()
+	if _, err := client.do(context.Background(), "PING"); err == nil {
+		t.Error("expected authentication error")
+	}
+}
+
+func TestRedisClientTimeout(t *testing.T) {
+	server := newFakeRedis(t, "")
+	server.SetHang(true)
+
+	client := newRedisClient(server.Addr(), RedisOption{IOTimeout: 20 * time.Millisecond})
+	defer client.Close()
+
+	var netErr net.Error
+	if _, err := client.do(context.Background(), "PING"); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got: %v", err)
+	}
+
+	// ctx deadline earlier than io timeout win
+	client = newRedisClient(server.Addr(), RedisOption{IOTimeout: time.Minute})
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.do(ctx, "PING"); err != context.DeadlineExceeded {
+		t.Errorf("expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRedisClientReconnect(t *testing.T) {
+	server := newFakeRedis(t, "")
+	client := newRedisClient(server.Addr())
+	defer client.Close()
+
+	client.do(context.Background(), "PING")
+	// idle connection closed by the server is replaced transparently
+	server.DropConns()
+	if _, err := client.do(context.Background(), "PING"); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+}
+
+func TestRedisClientClosed(t *testing.T) {
+	server := newFakeRedis(t, "")
+	client := newRedisClient(server.Addr())
+	client.do(context.Background(), "PING")
+	client.Close()
+
+	if _, err := client.do(context.Background(), "PING"); err != ErrRedisClosed {
+		t.Errorf("expected: %v, got: %v", ErrRedisClosed, err)
+	}
+}
@@ -1,9 +1,11 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"strings"
+	"time"
 
-	"github.com/mattn/go-sqlite3"
 	"gorm.io/gorm"
 )
 
@@ -11,48 +13,163 @@ var (
 	ErrConstraintUnique = errors.New("unique constraint failed")
 )
 
+// number of codes deleted by a single statement when purging
+const purgeBatchSize = 500
+
 // URLShortenerRepository to interact with data store
 type URLShortenerRepository interface {
-	CreateShortURL(shortURL *ShortURL) error
-	FindShortURL(code string) (*ShortURL, error)
-	UpdateShortURL(shortURL *ShortURL) error
-	IncreaseShortURLHitCount(code string, count int) error
-	ListShortURLs(offset, size int64, filters ...*FilterParams) ([]*ShortURL, int64, error)
+	CreateShortURL(ctx context.Context, shortURL *ShortURL) error
+	FindShortURL(ctx context.Context, code string) (*ShortURL, error)
+	UpdateShortURL(ctx context.Context, shortURL *ShortURL) error
+	DeleteShortURL(ctx context.Context, code string) error
+	// PurgeShortURLs permanently remove short urls soft deleted before deletedBefore,
+	// return codes of the removed short urls
+	PurgeShortURLs(ctx context.Context, deletedBefore time.Time) ([]string, error)
+	IncreaseShortURLHitCount(ctx context.Context, code string, count int) error
+	// SetShortURLBlocked set the blacklist flag only, a nil blockedAt clear it
+	SetShortURLBlocked(ctx context.Context, code string, blockedAt *time.Time, blockedBy string) error
+	// DisableShortURL soft delete a short url unless it already is, report whether it was
+	DisableShortURL(ctx context.Context, code string, deletedAt time.Time) (bool, error)
+	ListShortURLs(ctx context.Context, params *ListParams) ([]*ShortURL, int64, error)
+}
+
+// ListParams input to ListShortURLs
+type ListParams struct {
+	Offset int64
+	// Size limit number of records, 0 means no limit
+	Size int64
+	// After list records positioned after the cursor, Offset is ignored
+	After *Cursor
+	// WithCount count every record matching Filter, count is 0 otherwise
+	WithCount bool
+	Filter    *FilterParams
+	// Sort zero value is created_at ascending. After is only used with created_at
+	Sort SortParams
+}
+
+// Cursor position of a record in (created_at, id) order
+type Cursor struct {
+	CreatedAt time.Time
+	Id        int64
+}
+
+// Before report whether position c sort before shortURL
+func (c *Cursor) Before(shortURL *ShortURL) bool {
+	if c.CreatedAt.Equal(shortURL.CreatedAt) {
+		return c.Id < shortURL.Id
+	}
+	return c.CreatedAt.Before(shortURL.CreatedAt)
+}
+
+// After report whether position c sort after shortURL
+func (c *Cursor) After(shortURL *ShortURL) bool {
+	if c.CreatedAt.Equal(shortURL.CreatedAt) {
+		return c.Id > shortURL.Id
+	}
+	return c.CreatedAt.After(shortURL.CreatedAt)
 }
 
-// NewURLShortenerRepository factory function
+// sql columns of sort fields
+var sortColumns = map[string]string{
+	SortCreatedAt: "created_at",
+	SortHitCount:  "hit_count",
+	SortExpiresAt: "expires_at",
+}
+
+// NewURLShortenerRepository factory function. Pick implementation
+// matching the dialect of db, sqlite by default
 func NewURLShortenerRepository(db *gorm.DB) URLShortenerRepository {
-	return &sqliteRepository{db: db}
+	if db.Dialector.Name() == "postgres" {
+		return NewPostgresRepository(db)
+	}
+	return &gormRepository{db: db, transformError: transformSQLiteError}
 }
 
-type sqliteRepository struct {
-	db *gorm.DB
+// gormRepository is shared by sql databases, only error codes are driver specific
+type gormRepository struct {
+	db             *gorm.DB
+	transformError func(error) error
 }
 
-func (r *sqliteRepository) CreateShortURL(shortURL *ShortURL) error {
-	if err := r.db.Save(shortURL).Error; err != nil {
-		return transformError(err)
+func (r *gormRepository) CreateShortURL(ctx context.Context, shortURL *ShortURL) error {
+	// keep created_at in UTC, sqlite compare it as text when paginating by cursor
+	if shortURL.CreatedAt.IsZero() {
+		shortURL.CreatedAt = time.Now().UTC()
+	}
+	if err := r.db.WithContext(ctx).Save(shortURL).Error; err != nil {
+		return r.transformError(err)
 	}
 	return nil
 }
 
-func (r *sqliteRepository) FindShortURL(code string) (*ShortURL, error) {
+func (r *gormRepository) FindShortURL(ctx context.Context, code string) (*ShortURL, error) {
 	var shortURL ShortURL
-	if err := r.db.Unscoped().Where("code = ?", code).First(&shortURL).Error; err != nil {
-		return nil, err
+	if err := r.db.WithContext(ctx).Unscoped().Where("code = ?", code).First(&shortURL).Error; err != nil {
+		return nil, r.transformError(err)
 	}
 	return &shortURL, nil
 }
 
-func (r *sqliteRepository) UpdateShortURL(shortURL *ShortURL) error {
+func (r *gormRepository) UpdateShortURL(ctx context.Context, shortURL *ShortURL) error {
 	if shortURL.Id == 0 {
 		return ErrRecordNotFound
 	}
-	return r.db.Model(&ShortURL{Id: shortURL.Id}).Updates(shortURL).Error
+	// select mutable columns explicitly so they can be cleared
+	return r.db.WithContext(ctx).Model(&ShortURL{Id: shortURL.Id}).
+		Select("full_url", "domain", "expires_at", "deleted_at", "blocked_at", "blocked_by").
+		Updates(shortURL).Error
 }
 
-func (r *sqliteRepository) IncreaseShortURLHitCount(code string, count int) error {
-	result := r.db.Model(&ShortURL{}).Where("code = ?", code).
+// DeleteShortURL permanently remove a short url along with its click events,
+// a code created again later doesn't inherit them
+func (r *gormRepository) DeleteShortURL(ctx context.Context, code string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("code = ?", code).Delete(&ShortURL{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return tx.Where("code = ?", code).Delete(&ClickEvent{}).Error
+	})
+}
+
+// PurgeShortURLs permanently remove short urls soft deleted before deletedBefore
+// along with their click events
+func (r *gormRepository) PurgeShortURLs(ctx context.Context, deletedBefore time.Time) ([]string, error) {
+	var codes []string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&ShortURL{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore.UTC()).
+			Pluck("code", &codes).Error
+		if err != nil {
+			return err
+		}
+
+		// delete by batch of codes to stay below the sql variables limit
+		for i := 0; i < len(codes); i += purgeBatchSize {
+			batch := codes[i:]
+			if len(batch) > purgeBatchSize {
+				batch = batch[:purgeBatchSize]
+			}
+			if err := tx.Where("code IN ?", batch).Delete(&ClickEvent{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("code IN ?", batch).Delete(&ShortURL{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (r *gormRepository) IncreaseShortURLHitCount(ctx context.Context, code string, count int) error {
+	result := r.db.WithContext(ctx).Model(&ShortURL{}).Where("code = ?", code).
 		Update("hit_count", gorm.Expr("hit_count + ?", count))
 
 	if result.Error != nil {
@@ -64,33 +181,54 @@ func (r *sqliteRepository) IncreaseShortURLHitCount(code string, count int) erro
 	return nil
 }
 
-func (r *sqliteRepository) ListShortURLs(offset, size int64, filters ...*FilterParams) ([]*ShortURL, int64, error) {
-	var filter *FilterParams
-	if len(filters) > 0 {
-		filter = filters[0]
-	}
-	if offset < 0 {
-		offset = 0
+func (r *gormRepository) SetShortURLBlocked(ctx context.Context, code string, blockedAt *time.Time, blockedBy string) error {
+	if blockedAt != nil {
+		utc := blockedAt.UTC()
+		blockedAt = &utc
 	}
+	result := r.db.WithContext(ctx).Model(&ShortURL{}).Where("code = ?", code).
+		Updates(map[string]interface{}{"blocked_at": blockedAt, "blocked_by": blockedBy})
 
-	scope := r.db.Model(&ShortURL{})
-	if filter != nil {
-		if filter.Code != "" {
-			scope = scope.Where("code = ?", filter.Code)
-		}
-		if filter.Keyword != "" {
-			scope = scope.Where("domain LIKE ?", "%"+filter.Keyword+"%")
-		}
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
 	}
+	return nil
+}
+
+func (r *gormRepository) DisableShortURL(ctx context.Context, code string, deletedAt time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&ShortURL{}).Where("code = ? AND deleted_at IS NULL", code).
+		Update("deleted_at", deletedAt.UTC())
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormRepository) ListShortURLs(ctx context.Context, params *ListParams) ([]*ShortURL, int64, error) {
+	scope := filterScope(r.db.WithContext(ctx).Model(&ShortURL{}), params.Filter)
 
 	var count int64
 	var shortURLs []*ShortURL
 
-	if err := scope.Count(&count).Error; err != nil {
-		return nil, 0, err
+	if params.WithCount {
+		if err := scope.Count(&count).Error; err != nil {
+			return nil, 0, err
+		}
 	}
 
-	scope = scope.Offset(int(offset)).Limit(int(size))
+	scope = scope.Order(orderClause(params.Sort))
+	if after := params.After; after != nil {
+		op := ">"
+		if params.Sort.Desc {
+			op = "<"
+		}
+		scope = scope.Where("(created_at "+op+" ? OR (created_at = ? AND id "+op+" ?))", after.CreatedAt, after.CreatedAt, after.Id)
+	} else if params.Offset > 0 {
+		scope = scope.Offset(int(params.Offset))
+	}
+	if params.Size > 0 {
+		scope = scope.Limit(int(params.Size))
+	}
 	if err := scope.Find(&shortURLs).Error; err != nil {
 		return nil, 0, err
 	}
@@ -98,9 +236,84 @@ func (r *sqliteRepository) ListShortURLs(offset, size int64, filters ...*FilterP
 	return shortURLs, count, nil
 }
 
-func transformError(err error) error {
-	if e, ok := err.(sqlite3.Error); ok && e.Code == 19 {
-		return ErrConstraintUnique
+// likeContains build a case insensitive LIKE pattern matching s literally
+// anywhere, like strings.Contains of memory and bolt repositories
+func likeContains(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
+
+func filterScope(scope *gorm.DB, filter *FilterParams) *gorm.DB {
+	if filter == nil {
+		return scope
+	}
+	if filter.Code != "" {
+		scope = scope.Where("code = ?", filter.Code)
+	}
+	if filter.Keyword != "" {
+		scope = scope.Where(`LOWER(domain) LIKE ? ESCAPE '\'`, likeContains(filter.Keyword))
+	}
+	if filter.URL != "" {
+		scope = scope.Where(`LOWER(full_url) LIKE ? ESCAPE '\'`, likeContains(filter.URL))
+	}
+	if filter.CreatedFrom != nil {
+		scope = scope.Where("created_at >= ?", filter.CreatedFrom.UTC())
+	}
+	if filter.CreatedTo != nil {
+		scope = scope.Where("created_at < ?", filter.CreatedTo.UTC())
+	}
+	switch now := time.Now().UTC(); filter.Expiry {
+	case ExpiryActive:
+		scope = scope.Where("expires_at >= ?", now)
+	case ExpiryExpired:
+		scope = scope.Where("expires_at < ?", now)
+	case ExpiryNever:
+		scope = scope.Where("expires_at IS NULL")
+	}
+	if filter.Deleted != nil {
+		if *filter.Deleted {
+			scope = scope.Where("deleted_at IS NOT NULL")
+		} else {
+			scope = scope.Where("deleted_at IS NULL")
+		}
+	}
+	if filter.Blocked != nil {
+		if *filter.Blocked {
+			scope = scope.Where("blocked_at IS NOT NULL")
+		} else {
+			scope = scope.Where("blocked_at IS NULL")
+		}
+	}
+	if filter.MinHitCount != nil {
+		scope = scope.Where("hit_count >= ?", *filter.MinHitCount)
+	}
+	if filter.MaxHitCount != nil {
+		scope = scope.Where("hit_count <= ?", *filter.MaxHitCount)
+	}
+	return scope
+}
+
+// orderClause sort by field then id, short urls without expiry always come last
+func orderClause(sort SortParams) string {
+	column, ok := sortColumns[sort.Field]
+	if !ok {
+		column = sortColumns[SortCreatedAt]
+	}
+	dir := ""
+	if sort.Desc {
+		dir = " DESC"
+	}
+
+	order := column + dir + ", id" + dir
+	if sort.Field == SortExpiresAt {
+		order = "expires_at IS NULL, " + order
+	}
+	return order
+}
+
+func transformGormError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRecordNotFound
 	}
 	return err
 }
@@ -1,50 +1,44 @@
 package service
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/suite"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-var (
-	testDBName = "../test.sqlite"
-
-	repo URLShortenerRepository
-)
-
+// URLShortenerRepositorySuite run against any URLShortenerRepository implementation
 type URLShortenerRepositorySuite struct {
 	suite.Suite
-	db   *gorm.DB
-	repo URLShortenerRepository
+	// setup return an empty repository before each test
+	setup func() URLShortenerRepository
+	// teardown remove data created by each test
+	teardown func()
+	repo     URLShortenerRepository
 }
 
-func (suite *URLShortenerRepositorySuite) SetupSuite() {
-	db, err := gorm.Open(sqlite.Open(testDBName))
-	if err != nil {
-		panic(err)
+// gormRepositorySuite prepare URLShortenerRepositorySuite backed by a sql database
+func gormRepositorySuite(db *gorm.DB) *URLShortenerRepositorySuite {
+	return &URLShortenerRepositorySuite{
+		setup: func() URLShortenerRepository {
+			db.AutoMigrate(&ShortURL{}, &ClickEvent{})
+			return NewURLShortenerRepository(db)
+		},
+		teardown: func() {
+			db.Exec("DROP TABLE short_urls")
+			db.Exec("DROP TABLE click_events")
+		},
 	}
-
-	suite.db = db
-	suite.repo = NewURLShortenerRepository(db)
 }
 
 func (suite *URLShortenerRepositorySuite) SetupTest() {
-	suite.db.AutoMigrate(&ShortURL{})
+	suite.repo = suite.setup()
 }
 
 func (suite *URLShortenerRepositorySuite) TearDownTest() {
-	suite.db.Exec("DROP TABLE short_urls")
-}
-
-func (suite *URLShortenerRepositorySuite) TearDownSuite() {
-	db, err := suite.db.DB()
-	if err != nil {
-		panic(err)
-	}
-	db.Close()
+	suite.teardown()
 }
 
 func (suite *URLShortenerRepositorySuite) TestCreateShortURL() {
@@ -60,7 +54,7 @@ func (suite *URLShortenerRepositorySuite) TestCreateShortURL() {
 	}
 
 	for _, tc := range tests {
-		err := suite.repo.CreateShortURL(tc.input)
+		err := suite.repo.CreateShortURL(context.Background(), tc.input)
 		suite.Equal(tc.want, err)
 	}
 }
@@ -72,7 +66,7 @@ func (suite *URLShortenerRepositorySuite) TestFindShortURL() {
 		{FullURL: "http://example2.com", Domain: "example2.com", Code: "789"},
 	}
 	for _, s := range shortURLs {
-		suite.repo.CreateShortURL(s)
+		suite.repo.CreateShortURL(context.Background(), s)
 	}
 
 	type test struct {
@@ -83,10 +77,10 @@ func (suite *URLShortenerRepositorySuite) TestFindShortURL() {
 
 	tests := []test{
 		{input: "123", want: nil, shortURL: shortURLs[0]},
-		{input: "321", want: gorm.ErrRecordNotFound, shortURL: nil},
+		{input: "321", want: ErrRecordNotFound, shortURL: nil},
 	}
 	for _, tc := range tests {
-		s, err := suite.repo.FindShortURL(tc.input)
+		s, err := suite.repo.FindShortURL(context.Background(), tc.input)
 		suite.Equal(tc.want, err)
 
 		if s != nil {
@@ -97,7 +91,7 @@ func (suite *URLShortenerRepositorySuite) TestFindShortURL() {
 
 func (suite *URLShortenerRepositorySuite) TestUpdateShortURL() {
 	shortURL := ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"}
-	suite.repo.CreateShortURL(&shortURL)
+	suite.repo.CreateShortURL(context.Background(), &shortURL)
 
 	type test struct {
 		input *ShortURL
@@ -109,6 +103,8 @@ func (suite *URLShortenerRepositorySuite) TestUpdateShortURL() {
 		{
 			input: &ShortURL{
 				Id:        shortURL.Id,
+				FullURL:   shortURL.FullURL,
+				Domain:    shortURL.Domain,
 				ExpiresAt: &expiresAt,
 			},
 			want: nil,
@@ -122,23 +118,119 @@ func (suite *URLShortenerRepositorySuite) TestUpdateShortURL() {
 	}
 
 	for _, tc := range tests {
-		err := suite.repo.UpdateShortURL(tc.input)
+		err := suite.repo.UpdateShortURL(context.Background(), tc.input)
 		suite.Equal(tc.want, err)
 	}
+
+	// clear expiry and change destination
+	s, _ := suite.repo.FindShortURL(context.Background(), "123")
+	suite.NotNil(s.ExpiresAt)
+	s.FullURL = "http://example1.com"
+	s.Domain = "example1.com"
+	s.ExpiresAt = nil
+	blockedAt := time.Now().UTC().Truncate(time.Second)
+	s.BlockedAt = &blockedAt
+	s.BlockedBy = "domain:example1.com"
+	suite.Nil(suite.repo.UpdateShortURL(context.Background(), s))
+
+	s, _ = suite.repo.FindShortURL(context.Background(), "123")
+	suite.Equal("http://example1.com", s.FullURL)
+	suite.Equal("example1.com", s.Domain)
+	suite.Nil(s.ExpiresAt)
+	suite.True(blockedAt.Equal(*s.BlockedAt))
+	suite.Equal("domain:example1.com", s.BlockedBy)
+}
+
+func (suite *URLShortenerRepositorySuite) TestDeleteShortURL() {
+	suite.repo.CreateShortURL(context.Background(), &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+
+	suite.Nil(suite.repo.DeleteShortURL(context.Background(), "123"))
+	_, err := suite.repo.FindShortURL(context.Background(), "123")
+	suite.Equal(ErrRecordNotFound, err)
+
+	suite.Equal(ErrRecordNotFound, suite.repo.DeleteShortURL(context.Background(), "123"))
+}
+
+func (suite *URLShortenerRepositorySuite) TestPurgeShortURLs() {
+	now := time.Now().UTC()
+	oldDeletedAt := now.AddDate(0, 0, -10)
+	newDeletedAt := now.AddDate(0, 0, -1)
+	shortURLs := []*ShortURL{
+		{FullURL: "http://example.com", Domain: "example.com", Code: "123", DeletedAt: &oldDeletedAt},
+		{FullURL: "http://example1.com", Domain: "example1.com", Code: "456", DeletedAt: &newDeletedAt},
+		{FullURL: "http://example2.com", Domain: "example2.com", Code: "789"},
+	}
+	for _, s := range shortURLs {
+		suite.repo.CreateShortURL(context.Background(), s)
+	}
+
+	codes, err := suite.repo.PurgeShortURLs(context.Background(), now.AddDate(0, 0, -7))
+	suite.Nil(err)
+	suite.Equal([]string{"123"}, codes)
+
+	_, err = suite.repo.FindShortURL(context.Background(), "123")
+	suite.Equal(ErrRecordNotFound, err)
+	for _, code := range []string{"456", "789"} {
+		_, err = suite.repo.FindShortURL(context.Background(), code)
+		suite.Nil(err)
+	}
 }
 
 func (suite *URLShortenerRepositorySuite) TestIncreaseShortURLHitCount() {
-	suite.repo.CreateShortURL(&ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+	suite.repo.CreateShortURL(context.Background(), &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
 
-	err := suite.repo.IncreaseShortURLHitCount("123", 1)
+	err := suite.repo.IncreaseShortURLHitCount(context.Background(), "123", 1)
 	suite.Equal(nil, err)
-	s1, _ := suite.repo.FindShortURL("123")
+	s1, _ := suite.repo.FindShortURL(context.Background(), "123")
 	suite.EqualValues(1, s1.HitCount)
 
-	err = suite.repo.IncreaseShortURLHitCount("456", 1)
+	err = suite.repo.IncreaseShortURLHitCount(context.Background(), "456", 1)
 	suite.Equal(ErrRecordNotFound, err)
 }
 
+func (suite *URLShortenerRepositorySuite) TestSetShortURLBlocked() {
+	expiresAt := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	suite.repo.CreateShortURL(context.Background(), &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123", ExpiresAt: &expiresAt})
+
+	blockedAt := time.Now().UTC().Truncate(time.Second)
+	suite.Nil(suite.repo.SetShortURLBlocked(context.Background(), "123", &blockedAt, "domain:example.com"))
+	s, _ := suite.repo.FindShortURL(context.Background(), "123")
+	suite.True(blockedAt.Equal(*s.BlockedAt))
+	suite.Equal("domain:example.com", s.BlockedBy)
+	// other columns are left as is
+	suite.Equal("http://example.com", s.FullURL)
+	suite.True(expiresAt.Equal(*s.ExpiresAt))
+
+	suite.Nil(suite.repo.SetShortURLBlocked(context.Background(), "123", nil, ""))
+	s, _ = suite.repo.FindShortURL(context.Background(), "123")
+	suite.Nil(s.BlockedAt)
+	suite.Equal("", s.BlockedBy)
+
+	suite.Equal(ErrRecordNotFound, suite.repo.SetShortURLBlocked(context.Background(), "456", nil, ""))
+}
+
+func (suite *URLShortenerRepositorySuite) TestDisableShortURL() {
+	suite.repo.CreateShortURL(context.Background(), &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+
+	deletedAt := time.Now().UTC().Truncate(time.Second)
+	disabled, err := suite.repo.DisableShortURL(context.Background(), "123", deletedAt)
+	suite.Nil(err)
+	suite.True(disabled)
+	s, _ := suite.repo.FindShortURL(context.Background(), "123")
+	suite.True(deletedAt.Equal(*s.DeletedAt))
+
+	// already soft deleted short url keep its deletion time
+	disabled, err = suite.repo.DisableShortURL(context.Background(), "123", deletedAt.Add(time.Hour))
+	suite.Nil(err)
+	suite.False(disabled)
+	s, _ = suite.repo.FindShortURL(context.Background(), "123")
+	suite.True(deletedAt.Equal(*s.DeletedAt))
+
+	disabled, err = suite.repo.DisableShortURL(context.Background(), "456", deletedAt)
+	suite.Nil(err)
+	suite.False(disabled)
+}
+
 func (suite *URLShortenerRepositorySuite) TestListShortURLs() {
 	shortURLs := []ShortURL{
 		{FullURL: "http://example.com", Domain: "example.com", Code: "123"},
@@ -146,22 +238,24 @@ func (suite *URLShortenerRepositorySuite) TestListShortURLs() {
 		{FullURL: "http://myawesome-site.com", Domain: "myawesome-site.com", Code: "789"},
 	}
 	for _, shortURL := range shortURLs {
-		suite.repo.CreateShortURL(&shortURL)
+		suite.repo.CreateShortURL(context.Background(), &shortURL)
 	}
 
 	type test struct {
-		input *FindParams
+		input *ListParams
 		codes []string
 		count int64
 	}
 
-	params := []*FindParams{
+	params := []*ListParams{
+		{Offset: 0, Size: 10, WithCount: true},
+		{Offset: 1, Size: 1, WithCount: true},
+		{Offset: 0, Size: 30, WithCount: true, Filter: &FilterParams{Code: "123"}},
+		{Offset: 0, Size: 30, WithCount: true, Filter: &FilterParams{Code: "321"}},
+		{Offset: 0, Size: 30, WithCount: true, Filter: &FilterParams{Keyword: "awesome"}},
+		{Offset: 0, Size: 30, WithCount: true, Filter: &FilterParams{Code: "123", Keyword: "awesome"}},
+		{Offset: 0, Size: 30, WithCount: true, Filter: &FilterParams{Keyword: "AWESOME"}},
 		{Offset: 0, Size: 10},
-		{Offset: 1, Size: 1},
-		{Offset: 0, Size: 30, Filter: &FilterParams{Code: "123"}},
-		{Offset: 0, Size: 30, Filter: &FilterParams{Code: "321"}},
-		{Offset: 0, Size: 30, Filter: &FilterParams{Keyword: "awesome"}},
-		{Offset: 0, Size: 30, Filter: &FilterParams{Code: "123", Keyword: "awesome"}},
 	}
 
 	tests := []test{
@@ -171,9 +265,11 @@ func (suite *URLShortenerRepositorySuite) TestListShortURLs() {
 		{input: params[3], codes: nil, count: 0},
 		{input: params[4], codes: []string{"789"}, count: 1},
 		{input: params[5], codes: nil, count: 0},
+		{input: params[6], codes: []string{"789"}, count: 1},
+		{input: params[7], codes: []string{"123", "456", "789"}, count: 0},
 	}
 	for _, tc := range tests {
-		shortURLs, count, _ := suite.repo.ListShortURLs(tc.input.Offset, tc.input.Size, tc.input.Filter)
+		shortURLs, count, _ := suite.repo.ListShortURLs(context.Background(), tc.input)
 
 		var codes []string
 		for _, shortURL := range shortURLs {
@@ -184,6 +280,189 @@ func (suite *URLShortenerRepositorySuite) TestListShortURLs() {
 	}
 }
 
+func (suite *URLShortenerRepositorySuite) TestListShortURLsAfterCursor() {
+	// same created_at so id break the tie
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	shortURLs := []*ShortURL{
+		{FullURL: "http://example.com", Domain: "example.com", Code: "123", CreatedAt: createdAt.Add(time.Second)},
+		{FullURL: "http://example1.com", Domain: "example1.com", Code: "456", CreatedAt: createdAt},
+		{FullURL: "http://example2.com", Domain: "example2.com", Code: "789", CreatedAt: createdAt},
+	}
+	for _, s := range shortURLs {
+		suite.repo.CreateShortURL(context.Background(), s)
+	}
+
+	var codes []string
+	params := &ListParams{Size: 1}
+	for {
+		page, _, err := suite.repo.ListShortURLs(context.Background(), params)
+		suite.Require().Nil(err)
+		if len(page) == 0 {
+			break
+		}
+		codes = append(codes, page[0].Code)
+		params.After = &Cursor{CreatedAt: page[0].CreatedAt, Id: page[0].Id}
+	}
+	suite.Equal([]string{"456", "789", "123"}, codes)
+
+	// cursor work together with filters
+	page, _, _ := suite.repo.ListShortURLs(context.Background(), &ListParams{
+		After:  &Cursor{CreatedAt: createdAt, Id: shortURLs[1].Id},
+		Filter: &FilterParams{Keyword: "example"},
+	})
+	suite.Len(page, 2)
+}
+
+func (suite *URLShortenerRepositorySuite) TestListShortURLsFilters() {
+	now := time.Now().UTC()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	shortURLs := []*ShortURL{
+		{FullURL: "http://example.com/blog/post", Domain: "example.com", Code: "123", CreatedAt: now.AddDate(0, 0, -3), ExpiresAt: &past},
+		{FullURL: "http://example1.com/shop", Domain: "example1.com", Code: "456", CreatedAt: now.AddDate(0, 0, -2), ExpiresAt: &future, BlockedAt: &now, BlockedBy: "domain:example1.com"},
+		{FullURL: "http://example2.com/Blog", Domain: "example2.com", Code: "789", CreatedAt: now.AddDate(0, 0, -1), DeletedAt: &now},
+	}
+	for _, s := range shortURLs {
+		suite.repo.CreateShortURL(context.Background(), s)
+	}
+	suite.repo.IncreaseShortURLHitCount(context.Background(), "456", 5)
+	suite.repo.IncreaseShortURLHitCount(context.Background(), "789", 10)
+
+	yes, no := true, false
+	five, nine := int64(5), int64(9)
+	from, to := now.AddDate(0, 0, -2), now.AddDate(0, 0, -1)
+
+	tests := []struct {
+		filter *FilterParams
+		codes  []string
+	}{
+		{filter: &FilterParams{URL: "blog"}, codes: []string{"123", "789"}},
+		{filter: &FilterParams{CreatedFrom: &from}, codes: []string{"456", "789"}},
+		{filter: &FilterParams{CreatedTo: &to}, codes: []string{"123", "456"}},
+		{filter: &FilterParams{CreatedFrom: &from, CreatedTo: &to}, codes: []string{"456"}},
+		{filter: &FilterParams{Expiry: ExpiryActive}, codes: []string{"456"}},
+		{filter: &FilterParams{Expiry: ExpiryExpired}, codes: []string{"123"}},
+		{filter: &FilterParams{Expiry: ExpiryNever}, codes: []string{"789"}},
+		{filter: &FilterParams{Deleted: &yes}, codes: []string{"789"}},
+		{filter: &FilterParams{Deleted: &no}, codes: []string{"123", "456"}},
+		{filter: &FilterParams{Blocked: &yes}, codes: []string{"456"}},
+		{filter: &FilterParams{Blocked: &no}, codes: []string{"123", "789"}},
+		{filter: &FilterParams{MinHitCount: &five}, codes: []string{"456", "789"}},
+		{filter: &FilterParams{MaxHitCount: &nine}, codes: []string{"123", "456"}},
+		{filter: &FilterParams{MinHitCount: &five, MaxHitCount: &nine}, codes: []string{"456"}},
+		{filter: &FilterParams{URL: "blog", Deleted: &no}, codes: []string{"123"}},
+	}
+	for _, tc := range tests {
+		shortURLs, count, err := suite.repo.ListShortURLs(context.Background(), &ListParams{WithCount: true, Filter: tc.filter})
+		suite.Nil(err)
+
+		var codes []string
+		for _, shortURL := range shortURLs {
+			codes = append(codes, shortURL.Code)
+		}
+		suite.Equal(tc.codes, codes, "filter: %+v", tc.filter)
+		suite.EqualValues(len(tc.codes), count)
+	}
+}
+
+func (suite *URLShortenerRepositorySuite) TestListShortURLsFilterWildcards() {
+	shortURLs := []*ShortURL{
+		{FullURL: "http://example.com/a_b", Domain: "example.com", Code: "123"},
+		{FullURL: "http://example.com/axb", Domain: "example.com", Code: "456"},
+		{FullURL: "http://example.com/100%25", Domain: "example.com", Code: "789"},
+		{FullURL: `http://example.com/a\b`, Domain: "example.com", Code: "abc"},
+	}
+	for _, s := range shortURLs {
+		suite.repo.CreateShortURL(context.Background(), s)
+	}
+
+	// LIKE wildcards are matched literally
+	tests := []struct {
+		filter *FilterParams
+		codes  []string
+	}{
+		{filter: &FilterParams{URL: "_"}, codes: []string{"123"}},
+		{filter: &FilterParams{URL: "a_b"}, codes: []string{"123"}},
+		{filter: &FilterParams{URL: "%"}, codes: []string{"789"}},
+		{filter: &FilterParams{URL: `\`}, codes: []string{"abc"}},
+		{filter: &FilterParams{Keyword: "_"}, codes: nil},
+	}
+	for _, tc := range tests {
+		shortURLs, count, err := suite.repo.ListShortURLs(context.Background(), &ListParams{WithCount: true, Filter: tc.filter})
+		suite.Nil(err)
+
+		var codes []string
+		for _, shortURL := range shortURLs {
+			codes = append(codes, shortURL.Code)
+		}
+		suite.ElementsMatch(tc.codes, codes, "filter: %+v", tc.filter)
+		suite.EqualValues(len(tc.codes), count)
+	}
+}
+
+func (suite *URLShortenerRepositorySuite) TestListShortURLsSort() {
+	now := time.Now().UTC()
+	soon, later := now.Add(time.Hour), now.Add(2*time.Hour)
+	shortURLs := []*ShortURL{
+		{FullURL: "http://example.com", Domain: "example.com", Code: "123", CreatedAt: now.Add(-3 * time.Minute)},
+		{FullURL: "http://example1.com", Domain: "example1.com", Code: "456", CreatedAt: now.Add(-2 * time.Minute), ExpiresAt: &later},
+		{FullURL: "http://example2.com", Domain: "example2.com", Code: "789", CreatedAt: now.Add(-1 * time.Minute), ExpiresAt: &soon},
+	}
+	for _, s := range shortURLs {
+		suite.repo.CreateShortURL(context.Background(), s)
+	}
+	suite.repo.IncreaseShortURLHitCount(context.Background(), "123", 3)
+	suite.repo.IncreaseShortURLHitCount(context.Background(), "789", 1)
+
+	tests := []struct {
+		sort  SortParams
+		codes []string
+	}{
+		{sort: SortParams{}, codes: []string{"123", "456", "789"}},
+		{sort: SortParams{Field: SortCreatedAt, Desc: true}, codes: []string{"789", "456", "123"}},
+		{sort: SortParams{Field: SortHitCount}, codes: []string{"456", "789", "123"}},
+		{sort: SortParams{Field: SortHitCount, Desc: true}, codes: []string{"123", "789", "456"}},
+		// short urls without expiry come last in both directions
+		{sort: SortParams{Field: SortExpiresAt}, codes: []string{"789", "456", "123"}},
+		{sort: SortParams{Field: SortExpiresAt, Desc: true}, codes: []string{"456", "789", "123"}},
+	}
+	for _, tc := range tests {
+		shortURLs, _, err := suite.repo.ListShortURLs(context.Background(), &ListParams{Sort: tc.sort})
+		suite.Nil(err)
+
+		var codes []string
+		for _, shortURL := range shortURLs {
+			codes = append(codes, shortURL.Code)
+		}
+		suite.Equal(tc.codes, codes, "sort: %+v", tc.sort)
+	}
+
+	// descending cursor continue below the given position
+	desc := SortParams{Field: SortCreatedAt, Desc: true}
+	page, _, _ := suite.repo.ListShortURLs(context.Background(), &ListParams{
+		Size:  1,
+		Sort:  desc,
+		After: &Cursor{CreatedAt: shortURLs[2].CreatedAt, Id: shortURLs[2].Id},
+	})
+	suite.Require().Len(page, 1)
+	suite.Equal("456", page[0].Code)
+}
+
+func (suite *URLShortenerRepositorySuite) TestCanceledContext() {
+	suite.repo.CreateShortURL(context.Background(), &ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := suite.repo.FindShortURL(ctx, "123")
+	suite.ErrorIs(err, context.Canceled)
+	err = suite.repo.IncreaseShortURLHitCount(ctx, "123", 1)
+	suite.ErrorIs(err, context.Canceled)
+}
+
 func TestURLShortenerRepository(t *testing.T) {
-	suite.Run(t, new(URLShortenerRepositorySuite))
+	for _, database := range testDatabases() {
+		t.Run(database.name, func(t *testing.T) {
+			suite.Run(t, gormRepositorySuite(openTestDB(t, database.dialector)))
+		})
+	}
 }
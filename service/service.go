@@ -1,144 +1,386 @@
 package service
 
 import (
-	"crypto/rand"
+	"context"
 	"encoding/base64"
+	"errors"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Default random short code length
+const DEFAULT_SHORT_CODE_LENGTH = 16
+
 // Max random short code length
-const MAX_SHORT_CODE_LENGTH = 12
+//
+// Deprecated: use DEFAULT_SHORT_CODE_LENGTH instead, codes are no longer capped by it
+const MAX_SHORT_CODE_LENGTH = DEFAULT_SHORT_CODE_LENGTH
+
+// Default number of attempts to create a short url when generated code collides
+const DEFAULT_MAX_CREATE_ATTEMPTS = 5
+
+// Custom alias length boundary
+const (
+	MIN_ALIAS_LENGTH = 3
+	MAX_ALIAS_LENGTH = 64
+)
+
+var (
+	rxAlias = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// aliases that would shadow our own routes
+	reservedAliases = map[string]bool{
+		"shorten": true,
+		"admin":   true,
+	}
+)
 
 // Errors return from service
 var (
 	ErrInvalidURL       = newError("invalid url", http.StatusBadRequest)
 	ErrInvalidExpiresIn = newError("invalid expires in", http.StatusBadRequest)
+	ErrInvalidExpiresAt = newError("invalid expires at", http.StatusBadRequest)
 	ErrRecordNotFound   = newError("record not found", http.StatusNotFound)
 	ErrShortURLExpired  = newError("url expired", http.StatusGone)
 	ErrBlockedURL       = newError("url is blocked", http.StatusBadRequest)
+	ErrNotAllowedURL    = newError("url is not allowed", http.StatusForbidden)
+	ErrInvalidAlias     = newError("invalid alias", http.StatusBadRequest)
+	ErrReservedAlias    = newError("alias is reserved", http.StatusBadRequest)
+	ErrAliasTaken       = newError("alias taken", http.StatusConflict)
+	ErrInvalidCursor    = newError("invalid cursor", http.StatusBadRequest)
+	ErrCursorSort       = newError("cursor requires sort by createdAt", http.StatusBadRequest)
+	ErrInvalidSort      = newError("invalid sort", http.StatusBadRequest)
+	ErrInvalidExpiry    = newError("invalid expiry", http.StatusBadRequest)
+	ErrInvalidHitCount  = newError("invalid hit count range", http.StatusBadRequest)
 )
 
 // ShortURLInput used to create a ShortURL
 type ShortURLInput struct {
 	URL       string
-	ExpiresIn int64 // second
+	ExpiresIn int64  // second
+	Alias     string // optional custom code
+}
+
+// UpdateShortURLInput used to update a ShortURL. Nil fields are left unchanged
+type UpdateShortURLInput struct {
+	URL       *string
+	ExpiresAt *time.Time
+	// ClearExpiresAt make the short url never expire, ExpiresAt is ignored
+	ClearExpiresAt bool
 }
 
+// Expiry states to filter short urls
+const (
+	ExpiryActive  = "active" // expire in the future
+	ExpiryExpired = "expired"
+	ExpiryNever   = "never"
+)
+
+// Fields to sort short urls
+const (
+	SortCreatedAt = "createdAt"
+	SortHitCount  = "hitCount"
+	SortExpiresAt = "expiresAt"
+)
+
 // FindParams used to get/filter short urls
 type FindParams struct {
 	Offset int64
 	Size   int64
+	// Cursor is the NextCursor of a previous page, Offset is ignored when set
+	Cursor string
+	// WithCount compute TotalCount, which needs to scan every matching record
+	WithCount bool
+	// Sort is one of the Sort* fields, prefix with - for descending order.
+	// Default to createdAt
+	Sort   string
 	Filter *FilterParams
 }
 
-// FilterParams input to filter ShortURL
+// FilterParams input to filter ShortURL. Zero value fields are ignored
 type FilterParams struct {
 	Code    string
 	Keyword string
+	// URL is a case insensitive substring of the full url
+	URL         string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// Expiry is one of the Expiry* states
+	Expiry string
+	// Deleted keep only soft deleted short urls when true, only live ones when false
+	Deleted *bool
+	// Blocked keep only short urls flagged by blacklist scan when true, only unflagged ones when false
+	Blocked     *bool
+	MinHitCount *int64
+	MaxHitCount *int64
+}
+
+// SortParams order of listed short urls, ties are broken by id
+type SortParams struct {
+	Field string
+	Desc  bool
 }
 
 // Result type returned by FindURLs
 type Result struct {
-	Data       []*ShortURL
-	TotalCount int64
+	Data []*ShortURL
+	// TotalCount is nil unless requested by FindParams.WithCount
+	TotalCount *int64
+	// NextCursor is empty on the last page
+	NextCursor string
 }
 
 // URLShortener public service interface
 type URLShortener interface {
 	// Create a new short url
-	Create(input ShortURLInput) (string, error)
+	Create(ctx context.Context, input ShortURLInput) (string, error)
+	// Update full url and expiry of a short url
+	Update(ctx context.Context, code string, input UpdateShortURLInput) (*ShortURL, error)
 	// FindURLs return a list of short urls
-	FindURLs(params *FindParams) (*Result, error)
+	FindURLs(ctx context.Context, params *FindParams) (*Result, error)
 	// Delete a short url
-	Delete(code string) error
+	Delete(ctx context.Context, code string) error
+	// Restore a deleted short url
+	Restore(ctx context.Context, code string) error
+	// Purge permanently delete a short url
+	Purge(ctx context.Context, code string) error
+	// PurgeDeleted permanently delete short urls deleted before given time
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error)
 	// IncreaseHitCount of a short url
-	IncreaseHitCount(code string) error
+	IncreaseHitCount(ctx context.Context, code string) error
 	// Get full url from code and increase hit count
-	GetFullURL(code string) (string, error)
+	GetFullURL(ctx context.Context, code string) (string, error)
+}
+
+// ServiceOption to modify service behavior
+type ServiceOption struct {
+	// CodeGenerator used when no alias is given. Default to crypto-random code
+	CodeGenerator CodeGenerator
+	// MaxCreateAttempts bound retries when generated code already exists
+	MaxCreateAttempts int
 }
 
 // NewURLShortener factory function
-func NewURLShortener(repo URLShortenerRepository) URLShortener {
-	return &urlShortener{repo: repo}
+func NewURLShortener(repo URLShortenerRepository, opts ...ServiceOption) URLShortener {
+	s := &urlShortener{
+		repo:        repo,
+		maxAttempts: DEFAULT_MAX_CREATE_ATTEMPTS,
+	}
+	if len(opts) > 0 {
+		s.generator = opts[0].CodeGenerator
+		if opts[0].MaxCreateAttempts > 0 {
+			s.maxAttempts = opts[0].MaxCreateAttempts
+		}
+	}
+	if s.generator == nil {
+		// can only fail with invalid arguments
+		s.generator, _ = NewRandomCodeGenerator(DEFAULT_SHORT_CODE_LENGTH, Base64URLAlphabet)
+	}
+	return s
 }
 
 type urlShortener struct {
-	repo URLShortenerRepository
+	repo        URLShortenerRepository
+	generator   CodeGenerator
+	maxAttempts int
 }
 
-func (s *urlShortener) Create(input ShortURLInput) (string, error) {
-	u, err := url.ParseRequestURI(input.URL)
-	if err != nil || u.Host == "" {
-		return "", ErrInvalidURL
-	}
-	domain := u.Host
-	if u.Port() != "" {
-		domain += ":" + u.Port()
-	}
-
-	code, err := getRandomShortCode(MAX_SHORT_CODE_LENGTH)
+func (s *urlShortener) Create(ctx context.Context, input ShortURLInput) (string, error) {
+	domain, err := parseDomain(input.URL)
 	if err != nil {
 		return "", err
 	}
 
-	shortURL := ShortURL{
-		Code:    code,
-		FullURL: input.URL,
-		Domain:  domain,
+	if input.Alias != "" {
+		if err := validateAlias(input.Alias); err != nil {
+			return "", err
+		}
 	}
 	if input.ExpiresIn < 0 {
 		return "", ErrInvalidExpiresIn
 	}
+
+	shortURL := ShortURL{
+		FullURL: input.URL,
+		Domain:  domain,
+	}
 	if input.ExpiresIn > 0 {
 		d := time.Duration(input.ExpiresIn) * time.Second
 		expiresAt := time.Now().Add(d).UTC()
 		shortURL.ExpiresAt = &expiresAt
 	}
 
-	if err := s.repo.CreateShortURL(&shortURL); err != nil {
-		return "", err
+	// an alias is tried only once, generated codes are retried on collision
+	for attempt := 1; ; attempt++ {
+		shortURL.Code = input.Alias
+		if shortURL.Code == "" {
+			if shortURL.Code, err = s.generator.Generate(ctx); err != nil {
+				return "", err
+			}
+		}
+
+		err = s.repo.CreateShortURL(ctx, &shortURL)
+		if err == nil {
+			return shortURL.Code, nil
+		}
+		if err != ErrConstraintUnique {
+			return "", err
+		}
+		if input.Alias != "" {
+			return "", ErrAliasTaken
+		}
+		if attempt >= s.maxAttempts {
+			return "", err
+		}
+		// stop retrying once the caller gave up
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
 	}
-	return code, nil
 }
 
-func (s *urlShortener) FindURLs(params *FindParams) (*Result, error) {
-	shortURLs, count, err := s.repo.ListShortURLs(params.Offset, params.Size, params.Filter)
+func (s *urlShortener) Update(ctx context.Context, code string, input UpdateShortURLInput) (*ShortURL, error) {
+	shortURL, err := s.findForUpdate(ctx, code)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if input.URL != nil {
+		domain, err := parseDomain(*input.URL)
+		if err != nil {
+			return nil, err
+		}
+		shortURL.FullURL = *input.URL
+		shortURL.Domain = domain
+	}
+	if input.ClearExpiresAt {
+		shortURL.ExpiresAt = nil
+	} else if input.ExpiresAt != nil {
+		if input.ExpiresAt.Before(time.Now()) {
+			return nil, ErrInvalidExpiresAt
+		}
+		expiresAt := input.ExpiresAt.UTC()
+		shortURL.ExpiresAt = &expiresAt
+	}
+
+	if err := s.repo.UpdateShortURL(ctx, shortURL); err != nil {
+		return nil, err
+	}
+	return shortURL, nil
+}
+
+func (s *urlShortener) FindURLs(ctx context.Context, params *FindParams) (*Result, error) {
+	listParams := &ListParams{
+		Offset:    params.Offset,
+		Size:      params.Size,
+		WithCount: params.WithCount,
+		Filter:    params.Filter,
+	}
+	if err := validateFilter(params.Filter); err != nil {
+		return nil, err
+	}
+	sort, err := parseSort(params.Sort)
+	if err != nil {
+		return nil, err
+	}
+	listParams.Sort = sort
+	// cursor is a position in (created_at, id) order
+	if params.Cursor != "" {
+		if sort.Field != SortCreatedAt {
+			return nil, ErrCursorSort
+		}
+		cursor, err := decodeCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		listParams.After = cursor
+	}
+	// fetch one extra record to know if there is a next page
+	if params.Size > 0 {
+		listParams.Size = params.Size + 1
+	}
+
+	shortURLs, count, err := s.repo.ListShortURLs(ctx, listParams)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Result{
-		Data:       shortURLs,
-		TotalCount: count,
-	}, nil
+	result := &Result{Data: shortURLs}
+	if params.Size > 0 && int64(len(shortURLs)) > params.Size {
+		result.Data = shortURLs[:params.Size]
+		if sort.Field == SortCreatedAt {
+			result.NextCursor = encodeCursor(result.Data[params.Size-1])
+		}
+	}
+	if params.WithCount {
+		result.TotalCount = &count
+	}
+	return result, nil
 }
 
-func (s *urlShortener) Delete(code string) error {
-	shortURL, err := s.repo.FindShortURL(code)
+func (s *urlShortener) Delete(ctx context.Context, code string) error {
+	shortURL, err := s.findForUpdate(ctx, code)
 	if err != nil {
-		return ErrRecordNotFound
+		return notFound(err)
 	}
 
 	// soft delete short url
 	deletedAt := time.Now().UTC()
 	shortURL.DeletedAt = &deletedAt
-	if err := s.repo.UpdateShortURL(shortURL); err != nil {
+	if err := s.repo.UpdateShortURL(ctx, shortURL); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *urlShortener) IncreaseHitCount(code string) error {
-	return s.repo.IncreaseShortURLHitCount(code, 1)
+func (s *urlShortener) Restore(ctx context.Context, code string) error {
+	shortURL, err := s.findForUpdate(ctx, code)
+	if err != nil {
+		return notFound(err)
+	}
+	if shortURL.DeletedAt == nil {
+		return nil
+	}
+
+	shortURL.DeletedAt = nil
+	return s.repo.UpdateShortURL(ctx, shortURL)
+}
+
+// findForUpdate read code bypassing cache, the record is written back whole so
+// a stale copy would revert changes made meanwhile
+func (s *urlShortener) findForUpdate(ctx context.Context, code string) (*ShortURL, error) {
+	repo := s.repo
+	if cached, ok := repo.(interface{ Uncached() URLShortenerRepository }); ok {
+		repo = cached.Uncached()
+	}
+	return repo.FindShortURL(ctx, code)
+}
+
+func (s *urlShortener) Purge(ctx context.Context, code string) error {
+	return s.repo.DeleteShortURL(ctx, code)
+}
+
+func (s *urlShortener) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	codes, err := s.repo.PurgeShortURLs(ctx, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(codes)), nil
+}
+
+func (s *urlShortener) IncreaseHitCount(ctx context.Context, code string) error {
+	return s.repo.IncreaseShortURLHitCount(ctx, code, 1)
 }
 
-func (s *urlShortener) GetFullURL(code string) (string, error) {
-	shortURL, err := s.repo.FindShortURL(code)
+func (s *urlShortener) GetFullURL(ctx context.Context, code string) (string, error) {
+	shortURL, err := s.repo.FindShortURL(ctx, code)
 	if err != nil {
-		return "", ErrRecordNotFound
+		return "", notFound(err)
 	}
 	// check if short url is expired
 	if shortURL.ExpiresAt != nil && shortURL.ExpiresAt.Before(time.Now().UTC()) {
@@ -148,16 +390,109 @@ func (s *urlShortener) GetFullURL(code string) (string, error) {
 	if shortURL.DeletedAt != nil {
 		return "", ErrShortURLExpired
 	}
-	if err := s.repo.IncreaseShortURLHitCount(shortURL.Code, 1); err != nil {
+	if err := s.repo.IncreaseShortURLHitCount(ctx, shortURL.Code, 1); err != nil {
 		return "", err
 	}
 	return shortURL.FullURL, nil
 }
 
-func getRandomShortCode(size int) (string, error) {
-	buf := make([]byte, size)
-	if _, err := rand.Read(buf); err != nil {
-		return "", err
+// notFound map a failed lookup to ErrRecordNotFound while keeping
+// cancellation and deadline errors visible to the caller
+func notFound(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
 	}
-	return base64.RawURLEncoding.EncodeToString(buf), nil
+	return ErrRecordNotFound
+}
+
+// parseDomain validate rawURL and return its domain
+func parseDomain(rawURL string) (string, error) {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil || u.Host == "" {
+		return "", ErrInvalidURL
+	}
+	domain := u.Host
+	if u.Port() != "" {
+		domain += ":" + u.Port()
+	}
+	return domain, nil
+}
+
+// parseSort parse sort field with optional - prefix for descending order
+func parseSort(val string) (SortParams, error) {
+	sort := SortParams{Field: SortCreatedAt}
+	if val == "" {
+		return sort, nil
+	}
+	if strings.HasPrefix(val, "-") {
+		sort.Desc = true
+		val = val[1:]
+	}
+	switch val {
+	case SortCreatedAt, SortHitCount, SortExpiresAt:
+		sort.Field = val
+		return sort, nil
+	default:
+		return sort, ErrInvalidSort
+	}
+}
+
+func validateFilter(f *FilterParams) error {
+	if f == nil {
+		return nil
+	}
+	if f.CreatedFrom != nil && f.CreatedTo != nil && f.CreatedFrom.After(*f.CreatedTo) {
+		return ErrInvalidTimeRange
+	}
+	switch f.Expiry {
+	case "", ExpiryActive, ExpiryExpired, ExpiryNever:
+	default:
+		return ErrInvalidExpiry
+	}
+	if (f.MinHitCount != nil && *f.MinHitCount < 0) || (f.MaxHitCount != nil && *f.MaxHitCount < 0) {
+		return ErrInvalidHitCount
+	}
+	if f.MinHitCount != nil && f.MaxHitCount != nil && *f.MinHitCount > *f.MaxHitCount {
+		return ErrInvalidHitCount
+	}
+	return nil
+}
+
+// encodeCursor return opaque position of shortURL in (created_at, id) order
+func encodeCursor(shortURL *ShortURL) string {
+	raw := strconv.FormatInt(shortURL.CreatedAt.UnixNano(), 10) + ":" + strconv.FormatInt(shortURL.Id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(val string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	nsec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{CreatedAt: time.Unix(0, nsec).UTC(), Id: id}, nil
+}
+
+func validateAlias(alias string) error {
+	if len(alias) < MIN_ALIAS_LENGTH || len(alias) > MAX_ALIAS_LENGTH {
+		return ErrInvalidAlias
+	}
+	if !rxAlias.MatchString(alias) {
+		return ErrInvalidAlias
+	}
+	if reservedAliases[strings.ToLower(alias)] {
+		return ErrReservedAlias
+	}
+	return nil
 }
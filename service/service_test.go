@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -11,12 +12,12 @@ type mockRepo struct {
 	mock.Mock
 }
 
-func (m *mockRepo) CreateShortURL(shortURL *ShortURL) error {
+func (m *mockRepo) CreateShortURL(ctx context.Context, shortURL *ShortURL) error {
 	args := m.Called(shortURL)
 	return args.Error(0)
 }
 
-func (m *mockRepo) FindShortURL(code string) (*ShortURL, error) {
+func (m *mockRepo) FindShortURL(ctx context.Context, code string) (*ShortURL, error) {
 	args := m.Called(code)
 	if args.Get(0) != nil {
 		return args.Get(0).(*ShortURL), args.Error(1)
@@ -24,23 +25,41 @@ func (m *mockRepo) FindShortURL(code string) (*ShortURL, error) {
 	return nil, args.Error(1)
 }
 
-func (m *mockRepo) UpdateShortURL(shortURL *ShortURL) error {
+func (m *mockRepo) UpdateShortURL(ctx context.Context, shortURL *ShortURL) error {
 	args := m.Called(shortURL)
 	return args.Error(0)
 }
 
-func (m *mockRepo) IncreaseShortURLHitCount(code string, count int) error {
-	args := m.Called(code, count)
+func (m *mockRepo) DeleteShortURL(ctx context.Context, code string) error {
+	args := m.Called(code)
 	return args.Error(0)
 }
 
-func (m *mockRepo) ListShortURLs(offset, size int64, filters ...*FilterParams) ([]*ShortURL, int64, error) {
-	arguments := []interface{}{offset, size}
-	for _, f := range filters {
-		arguments = append(arguments, f)
+func (m *mockRepo) PurgeShortURLs(ctx context.Context, deletedBefore time.Time) ([]string, error) {
+	args := m.Called(deletedBefore)
+	if args.Get(0) != nil {
+		return args.Get(0).([]string), args.Error(1)
 	}
+	return nil, args.Error(1)
+}
+
+func (m *mockRepo) IncreaseShortURLHitCount(ctx context.Context, code string, count int) error {
+	args := m.Called(code, count)
+	return args.Error(0)
+}
+
+func (m *mockRepo) SetShortURLBlocked(ctx context.Context, code string, blockedAt *time.Time, blockedBy string) error {
+	args := m.Called(code, blockedAt, blockedBy)
+	return args.Error(0)
+}
 
-	args := m.Called(arguments...)
+func (m *mockRepo) DisableShortURL(ctx context.Context, code string, deletedAt time.Time) (bool, error) {
+	args := m.Called(code, deletedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockRepo) ListShortURLs(ctx context.Context, params *ListParams) ([]*ShortURL, int64, error) {
+	args := m.Called(params)
 	return args.Get(0).([]*ShortURL), int64(args.Int(1)), args.Error(2)
 }
 
@@ -66,7 +85,7 @@ func TestServiceCreateShortURL(t *testing.T) {
 				Return(tc.want)
 		}
 
-		_, err := svc.Create(tc.input)
+		_, err := svc.Create(context.Background(), tc.input)
 		if err != tc.want {
 			t.Errorf("expected: %v, got: %v", tc.want, err)
 		}
@@ -75,6 +94,86 @@ func TestServiceCreateShortURL(t *testing.T) {
 	}
 }
 
+func TestServiceCreateShortURLWithAlias(t *testing.T) {
+	type test struct {
+		input ShortURLInput
+		code  string
+		want  error
+	}
+
+	tests := []test{
+		{input: ShortURLInput{URL: "http://example.com", Alias: "spring-sale"}, code: "spring-sale", want: nil},
+		{input: ShortURLInput{URL: "http://example.com", Alias: "ab"}, want: ErrInvalidAlias},
+		{input: ShortURLInput{URL: "http://example.com", Alias: "spring sale"}, want: ErrInvalidAlias},
+		{input: ShortURLInput{URL: "http://example.com", Alias: "Admin"}, want: ErrReservedAlias},
+		{input: ShortURLInput{URL: "http://example.com", Alias: "shorten"}, want: ErrReservedAlias},
+		{input: ShortURLInput{URL: "http://example.com", Alias: "taken"}, want: ErrAliasTaken},
+	}
+
+	repo := new(mockRepo)
+	repo.On("CreateShortURL", mock.MatchedBy(func(s *ShortURL) bool {
+		return s.Code == "spring-sale"
+	})).Return(nil)
+	repo.On("CreateShortURL", mock.MatchedBy(func(s *ShortURL) bool {
+		return s.Code == "taken"
+	})).Return(ErrConstraintUnique)
+
+	svc := NewURLShortener(repo)
+	for _, tc := range tests {
+		code, err := svc.Create(context.Background(), tc.input)
+		if err != tc.want {
+			t.Errorf("expected: %v, got: %v", tc.want, err)
+		}
+		if code != tc.code {
+			t.Errorf("expected code: %v, got: %v", tc.code, code)
+		}
+	}
+
+	repo.AssertExpectations(t)
+}
+
+type stubGenerator struct {
+	codes []string
+}
+
+func (g *stubGenerator) Generate(ctx context.Context) (string, error) {
+	code := g.codes[0]
+	g.codes = g.codes[1:]
+	return code, nil
+}
+
+func TestServiceCreateShortURLRetryOnCollision(t *testing.T) {
+	repo := new(mockRepo)
+	repo.On("CreateShortURL", mock.MatchedBy(func(s *ShortURL) bool {
+		return s.Code == "aaa" || s.Code == "bbb"
+	})).Return(ErrConstraintUnique)
+	repo.On("CreateShortURL", mock.MatchedBy(func(s *ShortURL) bool {
+		return s.Code == "ccc"
+	})).Return(nil)
+
+	svc := NewURLShortener(repo, ServiceOption{
+		CodeGenerator: &stubGenerator{codes: []string{"aaa", "bbb", "ccc"}},
+	})
+	code, err := svc.Create(context.Background(), ShortURLInput{URL: "http://example.com"})
+	if err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	if code != "ccc" {
+		t.Errorf("expected code: %v, got: %v", "ccc", code)
+	}
+
+	svc = NewURLShortener(repo, ServiceOption{
+		CodeGenerator:     &stubGenerator{codes: []string{"aaa", "bbb", "ccc"}},
+		MaxCreateAttempts: 2,
+	})
+	_, err = svc.Create(context.Background(), ShortURLInput{URL: "http://example.com"})
+	if err != ErrConstraintUnique {
+		t.Errorf("expected: %v, got: %v", ErrConstraintUnique, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
 func TestServiceDeleteShortURL(t *testing.T) {
 	type test struct {
 		input string
@@ -105,7 +204,7 @@ func TestServiceDeleteShortURL(t *testing.T) {
 				Return(nil)
 		}
 
-		err := svc.Delete(tc.input)
+		err := svc.Delete(context.Background(), tc.input)
 		if err != tc.want {
 			t.Errorf("expected: %v, got: %v", tc.want, err)
 		}
@@ -117,6 +216,131 @@ func TestServiceDeleteShortURL(t *testing.T) {
 	}
 }
 
+func TestServiceUpdateShortURL(t *testing.T) {
+	repo := new(mockRepo)
+	svc := NewURLShortener(repo)
+
+	validURL := "http://example1.com/path"
+	invalidURL := "example1.com"
+	pastTime := time.Now().Add(-1 * time.Minute)
+	futureTime := time.Now().Add(1 * time.Hour)
+
+	type test struct {
+		input UpdateShortURLInput
+		want  error
+		check func(s *ShortURL) bool
+	}
+
+	tests := []test{
+		{
+			input: UpdateShortURLInput{URL: &validURL},
+			check: func(s *ShortURL) bool {
+				return s.FullURL == validURL && s.Domain == "example1.com" && s.ExpiresAt != nil
+			},
+		},
+		{
+			input: UpdateShortURLInput{ExpiresAt: &futureTime},
+			check: func(s *ShortURL) bool {
+				return s.ExpiresAt.Equal(futureTime)
+			},
+		},
+		{
+			input: UpdateShortURLInput{ClearExpiresAt: true, ExpiresAt: &futureTime},
+			check: func(s *ShortURL) bool {
+				return s.ExpiresAt == nil
+			},
+		},
+		{input: UpdateShortURLInput{URL: &invalidURL}, want: ErrInvalidURL},
+		{input: UpdateShortURLInput{ExpiresAt: &pastTime}, want: ErrInvalidExpiresAt},
+	}
+
+	repo.On("UpdateShortURL", mock.Anything).Return(nil)
+	for _, tc := range tests {
+		expiresAt := time.Now().Add(time.Minute)
+		repo.On("FindShortURL", "123").Return(&ShortURL{
+			Id:        1,
+			Code:      "123",
+			FullURL:   "http://example.com",
+			Domain:    "example.com",
+			ExpiresAt: &expiresAt,
+		}, nil).Once()
+
+		s, err := svc.Update(context.Background(), "123", tc.input)
+		if err != tc.want {
+			t.Errorf("expected: %v, got: %v", tc.want, err)
+		}
+		if tc.check != nil && !tc.check(s) {
+			t.Errorf("unexpected short url after update: %+v", s)
+		}
+	}
+
+	repo.On("FindShortURL", "456").Return(nil, ErrRecordNotFound)
+	if _, err := svc.Update(context.Background(), "456", UpdateShortURLInput{}); err != ErrRecordNotFound {
+		t.Errorf("expected: %v, got: %v", ErrRecordNotFound, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestServiceRestoreShortURL(t *testing.T) {
+	repo := new(mockRepo)
+	svc := NewURLShortener(repo)
+
+	deletedAt := time.Now().UTC()
+	s1 := &ShortURL{Id: 1, Code: "123", DeletedAt: &deletedAt}
+	s2 := &ShortURL{Id: 2, Code: "456"}
+	repo.On("FindShortURL", "123").Return(s1, nil)
+	repo.On("FindShortURL", "456").Return(s2, nil)
+	repo.On("FindShortURL", "789").Return(nil, ErrRecordNotFound)
+	repo.On("UpdateShortURL", s1).Return(nil).Once()
+
+	type test struct {
+		input string
+		want  error
+	}
+
+	tests := []test{
+		{input: "123", want: nil},
+		{input: "456", want: nil},
+		{input: "789", want: ErrRecordNotFound},
+	}
+	for _, tc := range tests {
+		err := svc.Restore(context.Background(), tc.input)
+		if err != tc.want {
+			t.Errorf("expected: %v, got: %v", tc.want, err)
+		}
+	}
+	if s1.DeletedAt != nil {
+		t.Error("expected to restore short url")
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestServicePurgeShortURL(t *testing.T) {
+	repo := new(mockRepo)
+	svc := NewURLShortener(repo)
+
+	repo.On("DeleteShortURL", "123").Return(nil)
+	repo.On("DeleteShortURL", "456").Return(ErrRecordNotFound)
+
+	if err := svc.Purge(context.Background(), "123"); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	if err := svc.Purge(context.Background(), "456"); err != ErrRecordNotFound {
+		t.Errorf("expected: %v, got: %v", ErrRecordNotFound, err)
+	}
+
+	deletedBefore := time.Now().AddDate(0, 0, -30)
+	repo.On("PurgeShortURLs", deletedBefore).Return([]string{"123", "456"}, nil)
+	count, err := svc.PurgeDeleted(context.Background(), deletedBefore)
+	if err != nil || count != 2 {
+		t.Errorf("expected to purge 2 short urls, got: %d, %v", count, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
 func TestServiceIncreaseShortURLHitCount(t *testing.T) {
 	type test struct {
 		input string
@@ -133,7 +357,7 @@ func TestServiceIncreaseShortURLHitCount(t *testing.T) {
 
 	for _, tc := range tests {
 		repo.On("IncreaseShortURLHitCount", tc.input, 1).Return(tc.want)
-		err := svc.IncreaseHitCount(tc.input)
+		err := svc.IncreaseHitCount(context.Background(), tc.input)
 		if err != tc.want {
 			t.Errorf("expected: %v, got: %v", tc.want, err)
 		}
@@ -172,7 +396,7 @@ func TestServiceGetFullURL(t *testing.T) {
 	svc := NewURLShortener(repo)
 
 	for _, tc := range tests {
-		fullURL, err := svc.GetFullURL(tc.input)
+		fullURL, err := svc.GetFullURL(context.Background(), tc.input)
 		if fullURL != tc.fullURL {
 			t.Errorf("expected: %v, got: %v", "http://example.com", fullURL)
 		}
@@ -188,17 +412,17 @@ func TestServiceFindShortURLs(t *testing.T) {
 	repo := new(mockRepo)
 	svc := NewURLShortener(repo)
 
-	var filter *FilterParams
 	shortURLs := []*ShortURL{
 		{FullURL: "http://example.com", Code: "123"},
 		{FullURL: "http://example1.com", Code: "456"},
 	}
-	repo.On("ListShortURLs", int64(0), int64(30), filter).
+	repo.On("ListShortURLs", &ListParams{Offset: 0, Size: 31, WithCount: true, Sort: SortParams{Field: SortCreatedAt}}).
 		Return(shortURLs, 2, nil)
 
-	r, err := svc.FindURLs(&FindParams{
-		Offset: 0,
-		Size:   30,
+	r, err := svc.FindURLs(context.Background(), &FindParams{
+		Offset:    0,
+		Size:      30,
+		WithCount: true,
 	})
 	if err != nil {
 		t.Errorf("expected: %v, got: %v", nil, err)
@@ -206,9 +430,136 @@ func TestServiceFindShortURLs(t *testing.T) {
 	if len(r.Data) != 2 {
 		t.Error("expected to get a list of short urls")
 	}
-	if r.TotalCount != 2 {
+	if r.TotalCount == nil || *r.TotalCount != 2 {
 		t.Error("expected to get a total count of short url")
 	}
+	if r.NextCursor != "" {
+		t.Errorf("expected no next cursor on last page, got: %v", r.NextCursor)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestServiceFindShortURLsCursor(t *testing.T) {
+	repo := new(mockRepo)
+	svc := NewURLShortener(repo)
+
+	createdAt := time.Date(2022, 1, 2, 3, 4, 5, 6, time.UTC)
+	shortURLs := []*ShortURL{
+		{Id: 1, FullURL: "http://example.com", Code: "123", CreatedAt: createdAt},
+		{Id: 2, FullURL: "http://example1.com", Code: "456", CreatedAt: createdAt},
+		{Id: 3, FullURL: "http://example2.com", Code: "789", CreatedAt: createdAt},
+	}
+	repo.On("ListShortURLs", &ListParams{Size: 3, Sort: SortParams{Field: SortCreatedAt}}).Return(shortURLs, 0, nil)
+
+	r, err := svc.FindURLs(context.Background(), &FindParams{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Data) != 2 {
+		t.Errorf("expected: %v, got: %v", 2, len(r.Data))
+	}
+	if r.TotalCount != nil {
+		t.Errorf("expected no total count, got: %v", *r.TotalCount)
+	}
+	if r.NextCursor == "" {
+		t.Fatal("expected next cursor")
+	}
+
+	// next page start after the last returned record
+	after := &Cursor{CreatedAt: createdAt, Id: 2}
+	repo.On("ListShortURLs", &ListParams{Size: 3, After: after, Sort: SortParams{Field: SortCreatedAt}}).Return(shortURLs[2:], 0, nil)
+	r, err = svc.FindURLs(context.Background(), &FindParams{Size: 2, Cursor: r.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Data) != 1 || r.NextCursor != "" {
+		t.Errorf("unexpected last page: %+v", r)
+	}
+
+	_, err = svc.FindURLs(context.Background(), &FindParams{Size: 2, Cursor: "not a cursor"})
+	if err != ErrInvalidCursor {
+		t.Errorf("expected: %v, got: %v", ErrInvalidCursor, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestServiceFindShortURLsSort(t *testing.T) {
+	repo := new(mockRepo)
+	svc := NewURLShortener(repo)
+
+	repo.On("ListShortURLs", &ListParams{Size: 3, Sort: SortParams{Field: SortHitCount, Desc: true}}).
+		Return([]*ShortURL{{Id: 1}, {Id: 2}, {Id: 3}}, 0, nil)
+
+	// only created at sort can continue with a cursor
+	r, err := svc.FindURLs(context.Background(), &FindParams{Size: 2, Sort: "-hitCount"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Data) != 2 || r.NextCursor != "" {
+		t.Errorf("unexpected result: %+v", r)
+	}
+
+	_, err = svc.FindURLs(context.Background(), &FindParams{Size: 2, Sort: "-hitCount", Cursor: encodeCursor(r.Data[1])})
+	if err != ErrCursorSort {
+		t.Errorf("expected: %v, got: %v", ErrCursorSort, err)
+	}
+
+	repo.AssertExpectations(t)
+}
+
+func TestServiceFindShortURLsInvalidParams(t *testing.T) {
+	repo := new(mockRepo)
+	svc := NewURLShortener(repo)
+
+	now := time.Now()
+	before := now.Add(-time.Hour)
+	negative, small, large := int64(-1), int64(1), int64(10)
+
+	tests := []struct {
+		params *FindParams
+		want   error
+	}{
+		{params: &FindParams{Sort: "fullUrl"}, want: ErrInvalidSort},
+		{params: &FindParams{Sort: "--createdAt"}, want: ErrInvalidSort},
+		{params: &FindParams{Filter: &FilterParams{Expiry: "soon"}}, want: ErrInvalidExpiry},
+		{params: &FindParams{Filter: &FilterParams{CreatedFrom: &now, CreatedTo: &before}}, want: ErrInvalidTimeRange},
+		{params: &FindParams{Filter: &FilterParams{MinHitCount: &negative}}, want: ErrInvalidHitCount},
+		{params: &FindParams{Filter: &FilterParams{MinHitCount: &large, MaxHitCount: &small}}, want: ErrInvalidHitCount},
+	}
+	for _, tc := range tests {
+		if _, err := svc.FindURLs(context.Background(), tc.params); err != tc.want {
+			t.Errorf("expected: %v, got: %v", tc.want, err)
+		}
+	}
+
+	repo.AssertNotCalled(t, "ListShortURLs", mock.Anything)
+}
+
+func TestServiceKeepContextError(t *testing.T) {
+	repo := new(mockRepo)
+	svc := NewURLShortener(repo)
+
+	repo.On("FindShortURL", "123").Return(nil, context.DeadlineExceeded)
+	if _, err := svc.GetFullURL(context.Background(), "123"); err != context.DeadlineExceeded {
+		t.Errorf("expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if err := svc.Delete(context.Background(), "123"); err != context.DeadlineExceeded {
+		t.Errorf("expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+
+	// collision retry stop once context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	repo.On("CreateShortURL", mock.Anything).Return(ErrConstraintUnique).Once().Run(func(mock.Arguments) {
+		cancel()
+	})
+	svc = NewURLShortener(repo, ServiceOption{
+		CodeGenerator: &stubGenerator{codes: []string{"aaa", "bbb"}},
+	})
+	if _, err := svc.Create(ctx, ShortURLInput{URL: "http://example.com"}); err != context.Canceled {
+		t.Errorf("expected: %v, got: %v", context.Canceled, err)
+	}
 
 	repo.AssertExpectations(t)
 }
@@ -0,0 +1,13 @@
+//go:build cgo
+// +build cgo
+
+package service
+
+import "github.com/mattn/go-sqlite3"
+
+func transformSQLiteError(err error) error {
+	if e, ok := err.(sqlite3.Error); ok && e.Code == sqlite3.ErrConstraint {
+		return ErrConstraintUnique
+	}
+	return transformGormError(err)
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+// +build !cgo
+
+package service
+
+// transformSQLiteError without cgo the sqlite driver is a stub which
+// can't open a database, so there is no sqlite error to map
+func transformSQLiteError(err error) error {
+	return transformGormError(err)
+}
@@ -0,0 +1,37 @@
+package service
+
+import "context"
+
+// TypedCache is a type safe view of a CacheStore holding values of type T
+type TypedCache[T any] interface {
+	Save(ctx context.Context, key string, val T, opts ...CacheOption) error
+	// Get return ErrCacheKeyNotFound along with zero value on miss
+	Get(ctx context.Context, key string) (T, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewTypedCache factory function
+func NewTypedCache[T any](store CacheStore) TypedCache[T] {
+	return &typedCache[T]{store: store}
+}
+
+type typedCache[T any] struct {
+	store CacheStore
+}
+
+func (c *typedCache[T]) Save(ctx context.Context, key string, val T, opts ...CacheOption) error {
+	return c.store.Save(ctx, key, val, opts...)
+}
+
+func (c *typedCache[T]) Get(ctx context.Context, key string) (T, error) {
+	var val T
+	if err := c.store.Get(ctx, key, &val); err != nil {
+		var zero T
+		return zero, err
+	}
+	return val, nil
+}
+
+func (c *typedCache[T]) Delete(ctx context.Context, key string) error {
+	return c.store.Delete(ctx, key)
+}
@@ -1,12 +1,17 @@
 package transport
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/PrinceNorin/rburlshortener/service"
 	"github.com/gorilla/mux"
@@ -20,17 +25,43 @@ var (
 // Config server configuration
 type HTTPConfig struct {
 	Service    service.URLShortener
+	Analytics  service.Analytics        // optional, click tracking is disabled when nil
+	Cache      service.CachedRepository // optional, cache stats are not exposed when nil
+	Blacklist  service.Blacklist        // optional, blacklist api is disabled when nil
 	ServerHost string
 	AdminToken string
+	// AdminTokens optional, additional admin tokens mapped to admin names
+	AdminTokens map[string]string
+	// BlacklistScanner optional, blacklist scan api is disabled when nil
+	BlacklistScanner service.BlacklistScanner
+	// BlacklistFeeds optional, feed stats are not exposed when nil
+	BlacklistFeeds service.BlacklistFeeds
+	// RequestTimeout cancel request context after the duration, 0 means no timeout
+	RequestTimeout time.Duration
+	// TrustedProxies whose X-Forwarded-For header is used to find client ip of clicks
+	TrustedProxies []*net.IPNet
 }
 
 // NewHTTPHandler factory function
 func NewHTTPHandler(conf HTTPConfig) http.Handler {
 	r := mux.NewRouter()
-	h := handler{svc: conf.Service, serverHost: conf.ServerHost}
+	h := handler{
+		svc:        conf.Service,
+		analytics:  conf.Analytics,
+		cache:      conf.Cache,
+		blacklist:  conf.Blacklist,
+		scanner:    conf.BlacklistScanner,
+		feeds:      conf.BlacklistFeeds,
+		serverHost: conf.ServerHost,
+
+		trustedProxies: conf.TrustedProxies,
+	}
 
 	r.Use(loggingMiddleware(log.New(os.Stdout, "", 0)))
 	r.Use(recoverer)
+	if conf.RequestTimeout > 0 {
+		r.Use(timeoutMiddleware(conf.RequestTimeout))
+	}
 	r.HandleFunc("/shorten", h.createShortURL).
 		Methods("POST")
 	r.HandleFunc("/{code}", h.getFullURL).
@@ -38,9 +69,31 @@ func NewHTTPHandler(conf HTTPConfig) http.Handler {
 
 	// Admin endpoints
 	admin := r.PathPrefix("/admin").Subrouter()
-	admin.Use(httpAdminAuthMiddleware(conf.AdminToken))
+	admin.Use(httpAdminAuthMiddleware(adminTokens(conf)))
 	admin.HandleFunc("/shortUrls", h.adminListShortURLs).Methods("GET")
+	admin.HandleFunc("/shortUrls", h.adminPurgeShortURLs).Methods("DELETE")
+	admin.HandleFunc("/shortUrls/{code}", h.adminUpdateShortURL).Methods("PATCH")
 	admin.HandleFunc("/shortUrls/{code}", h.adminDeleteShortURL).Methods("DELETE")
+	admin.HandleFunc("/shortUrls/{code}/restore", h.adminRestoreShortURL).Methods("POST")
+	if h.analytics != nil {
+		admin.HandleFunc("/shortUrls/{code}/clicks", h.adminListClicks).Methods("GET")
+		admin.HandleFunc("/shortUrls/{code}/stats", h.adminClickStats).Methods("GET")
+	}
+	if h.cache != nil {
+		admin.HandleFunc("/cache/stats", h.adminCacheStats).Methods("GET")
+	}
+	if h.feeds != nil {
+		admin.HandleFunc("/blacklist/feeds", h.adminBlacklistFeedStats).Methods("GET")
+	}
+	if h.scanner != nil {
+		admin.HandleFunc("/blacklist/scan", h.adminScanBlacklist).Methods("POST")
+		admin.HandleFunc("/blacklist/scan", h.adminLastBlacklistScan).Methods("GET")
+	}
+	if h.blacklist != nil {
+		admin.HandleFunc("/blacklist", h.adminListBlacklist).Methods("GET")
+		admin.HandleFunc("/blacklist", h.adminCreateBlacklistRule).Methods("POST")
+		admin.HandleFunc("/blacklist/{id}", h.adminDeleteBlacklistRule).Methods("DELETE")
+	}
 
 	return r
 }
@@ -54,20 +107,41 @@ type httpError interface {
 type createRequest struct {
 	URL       string `json:"url"`
 	ExpiresIn int64  `json:"expiresIn"`
+	Alias     string `json:"alias"`
+}
+
+type blacklistRuleRequest struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	Reason  string `json:"reason"`
+}
+
+// expiresAt is kept raw to tell an explicit null (clear expiry) from absence
+type updateRequest struct {
+	URL       *string         `json:"url"`
+	ExpiresAt json.RawMessage `json:"expiresAt"`
 }
 
 type handler struct {
 	serverHost string
 	svc        service.URLShortener
+	analytics  service.Analytics
+	cache      service.CachedRepository
+	blacklist  service.Blacklist
+	scanner    service.BlacklistScanner
+	feeds      service.BlacklistFeeds
+
+	trustedProxies []*net.IPNet
 }
 
 func (h handler) createShortURL(w http.ResponseWriter, r *http.Request) {
 	var req createRequest
 	json.NewDecoder(r.Body).Decode(&req)
 
-	code, err := h.svc.Create(service.ShortURLInput{
+	code, err := h.svc.Create(r.Context(), service.ShortURLInput{
 		URL:       req.URL,
 		ExpiresIn: req.ExpiresIn,
+		Alias:     req.Alias,
 	})
 	if err != nil {
 		handleError(err, w, r)
@@ -82,17 +156,132 @@ func (h handler) getFullURL(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	code := vars["code"]
 
-	fullURL, err := h.svc.GetFullURL(code)
+	fullURL, err := h.svc.GetFullURL(r.Context(), code)
 	if err != nil {
 		handleError(err, w, r)
 		return
 	}
 
+	if h.analytics != nil {
+		// failing to record a click should never break the redirect
+		if err := h.analytics.RecordClick(r.Context(), code, getClickInput(r, h.trustedProxies)); err != nil {
+			log.Printf("[Error]: record click: %v", err)
+		}
+	}
 	http.Redirect(w, r, fullURL, http.StatusFound)
 }
 
 func (h handler) adminListShortURLs(w http.ResponseWriter, r *http.Request) {
-	result, err := h.svc.FindURLs(getFindParams(r))
+	params, err := getFindParams(r)
+	if err != nil {
+		writeErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.svc.FindURLs(r.Context(), params)
+	if err != nil {
+		handleError(err, w, r)
+		return
+	}
+
+	data := map[string]interface{}{
+		"data": result.Data,
+	}
+	if result.TotalCount != nil {
+		data["totalCount"] = *result.TotalCount
+	}
+	if result.NextCursor != "" {
+		data["nextCursor"] = result.NextCursor
+	}
+	writeJSON(w, data, http.StatusOK)
+}
+
+func (h handler) adminUpdateShortURL(w http.ResponseWriter, r *http.Request) {
+	var req updateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorJSON(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	input := service.UpdateShortURLInput{URL: req.URL}
+	if len(req.ExpiresAt) > 0 {
+		if string(req.ExpiresAt) == "null" {
+			input.ClearExpiresAt = true
+		} else {
+			var expiresAt time.Time
+			if err := json.Unmarshal(req.ExpiresAt, &expiresAt); err != nil {
+				handleError(service.ErrInvalidExpiresAt, w, r)
+				return
+			}
+			input.ExpiresAt = &expiresAt
+		}
+	}
+
+	vars := mux.Vars(r)
+	shortURL, err := h.svc.Update(r.Context(), vars["code"], input)
+	if err != nil {
+		handleError(err, w, r)
+		return
+	}
+	writeJSON(w, shortURL, http.StatusOK)
+}
+
+func (h handler) adminDeleteShortURL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deleteFunc := h.svc.Delete
+	if r.URL.Query().Get("hard") == "true" {
+		deleteFunc = h.svc.Purge
+	}
+
+	if err := deleteFunc(r.Context(), vars["code"]); err != nil {
+		handleError(err, w, r)
+		return
+	}
+	w.Header().Add("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h handler) adminRestoreShortURL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.svc.Restore(r.Context(), vars["code"]); err != nil {
+		handleError(err, w, r)
+		return
+	}
+	w.Header().Add("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminPurgeShortURLs permanently delete short urls soft deleted more than olderThanDays ago
+func (h handler) adminPurgeShortURLs(w http.ResponseWriter, r *http.Request) {
+	days, err := strconv.Atoi(r.URL.Query().Get("olderThanDays"))
+	if err != nil || days < 0 {
+		writeErrorJSON(w, "invalid olderThanDays", http.StatusBadRequest)
+		return
+	}
+
+	deletedBefore := time.Now().UTC().AddDate(0, 0, -days)
+	count, err := h.svc.PurgeDeleted(r.Context(), deletedBefore)
+	if err != nil {
+		handleError(err, w, r)
+		return
+	}
+	writeJSON(w, map[string]int64{"purgedCount": count}, http.StatusOK)
+}
+
+func (h handler) adminListClicks(w http.ResponseWriter, r *http.Request) {
+	filter, err := getClickFilterParams(r)
+	if err != nil {
+		writeErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	offset, size := getPaginationParams(r)
+	result, err := h.analytics.FindClicks(r.Context(), vars["code"], &service.FindClicksParams{
+		Offset: offset,
+		Size:   size,
+		Filter: filter,
+	})
 	if err != nil {
 		handleError(err, w, r)
 		return
@@ -105,9 +294,71 @@ func (h handler) adminListShortURLs(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, data, http.StatusOK)
 }
 
-func (h handler) adminDeleteShortURL(w http.ResponseWriter, r *http.Request) {
+func (h handler) adminClickStats(w http.ResponseWriter, r *http.Request) {
+	filter, err := getClickFilterParams(r)
+	if err != nil {
+		writeErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	stats, err := h.analytics.Stats(r.Context(), vars["code"], service.StatsParams{
+		Interval: r.URL.Query().Get("interval"),
+		Filter:   filter,
+	})
+	if err != nil {
+		handleError(err, w, r)
+		return
+	}
+	writeJSON(w, stats, http.StatusOK)
+}
+
+func (h handler) adminCacheStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.cache.Stats(), http.StatusOK)
+}
+
+func (h handler) adminListBlacklist(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.blacklist.Rules(r.Context())
+	if err != nil {
+		handleError(err, w, r)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"data": rules}, http.StatusOK)
+}
+
+func (h handler) adminCreateBlacklistRule(w http.ResponseWriter, r *http.Request) {
+	var req blacklistRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorJSON(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.blacklist.AddRule(r.Context(), service.BlacklistRuleInput{
+		Type:      req.Type,
+		Pattern:   req.Pattern,
+		Reason:    req.Reason,
+		CreatedBy: adminName(r),
+	})
+	if err != nil {
+		handleError(err, w, r)
+		return
+	}
+	// flag existing short urls matching the new rule
+	if h.scanner != nil {
+		h.scanner.Trigger()
+	}
+	writeJSON(w, rule, http.StatusCreated)
+}
+
+func (h handler) adminDeleteBlacklistRule(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	if err := h.svc.Delete(vars["code"]); err != nil {
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		writeErrorJSON(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.blacklist.DeleteRule(r.Context(), id); err != nil {
 		handleError(err, w, r)
 		return
 	}
@@ -115,22 +366,163 @@ func (h handler) adminDeleteShortURL(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (h handler) adminBlacklistFeedStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.feeds.Stats(), http.StatusOK)
+}
+
+// adminScanBlacklist wait for a scan of every short url, the scan keeps
+// running in background when request times out
+func (h handler) adminScanBlacklist(w http.ResponseWriter, r *http.Request) {
+	report, err := h.scanner.Scan(r.Context())
+	if err != nil {
+		handleError(err, w, r)
+		return
+	}
+	writeJSON(w, report, http.StatusOK)
+}
+
+func (h handler) adminLastBlacklistScan(w http.ResponseWriter, r *http.Request) {
+	report := h.scanner.LastReport()
+	if report == nil {
+		writeErrorJSON(w, "no scan completed yet", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, report, http.StatusOK)
+}
+
 func writeJSON(w http.ResponseWriter, resp interface{}, status int) {
 	w.Header().Add("Content-Type", jsonContentType)
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(resp)
 }
 
-func getFindParams(r *http.Request) *service.FindParams {
+func writeErrorJSON(w http.ResponseWriter, msg string, status int) {
+	writeJSON(w, map[string]interface{}{"error": []string{msg}}, status)
+}
+
+// getFindParams parse pagination, sort and filter query params
+func getFindParams(r *http.Request) (*service.FindParams, error) {
+	q := r.URL.Query()
 	offset, size := getPaginationParams(r)
+	cursor := q.Get("cursor")
+	// offset pagination keep total count by default for compatibility
+	withCount := cursor == ""
+	if v, err := strconv.ParseBool(q.Get("withCount")); err == nil {
+		withCount = v
+	}
+
+	filter := &service.FilterParams{
+		Code:    q.Get("shortCode"),
+		Keyword: q.Get("keyword"),
+		URL:     q.Get("url"),
+		Expiry:  q.Get("expiry"),
+	}
+	for key, dst := range map[string]**time.Time{"createdFrom": &filter.CreatedFrom, "createdTo": &filter.CreatedTo} {
+		if val := q.Get(key); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s", key)
+			}
+			*dst = &t
+		}
+	}
+	for key, dst := range map[string]**int64{"minHitCount": &filter.MinHitCount, "maxHitCount": &filter.MaxHitCount} {
+		if val := q.Get(key); val != "" {
+			v, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s", key)
+			}
+			*dst = &v
+		}
+	}
+	if val := q.Get("deleted"); val != "" {
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deleted")
+		}
+		filter.Deleted = &v
+	}
+	if val := q.Get("blocked"); val != "" {
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked")
+		}
+		filter.Blocked = &v
+	}
+
 	return &service.FindParams{
-		Size:   size,
-		Offset: offset,
-		Filter: &service.FilterParams{
-			Code:    r.URL.Query().Get("shortCode"),
-			Keyword: r.URL.Query().Get("keyword"),
-		},
+		Size:      size,
+		Offset:    offset,
+		Cursor:    cursor,
+		WithCount: withCount,
+		Sort:      q.Get("sort"),
+		Filter:    filter,
+	}, nil
+}
+
+// getClickFilterParams parse optional RFC 3339 from and to query params
+func getClickFilterParams(r *http.Request) (*service.ClickFilterParams, error) {
+	filter := &service.ClickFilterParams{}
+	for key, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		val := r.URL.Query().Get(key)
+		if val == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s", key)
+		}
+		*dst = &t
+	}
+	return filter, nil
+}
+
+func getClickInput(r *http.Request, trustedProxies []*net.IPNet) service.ClickInput {
+	return service.ClickInput{
+		Referrer:       r.Referer(),
+		UserAgent:      r.UserAgent(),
+		ClientIP:       getClientIP(r, trustedProxies),
+		AcceptLanguage: r.Header.Get("Accept-Language"),
+	}
+}
+
+// getClientIP return the peer address. When the peer is a trusted proxy,
+// X-Forwarded-For is walked from the nearest hop and the first address which
+// isn't a trusted proxy is returned, addresses before it may be forged
+func getClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip, trustedProxies) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrustedProxy(hop, trustedProxies) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(addr string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
 	}
+	return false
 }
 
 func getPaginationParams(r *http.Request) (int64, int64) {
@@ -164,6 +556,9 @@ func handleError(err error, w http.ResponseWriter, r *http.Request) {
 	if e, ok := err.(httpError); ok {
 		code = e.StatusCode()
 		resp = e.Response()
+	} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		code = http.StatusServiceUnavailable
+		resp = []string{"request timeout"}
 	} else {
 		code = http.StatusInternalServerError
 		resp = "internal server error"
@@ -1,9 +1,12 @@
 package transport
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -23,7 +26,51 @@ func (s *statusRecorder) WriteHeader(status int) {
 	s.ResponseWriter.WriteHeader(status)
 }
 
-func httpAdminAuthMiddleware(token string) mux.MiddlewareFunc {
+// name of the admin authenticated with AdminToken
+const defaultAdminName = "admin"
+
+type adminContextKey struct{}
+
+// ParseAdminTokens map admin tokens to admin names from comma-separated
+// `name:token` entries. Names can't contain `:` so tokens can, empty entries
+// are skipped and entries missing a name or token are rejected
+func ParseAdminTokens(conf string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(conf, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, ":")
+		if i <= 0 || i == len(entry)-1 {
+			return nil, fmt.Errorf("invalid admin token entry, expected name:token")
+		}
+		tokens[entry[i+1:]] = entry[:i]
+	}
+	return tokens, nil
+}
+
+// adminTokens merge AdminToken and AdminTokens of conf
+func adminTokens(conf HTTPConfig) map[string]string {
+	tokens := make(map[string]string, len(conf.AdminTokens)+1)
+	for token, name := range conf.AdminTokens {
+		tokens[token] = name
+	}
+	if conf.AdminToken != "" {
+		tokens[conf.AdminToken] = defaultAdminName
+	}
+	return tokens
+}
+
+// adminName returns name of the admin authenticated for request
+func adminName(r *http.Request) string {
+	name, _ := r.Context().Value(adminContextKey{}).(string)
+	return name
+}
+
+// httpAdminAuthMiddleware authenticate requests with one of tokens, mapping
+// token to admin name. Requests without token are always rejected
+func httpAdminAuthMiddleware(tokens map[string]string) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var reqToken string
@@ -42,13 +89,25 @@ func httpAdminAuthMiddleware(token string) mux.MiddlewareFunc {
 				reqToken = content
 			}
 
-			if reqToken != token {
+			name, ok := tokens[reqToken]
+			if reqToken == "" || !ok {
 				resp := map[string]string{"error": "403 Forbidden!"}
 				writeJSON(w, resp, http.StatusForbidden)
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), adminContextKey{}, name)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func timeoutMiddleware(timeout time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
@@ -1,10 +1,15 @@
 package transport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -17,34 +22,149 @@ type mockService struct {
 	mock.Mock
 }
 
-func (m *mockService) Create(input service.ShortURLInput) (string, error) {
+func (m *mockService) Create(ctx context.Context, input service.ShortURLInput) (string, error) {
 	args := m.Called(input)
 	return args.String(0), args.Error(1)
 }
 
-func (m *mockService) FindURLs(params *service.FindParams) (*service.Result, error) {
+func (m *mockService) Update(ctx context.Context, code string, input service.UpdateShortURLInput) (*service.ShortURL, error) {
+	args := m.Called(code, input)
+	if args.Get(0) != nil {
+		return args.Get(0).(*service.ShortURL), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockService) FindURLs(ctx context.Context, params *service.FindParams) (*service.Result, error) {
 	args := m.Called(params)
 	return args.Get(0).(*service.Result), args.Error(1)
 }
 
-func (m *mockService) Delete(code string) error {
+func (m *mockService) Delete(ctx context.Context, code string) error {
+	args := m.Called(code)
+	return args.Error(0)
+}
+
+func (m *mockService) Restore(ctx context.Context, code string) error {
 	args := m.Called(code)
 	return args.Error(0)
 }
 
-func (m *mockService) IncreaseHitCount(code string) error {
+func (m *mockService) Purge(ctx context.Context, code string) error {
+	args := m.Called(code)
+	return args.Error(0)
+}
+
+func (m *mockService) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	args := m.Called(deletedBefore)
+	return int64(args.Int(0)), args.Error(1)
+}
+
+func (m *mockService) IncreaseHitCount(ctx context.Context, code string) error {
 	return nil
 }
 
-func (m *mockService) GetFullURL(code string) (string, error) {
+func (m *mockService) GetFullURL(ctx context.Context, code string) (string, error) {
 	args := m.Called(code)
 	return args.String(0), args.Error(1)
 }
 
+type mockAnalytics struct {
+	mock.Mock
+}
+
+func (m *mockAnalytics) RecordClick(ctx context.Context, code string, input service.ClickInput) error {
+	args := m.Called(code, input)
+	return args.Error(0)
+}
+
+func (m *mockAnalytics) FindClicks(ctx context.Context, code string, params *service.FindClicksParams) (*service.ClickResult, error) {
+	args := m.Called(code, params)
+	if args.Get(0) != nil {
+		return args.Get(0).(*service.ClickResult), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockAnalytics) Stats(ctx context.Context, code string, params service.StatsParams) (*service.ClickStats, error) {
+	args := m.Called(code, params)
+	if args.Get(0) != nil {
+		return args.Get(0).(*service.ClickStats), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+type mockBlacklist struct {
+	mock.Mock
+}
+
+func (m *mockBlacklist) Match(url string) *service.BlacklistRule {
+	args := m.Called(url)
+	if args.Get(0) != nil {
+		return args.Get(0).(*service.BlacklistRule)
+	}
+	return nil
+}
+
+func (m *mockBlacklist) Rules(ctx context.Context) ([]*service.BlacklistRule, error) {
+	args := m.Called()
+	return args.Get(0).([]*service.BlacklistRule), args.Error(1)
+}
+
+func (m *mockBlacklist) AddRule(ctx context.Context, input service.BlacklistRuleInput) (*service.BlacklistRule, error) {
+	args := m.Called(input)
+	if args.Get(0) != nil {
+		return args.Get(0).(*service.BlacklistRule), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockBlacklist) DeleteRule(ctx context.Context, id int64) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *mockBlacklist) Reload(ctx context.Context) error {
+	return m.Called().Error(0)
+}
+
+func (m *mockBlacklist) Close() error {
+	return nil
+}
+
+type mockBlacklistScanner struct {
+	mock.Mock
+}
+
+func (m *mockBlacklistScanner) Scan(ctx context.Context) (*service.BlacklistScanReport, error) {
+	args := m.Called()
+	if args.Get(0) != nil {
+		return args.Get(0).(*service.BlacklistScanReport), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockBlacklistScanner) Trigger() {
+	m.Called()
+}
+
+func (m *mockBlacklistScanner) LastReport() *service.BlacklistScanReport {
+	args := m.Called()
+	if args.Get(0) != nil {
+		return args.Get(0).(*service.BlacklistScanReport)
+	}
+	return nil
+}
+
+func (m *mockBlacklistScanner) Close() error {
+	return nil
+}
+
 func TestCreateShortURLHandler(t *testing.T) {
 	type testRequest struct {
 		url       string
 		expiresIn int64
+		alias     string
 	}
 	type testResponse struct {
 		code   string
@@ -76,6 +196,24 @@ func TestCreateShortURLHandler(t *testing.T) {
 				body:   `{"url":"http://127.0.0.1/456"}`,
 			},
 		},
+		{
+			req: testRequest{url: "http://example.com", alias: "spring-sale"},
+			res: testResponse{
+				code:   "spring-sale",
+				status: 201,
+				err:    nil,
+				body:   `{"url":"http://127.0.0.1/spring-sale"}`,
+			},
+		},
+		{
+			req: testRequest{url: "http://example.com", alias: "taken"},
+			res: testResponse{
+				code:   "",
+				status: 409,
+				err:    service.ErrAliasTaken,
+				body:   `{"error":["alias taken"]}`,
+			},
+		},
 		{
 			req: testRequest{url: "example.com"},
 			res: testResponse{
@@ -103,7 +241,8 @@ func TestCreateShortURLHandler(t *testing.T) {
 	})
 
 	for _, tc := range tests {
-		body := fmt.Sprintf(`{"url": "%s", "expiresIn": %d}`, tc.req.url, tc.req.expiresIn)
+		body := fmt.Sprintf(`{"url": "%s", "expiresIn": %d, "alias": "%s"}`,
+			tc.req.url, tc.req.expiresIn, tc.req.alias)
 		req, err := http.NewRequest("POST", "/shorten", strings.NewReader(body))
 		if err != nil {
 			t.Fatal(err)
@@ -112,6 +251,7 @@ func TestCreateShortURLHandler(t *testing.T) {
 		mockSvc.On("Create", service.ShortURLInput{
 			URL:       tc.req.url,
 			ExpiresIn: tc.req.expiresIn,
+			Alias:     tc.req.alias,
 		}).Return(tc.res.code, tc.res.err)
 
 		rr := httptest.NewRecorder()
@@ -145,8 +285,10 @@ func TestGetFullURLHandler(t *testing.T) {
 		{input: "123", want: 302},
 		{input: "456", want: 410},
 		{input: "789", want: 404},
+		{input: "111", want: 503},
 	}
 
+	mockSvc.On("GetFullURL", "111").Return("", context.DeadlineExceeded)
 	mockSvc.On("GetFullURL", "123").Return("http://example.com", nil)
 	mockSvc.On("GetFullURL", "456").Return("", service.ErrShortURLExpired)
 	mockSvc.On("GetFullURL", "789").Return("", service.ErrRecordNotFound)
@@ -177,7 +319,8 @@ func TestAdminListShortURLsHandler(t *testing.T) {
 
 	type response struct {
 		Data       []*service.ShortURL `json:"data"`
-		TotalCount int64               `json:"totalCount"`
+		NextCursor string              `json:"nextCursor,omitempty"`
+		TotalCount int64               `json:"totalCount,omitempty"`
 	}
 	type test struct {
 		params map[string]string
@@ -227,6 +370,18 @@ func TestAdminListShortURLsHandler(t *testing.T) {
 			},
 			status: 200,
 		},
+		{
+			token: "1234",
+			params: map[string]string{
+				"size":   "1",
+				"cursor": "abc",
+			},
+			resp: &response{
+				Data:       []*service.ShortURL{shortURLs[1]},
+				NextCursor: "def",
+			},
+			status: 200,
+		},
 		{
 			token:  "invalid token",
 			status: 403,
@@ -234,23 +389,35 @@ func TestAdminListShortURLsHandler(t *testing.T) {
 		},
 	}
 
+	count := func(n int64) *int64 { return &n }
 	mockSvc.On("FindURLs", &service.FindParams{
-		Offset: 0,
-		Size:   10,
-		Filter: &service.FilterParams{},
+		Offset:    0,
+		Size:      10,
+		WithCount: true,
+		Filter:    &service.FilterParams{},
 	}).Return(&service.Result{
 		Data:       shortURLs,
-		TotalCount: 2,
+		TotalCount: count(2),
 	}, nil)
 	mockSvc.On("FindURLs", &service.FindParams{
-		Offset: 0,
-		Size:   10,
+		Offset:    0,
+		Size:      10,
+		WithCount: true,
 		Filter: &service.FilterParams{
 			Code: "123",
 		},
 	}).Return(&service.Result{
 		Data:       []*service.ShortURL{shortURLs[0]},
-		TotalCount: 1,
+		TotalCount: count(1),
+	}, nil)
+	// cursor pagination skip total count unless asked
+	mockSvc.On("FindURLs", &service.FindParams{
+		Size:   1,
+		Cursor: "abc",
+		Filter: &service.FilterParams{},
+	}).Return(&service.Result{
+		Data:       []*service.ShortURL{shortURLs[1]},
+		NextCursor: "def",
 	}, nil)
 
 	for _, tc := range tests {
@@ -287,6 +454,178 @@ func TestAdminListShortURLsHandler(t *testing.T) {
 	mockSvc.AssertExpectations(t)
 }
 
+func TestAdminListShortURLsFilterHandler(t *testing.T) {
+	mockSvc := new(mockService)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost: "http://127.0.0.1",
+		Service:    mockSvc,
+		AdminToken: "1234",
+	})
+
+	from := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	minHitCount := int64(5)
+	deleted, blocked := false, true
+	mockSvc.On("FindURLs", &service.FindParams{
+		Size:      30,
+		WithCount: true,
+		Sort:      "-hitCount",
+		Filter: &service.FilterParams{
+			URL:         "blog",
+			CreatedFrom: &from,
+			Expiry:      service.ExpiryActive,
+			Deleted:     &deleted,
+			Blocked:     &blocked,
+			MinHitCount: &minHitCount,
+		},
+	}).Return(&service.Result{Data: []*service.ShortURL{}}, nil)
+	mockSvc.On("FindURLs", &service.FindParams{
+		Size:      30,
+		WithCount: true,
+		Sort:      "fullUrl",
+		Filter:    &service.FilterParams{},
+	}).Return((*service.Result)(nil), service.ErrInvalidSort)
+
+	type test struct {
+		query  string
+		status int
+		resp   string
+	}
+
+	tests := []test{
+		{
+			query:  "url=blog&createdFrom=2021-12-01T00:00:00Z&expiry=active&deleted=false&blocked=true&minHitCount=5&sort=-hitCount",
+			status: 200,
+			resp:   `{"data":[]}`,
+		},
+		{
+			query:  "sort=fullUrl",
+			status: 400,
+			resp:   `{"error":["invalid sort"]}`,
+		},
+		{
+			query:  "createdTo=yesterday",
+			status: 400,
+			resp:   `{"error":["invalid createdTo"]}`,
+		},
+		{
+			query:  "maxHitCount=many",
+			status: 400,
+			resp:   `{"error":["invalid maxHitCount"]}`,
+		},
+		{
+			query:  "deleted=maybe",
+			status: 400,
+			resp:   `{"error":["invalid deleted"]}`,
+		},
+		{
+			query:  "blocked=maybe",
+			status: 400,
+			resp:   `{"error":["invalid blocked"]}`,
+		},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest("GET", "/admin/shortUrls?"+tc.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Authorization", "Bearer 1234")
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code: expected %v, got %v", tc.status, status)
+		}
+		if body := strings.TrimSpace(r.Body.String()); body != tc.resp {
+			t.Errorf("handler returned wrong response: expected %v, got %v", tc.resp, body)
+		}
+	}
+
+	mockSvc.AssertExpectations(t)
+}
+
+func TestAdminUpdateShortURL(t *testing.T) {
+	mockSvc := new(mockService)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost: "http://127.0.0.1",
+		Service:    mockSvc,
+		AdminToken: "1234",
+	})
+
+	newURL := "http://example1.com"
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockSvc.On("Update", "123", service.UpdateShortURLInput{URL: &newURL}).
+		Return(&service.ShortURL{Code: "123", FullURL: newURL}, nil)
+	mockSvc.On("Update", "123", service.UpdateShortURLInput{ExpiresAt: &expiresAt}).
+		Return(&service.ShortURL{Code: "123", FullURL: "http://example.com", ExpiresAt: &expiresAt}, nil)
+	mockSvc.On("Update", "123", service.UpdateShortURLInput{ClearExpiresAt: true}).
+		Return(&service.ShortURL{Code: "123", FullURL: "http://example.com"}, nil)
+	mockSvc.On("Update", "456", service.UpdateShortURLInput{URL: &newURL}).
+		Return(nil, service.ErrRecordNotFound)
+
+	type test struct {
+		code   string
+		body   string
+		status int
+		resp   string
+	}
+
+	tests := []test{
+		{
+			code:   "123",
+			body:   `{"url":"http://example1.com"}`,
+			status: 200,
+			resp:   `{"fullUrl":"http://example1.com","code":"123","hitCount":0}`,
+		},
+		{
+			code:   "123",
+			body:   `{"expiresAt":"2030-01-01T00:00:00Z"}`,
+			status: 200,
+			resp:   `{"fullUrl":"http://example.com","code":"123","hitCount":0,"expiresAt":"2030-01-01T00:00:00Z"}`,
+		},
+		{
+			code:   "123",
+			body:   `{"expiresAt":null}`,
+			status: 200,
+			resp:   `{"fullUrl":"http://example.com","code":"123","hitCount":0}`,
+		},
+		{
+			code:   "123",
+			body:   `{"expiresAt":"tomorrow"}`,
+			status: 400,
+			resp:   `{"error":["invalid expires at"]}`,
+		},
+		{
+			code:   "123",
+			body:   `not json`,
+			status: 400,
+			resp:   `{"error":["invalid request body"]}`,
+		},
+		{code: "456", body: `{"url":"http://example1.com"}`, status: 404, resp: ""},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest("PATCH", "/admin/shortUrls/"+tc.code, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Authorization", "Bearer 1234")
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code: expected %v, got %v", tc.status, status)
+		}
+		if body := strings.TrimSpace(r.Body.String()); body != tc.resp {
+			t.Errorf("handler returned wrong response: expected %v, got %v", tc.resp, body)
+		}
+	}
+
+	mockSvc.AssertExpectations(t)
+}
+
 func TestAdminDeleteShortURL(t *testing.T) {
 	mockSvc := new(mockService)
 	h := NewHTTPHandler(HTTPConfig{
@@ -297,6 +636,7 @@ func TestAdminDeleteShortURL(t *testing.T) {
 
 	mockSvc.On("Delete", "123").Return(nil)
 	mockSvc.On("Delete", "456").Return(service.ErrRecordNotFound)
+	mockSvc.On("Purge", "789").Return(nil)
 
 	type test struct {
 		code   string
@@ -308,6 +648,7 @@ func TestAdminDeleteShortURL(t *testing.T) {
 		{code: "123", status: 204, token: "1234"},
 		{code: "456", status: 404, token: "1234"},
 		{code: "123", status: 403, token: "invalid"},
+		{code: "789?hard=true", status: 204, token: "1234"},
 	}
 
 	for _, tc := range tests {
@@ -327,3 +668,599 @@ func TestAdminDeleteShortURL(t *testing.T) {
 
 	mockSvc.AssertExpectations(t)
 }
+
+func TestAdminRestoreShortURL(t *testing.T) {
+	mockSvc := new(mockService)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost: "http://127.0.0.1",
+		Service:    mockSvc,
+		AdminToken: "1234",
+	})
+
+	mockSvc.On("Restore", "123").Return(nil)
+	mockSvc.On("Restore", "456").Return(service.ErrRecordNotFound)
+
+	type test struct {
+		code   string
+		status int
+	}
+
+	tests := []test{
+		{code: "123", status: 204},
+		{code: "456", status: 404},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest("POST", "/admin/shortUrls/"+tc.code+"/restore", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Authorization", "Bearer 1234")
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code: expected %v, got %v", tc.status, status)
+		}
+	}
+
+	mockSvc.AssertExpectations(t)
+}
+
+func TestAdminPurgeShortURLs(t *testing.T) {
+	mockSvc := new(mockService)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost: "http://127.0.0.1",
+		Service:    mockSvc,
+		AdminToken: "1234",
+	})
+
+	mockSvc.On("PurgeDeleted", mock.MatchedBy(func(t time.Time) bool {
+		d := time.Since(t) - 30*24*time.Hour
+		return d >= 0 && d < time.Minute
+	})).Return(3, nil)
+
+	type test struct {
+		query  string
+		status int
+		resp   string
+	}
+
+	tests := []test{
+		{query: "olderThanDays=30", status: 200, resp: `{"purgedCount":3}`},
+		{query: "", status: 400, resp: `{"error":["invalid olderThanDays"]}`},
+		{query: "olderThanDays=-1", status: 400, resp: `{"error":["invalid olderThanDays"]}`},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest("DELETE", "/admin/shortUrls?"+tc.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Authorization", "Bearer 1234")
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code: expected %v, got %v", tc.status, status)
+		}
+		if body := strings.TrimSpace(r.Body.String()); body != tc.resp {
+			t.Errorf("handler returned wrong response: expected %v, got %v", tc.resp, body)
+		}
+	}
+
+	mockSvc.AssertExpectations(t)
+}
+
+func TestGetFullURLHandlerRecordClick(t *testing.T) {
+	mockSvc := new(mockService)
+	mockAnalytics := new(mockAnalytics)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost: "http://127.0.0.1",
+		Service:    mockSvc,
+		Analytics:  mockAnalytics,
+
+		TrustedProxies: []*net.IPNet{mustParseCIDR("10.0.0.0/8")},
+	})
+
+	mockSvc.On("GetFullURL", "123").Return("http://example.com", nil)
+	mockSvc.On("GetFullURL", "456").Return("", service.ErrShortURLExpired)
+	mockAnalytics.On("RecordClick", "123", service.ClickInput{
+		Referrer:       "http://referrer.com",
+		UserAgent:      "curl/7.79.1",
+		ClientIP:       "203.0.113.7",
+		AcceptLanguage: "en-US",
+	}).Return(nil).Once()
+
+	for _, code := range []string{"123", "456"} {
+		req, err := http.NewRequest("GET", "/"+code, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Referer", "http://referrer.com")
+		req.Header.Set("User-Agent", "curl/7.79.1")
+		req.Header.Set("Accept-Language", "en-US")
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+		req.RemoteAddr = "10.0.0.3:52000"
+
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	mockSvc.AssertExpectations(t)
+	mockAnalytics.AssertExpectations(t)
+}
+
+func TestGetClientIP(t *testing.T) {
+	trusted := []*net.IPNet{mustParseCIDR("10.0.0.0/8"), mustParseCIDR("::1/128")}
+	tests := []struct {
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{remoteAddr: "203.0.113.7:52000", want: "203.0.113.7"},
+		// header of an untrusted peer is ignored
+		{remoteAddr: "203.0.113.7:52000", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{remoteAddr: "10.0.0.3:52000", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		// address prepended by the client is skipped
+		{remoteAddr: "10.0.0.3:52000", forwarded: []string{"198.51.100.1, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{remoteAddr: "[::1]:52000", forwarded: []string{"198.51.100.1", "203.0.113.7"}, want: "203.0.113.7"},
+		// only trusted proxies, the farthest is the client
+		{remoteAddr: "10.0.0.3:52000", forwarded: []string{"10.0.0.1, 10.0.0.2"}, want: "10.0.0.1"},
+		{remoteAddr: "10.0.0.3:52000", want: "10.0.0.3"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/123", nil)
+		req.RemoteAddr = tc.remoteAddr
+		for _, val := range tc.forwarded {
+			req.Header.Add("X-Forwarded-For", val)
+		}
+		if got := getClientIP(req, trusted); got != tc.want {
+			t.Errorf("%v %v: expected: %v, got: %v", tc.remoteAddr, tc.forwarded, tc.want, got)
+		}
+	}
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func TestAdminListClicksHandler(t *testing.T) {
+	mockSvc := new(mockService)
+	mockAnalytics := new(mockAnalytics)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost: "http://127.0.0.1",
+		Service:    mockSvc,
+		Analytics:  mockAnalytics,
+		AdminToken: "1234",
+	})
+
+	from := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2021, 12, 2, 0, 0, 0, 0, time.UTC)
+	mockAnalytics.On("FindClicks", "123", &service.FindClicksParams{
+		Offset: 0,
+		Size:   10,
+		Filter: &service.ClickFilterParams{From: &from},
+	}).Return(&service.ClickResult{
+		Data:       []*service.ClickEvent{{Code: "123", Referrer: "http://referrer.com", CreatedAt: createdAt}},
+		TotalCount: 1,
+	}, nil)
+
+	type test struct {
+		query  string
+		status int
+		resp   string
+	}
+
+	tests := []test{
+		{
+			query:  "size=10&from=2021-12-01T00:00:00Z",
+			status: 200,
+			resp:   `{"data":[{"code":"123","referrer":"http://referrer.com","userAgent":"","userAgentFamily":"","ipHash":"","acceptLanguage":"","createdAt":"2021-12-02T00:00:00Z"}],"totalCount":1}`,
+		},
+		{
+			query:  "to=yesterday",
+			status: 400,
+			resp:   `{"error":["invalid to"]}`,
+		},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest("GET", "/admin/shortUrls/123/clicks?"+tc.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Authorization", "Bearer 1234")
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code: expected %v, got %v", tc.status, status)
+		}
+		if body := strings.TrimSpace(r.Body.String()); body != tc.resp {
+			t.Errorf("handler returned wrong response: expected %v, got %v", tc.resp, body)
+		}
+	}
+
+	mockAnalytics.AssertExpectations(t)
+}
+
+func TestAdminClickStatsHandler(t *testing.T) {
+	mockSvc := new(mockService)
+	mockAnalytics := new(mockAnalytics)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost: "http://127.0.0.1",
+		Service:    mockSvc,
+		Analytics:  mockAnalytics,
+		AdminToken: "1234",
+	})
+
+	from := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 12, 2, 0, 0, 0, 0, time.UTC)
+	mockAnalytics.On("Stats", "123", service.StatsParams{
+		Interval: "hour",
+		Filter:   &service.ClickFilterParams{From: &from, To: &to},
+	}).Return(&service.ClickStats{
+		TotalClicks:    2,
+		UniqueVisitors: 1,
+		Buckets:        []*service.StatsBucket{{Time: from, Clicks: 2, UniqueVisitors: 1}},
+		TopReferrers:   []*service.StatsCount{{Value: "http://referrer.com", Count: 2}},
+		TopUserAgents:  []*service.StatsCount{{Value: "Chrome", Count: 2}},
+	}, nil)
+	mockAnalytics.On("Stats", "123", service.StatsParams{
+		Interval: "minute",
+		Filter:   &service.ClickFilterParams{},
+	}).Return(nil, service.ErrInvalidInterval)
+
+	type test struct {
+		query  string
+		status int
+		resp   string
+	}
+
+	tests := []test{
+		{
+			query:  "interval=hour&from=2021-12-01T00:00:00Z&to=2021-12-02T00:00:00Z",
+			status: 200,
+			resp: `{"totalClicks":2,"uniqueVisitors":1,` +
+				`"buckets":[{"time":"2021-12-01T00:00:00Z","clicks":2,"uniqueVisitors":1}],` +
+				`"topReferrers":[{"value":"http://referrer.com","count":2}],` +
+				`"topUserAgents":[{"value":"Chrome","count":2}]}`,
+		},
+		{query: "interval=minute", status: 400, resp: `{"error":["invalid interval"]}`},
+		{query: "from=today", status: 400, resp: `{"error":["invalid from"]}`},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest("GET", "/admin/shortUrls/123/stats?"+tc.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Authorization", "Bearer 1234")
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code: expected %v, got %v", tc.status, status)
+		}
+		if body := strings.TrimSpace(r.Body.String()); body != tc.resp {
+			t.Errorf("handler returned wrong response: expected %v, got %v", tc.resp, body)
+		}
+	}
+
+	mockAnalytics.AssertExpectations(t)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	var deadline time.Time
+	h := timeoutMiddleware(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+	}))
+
+	req, err := http.NewRequest("GET", "/123", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if d := time.Until(deadline); d <= 0 || d > time.Minute {
+		t.Errorf("expected request context deadline within a minute, got: %v", deadline)
+	}
+}
+
+func TestParseAdminTokens(t *testing.T) {
+	tokens, err := ParseAdminTokens(" alice:1234, bob:ab:cd,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"1234": "alice", "ab:cd": "bob"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+
+	for _, conf := range []string{"1234", "alice:", ":1234", "alice:1234,bob"} {
+		if _, err := ParseAdminTokens(conf); err == nil {
+			t.Errorf("%q: expected error", conf)
+		}
+	}
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	tokens, err := ParseAdminTokens("alice:1234,bob:5678,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		conf   HTTPConfig
+		token  string
+		status int
+		name   string
+	}
+
+	tests := []test{
+		{conf: HTTPConfig{AdminToken: "abc:xyz", AdminTokens: tokens}, token: "1234", status: 200, name: "alice"},
+		{conf: HTTPConfig{AdminToken: "abc:xyz", AdminTokens: tokens}, token: "5678", status: 200, name: "bob"},
+		{conf: HTTPConfig{AdminToken: "abc:xyz", AdminTokens: tokens}, token: "abc:xyz", status: 200, name: "admin"},
+		{conf: HTTPConfig{AdminToken: "abc:xyz", AdminTokens: tokens}, token: "xyz", status: 403},
+		{conf: HTTPConfig{AdminToken: "abc:xyz", AdminTokens: tokens}, token: "", status: 403},
+		{conf: HTTPConfig{AdminTokens: tokens}, token: "", status: 403},
+		{conf: HTTPConfig{AdminTokens: map[string]string{"": "alice"}}, token: "", status: 403},
+		{conf: HTTPConfig{}, token: "", status: 403},
+	}
+
+	for _, tc := range tests {
+		var name string
+		h := httpAdminAuthMiddleware(adminTokens(tc.conf))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name = adminName(r)
+		}))
+
+		req, err := http.NewRequest("GET", "/admin/shortUrls", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tc.token != "" {
+			req.Header.Add("Authorization", "Bearer "+tc.token)
+		}
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("token %q: handler returned wrong status code: expected %v, got %v", tc.token, tc.status, status)
+		}
+		if name != tc.name {
+			t.Errorf("token %q: expected admin name %q, got %q", tc.token, tc.name, name)
+		}
+	}
+}
+
+func TestAdminCacheStatsHandler(t *testing.T) {
+	ctx := context.Background()
+	repo := service.WithCache(service.NewMemoryRepository(), service.NewMemoryCacheStore())
+	repo.CreateShortURL(ctx, &service.ShortURL{FullURL: "http://example.com", Domain: "example.com", Code: "123"})
+	for _, code := range []string{"123", "123", "456", "456"} {
+		repo.FindShortURL(ctx, code)
+	}
+
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost: "http://127.0.0.1",
+		Service:    new(mockService),
+		Cache:      repo,
+		AdminToken: "1234",
+	})
+
+	req, err := http.NewRequest("GET", "/admin/cache/stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer 1234")
+	r := httptest.NewRecorder()
+	h.ServeHTTP(r, req)
+
+	if status := r.Code; status != 200 {
+		t.Errorf("handler returned wrong status code: expected %v, got %v", 200, status)
+	}
+	expected := `{"hits":1,"staleHits":0,"notFoundHits":1,"misses":2,"invalidations":1,"remoteInvalidations":0,"publishErrors":0,"saveErrors":0,"resets":0}`
+	if body := strings.TrimSpace(r.Body.String()); body != expected {
+		t.Errorf("handler returned wrong response: expected %v, got %v", expected, body)
+	}
+}
+
+func TestAdminBlacklistHandler(t *testing.T) {
+	mockBlacklist := new(mockBlacklist)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost:  "http://127.0.0.1",
+		Service:     new(mockService),
+		Blacklist:   mockBlacklist,
+		AdminToken:  "5678",
+		AdminTokens: map[string]string{"1234": "alice"},
+	})
+
+	createdAt := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	rule := &service.BlacklistRule{
+		Id:        1,
+		Type:      service.RuleTypeRegex,
+		Pattern:   `sample\\.com`,
+		Reason:    "phishing",
+		CreatedBy: "alice",
+		CreatedAt: createdAt,
+	}
+	mockBlacklist.On("Rules").Return([]*service.BlacklistRule{rule}, nil)
+	mockBlacklist.On("AddRule", service.BlacklistRuleInput{
+		Pattern:   `sample\\.com`,
+		Reason:    "phishing",
+		CreatedBy: "alice",
+	}).Return(rule, nil)
+	mockBlacklist.On("AddRule", service.BlacklistRuleInput{Pattern: "(", CreatedBy: "alice"}).Return(nil, service.ErrInvalidPattern)
+	mockBlacklist.On("AddRule", service.BlacklistRuleInput{Type: "glob", Pattern: "*", CreatedBy: "alice"}).Return(nil, service.ErrInvalidRuleType)
+	domainRule := &service.BlacklistRule{Id: 2, Type: service.RuleTypeDomain, Pattern: "evil.com", CreatedAt: createdAt}
+	mockBlacklist.On("AddRule", service.BlacklistRuleInput{Type: "domain", Pattern: "evil.com", CreatedBy: "alice"}).Return(domainRule, nil)
+	mockBlacklist.On("DeleteRule", int64(1)).Return(nil)
+	mockBlacklist.On("DeleteRule", int64(2)).Return(service.ErrRecordNotFound)
+
+	ruleJSON := `{"id":1,"type":"regex","pattern":"sample\\\\.com","reason":"phishing","createdBy":"alice","createdAt":"2021-12-01T00:00:00Z"}`
+	domainRuleJSON := `{"id":2,"type":"domain","pattern":"evil.com","reason":"","createdBy":"","createdAt":"2021-12-01T00:00:00Z"}`
+	type test struct {
+		method string
+		path   string
+		body   string
+		status int
+		resp   string
+	}
+
+	tests := []test{
+		{method: "GET", path: "/admin/blacklist", status: 200, resp: `{"data":[` + ruleJSON + `]}`},
+		{
+			method: "POST",
+			path:   "/admin/blacklist",
+			body:   `{"pattern":"sample\\\\.com","reason":"phishing","createdBy":"mallory"}`,
+			status: 201,
+			resp:   ruleJSON,
+		},
+		{
+			method: "POST",
+			path:   "/admin/blacklist",
+			body:   `{"type":"domain","pattern":"evil.com"}`,
+			status: 201,
+			resp:   domainRuleJSON,
+		},
+		{method: "POST", path: "/admin/blacklist", body: `{"pattern":"("}`, status: 400, resp: `{"error":["invalid pattern"]}`},
+		{method: "POST", path: "/admin/blacklist", body: `{"type":"glob","pattern":"*"}`, status: 400, resp: `{"error":["invalid rule type"]}`},
+		{method: "POST", path: "/admin/blacklist", body: `{`, status: 400, resp: `{"error":["invalid request body"]}`},
+		{method: "DELETE", path: "/admin/blacklist/1", status: 204},
+		{method: "DELETE", path: "/admin/blacklist/2", status: 404},
+		{method: "DELETE", path: "/admin/blacklist/abc", status: 400, resp: `{"error":["invalid id"]}`},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Authorization", "Bearer 1234")
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("%s %s: handler returned wrong status code: expected %v, got %v", tc.method, tc.path, tc.status, status)
+		}
+		if body := strings.TrimSpace(r.Body.String()); body != tc.resp {
+			t.Errorf("%s %s: handler returned wrong response: expected %v, got %v", tc.method, tc.path, tc.resp, body)
+		}
+	}
+
+	mockBlacklist.AssertExpectations(t)
+}
+
+func TestAdminBlacklistScanHandler(t *testing.T) {
+	mockScanner := new(mockBlacklistScanner)
+	mockBlacklist := new(mockBlacklist)
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost:       "http://127.0.0.1",
+		Service:          new(mockService),
+		Blacklist:        mockBlacklist,
+		BlacklistScanner: mockScanner,
+		AdminToken:       "1234",
+	})
+
+	at := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	rule := &service.BlacklistRule{Id: 1, Type: service.RuleTypeDomain, Pattern: "evil.com", CreatedAt: at}
+	report := &service.BlacklistScanReport{
+		StartedAt:  at,
+		FinishedAt: at,
+		Scanned:    10,
+		Affected:   []*service.BlacklistScanMatch{{Code: "123", FullURL: "http://evil.com", Rule: rule}},
+		Cleared:    []string{"456"},
+	}
+	mockScanner.On("LastReport").Return(nil).Once()
+	mockScanner.On("Scan").Return(report, nil).Once()
+	mockScanner.On("Scan").Return(nil, context.DeadlineExceeded).Once()
+	mockScanner.On("LastReport").Return(report).Once()
+	// adding a rule trigger a scan
+	mockBlacklist.On("AddRule", service.BlacklistRuleInput{Type: "domain", Pattern: "evil.com", CreatedBy: "admin"}).Return(rule, nil)
+	mockScanner.On("Trigger").Once()
+
+	reportJSON := `{"startedAt":"2021-12-01T00:00:00Z","finishedAt":"2021-12-01T00:00:00Z","scanned":10,` +
+		`"affected":[{"code":"123","fullUrl":"http://evil.com","rule":{"id":1,"type":"domain","pattern":"evil.com","reason":"","createdBy":"","createdAt":"2021-12-01T00:00:00Z"},"disabled":false}],` +
+		`"cleared":["456"]}`
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		resp   string
+	}{
+		{method: "GET", path: "/admin/blacklist/scan", status: 404, resp: `{"error":["no scan completed yet"]}`},
+		{method: "POST", path: "/admin/blacklist/scan", status: 200, resp: reportJSON},
+		{method: "POST", path: "/admin/blacklist/scan", status: 503, resp: `{"error":["request timeout"]}`},
+		{method: "GET", path: "/admin/blacklist/scan", status: 200, resp: reportJSON},
+		{method: "POST", path: "/admin/blacklist", body: `{"type":"domain","pattern":"evil.com"}`, status: 201},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Add("Authorization", "Bearer 1234")
+		r := httptest.NewRecorder()
+		h.ServeHTTP(r, req)
+
+		if status := r.Code; status != tc.status {
+			t.Errorf("%s %s: handler returned wrong status code: expected %v, got %v", tc.method, tc.path, tc.status, status)
+		}
+		if body := strings.TrimSpace(r.Body.String()); tc.resp != "" && body != tc.resp {
+			t.Errorf("%s %s: handler returned wrong response: expected %v, got %v", tc.method, tc.path, tc.resp, body)
+		}
+	}
+
+	mockScanner.AssertExpectations(t)
+}
+
+func TestAdminBlacklistFeedsHandler(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hosts.txt"), []byte("0.0.0.0 evil.com bad.com\n0.0.0.0 bad/host\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	feeds, err := service.NewBlacklistFeeds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feeds.Close()
+
+	h := NewHTTPHandler(HTTPConfig{
+		ServerHost:     "http://127.0.0.1",
+		Service:        new(mockService),
+		BlacklistFeeds: feeds,
+		AdminToken:     "1234",
+	})
+
+	req, err := http.NewRequest("GET", "/admin/blacklist/feeds", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer 1234")
+	r := httptest.NewRecorder()
+	h.ServeHTTP(r, req)
+
+	if status := r.Code; status != 200 {
+		t.Errorf("handler returned wrong status code: expected %v, got %v", 200, status)
+	}
+	var stats service.BlacklistFeedsStats
+	if err := json.NewDecoder(r.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Loaded != 2 || stats.Invalid != 1 || len(stats.Feeds) != 1 || stats.Feeds[0].File != "hosts.txt" {
+		t.Errorf("handler returned wrong response: %+v", stats)
+	}
+}